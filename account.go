@@ -0,0 +1,530 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cicovic-andrija/libgo/logging"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Accounts are persisted in the data directory, next to the dive log, with passwords hashed by bcrypt. Sessions are
+// kept in memory only, so users sign in again after the server restarts.
+
+const (
+	AccountsFileName     = "accounts.json"
+	TempAccountsFileName = "accounts.tmp.json"
+	AccountsLockFileName = "accounts.lock"
+	AccountsLockTimeout  = 10 * time.Second // a lock file older than this was left behind by a crashed process
+
+	SessionCookieName = "ddhs_session"
+	SessionLifetime   = 7 * 24 * time.Hour
+	MinPasswordLength = 8
+
+	MaxFailedAttempts   = 5                // failed password checks per key within the window, before further ones are refused
+	FailedAttemptWindow = 15 * time.Minute //
+
+	UsernameTag        = "username"
+	AccountNameTag     = "name"
+	PasswordTag        = "password"
+	PasswordConfirmTag = "password_confirm"
+	NextTag            = "next"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrAccountExists      = errors.New("account already exists")
+	ErrTooManyAttempts    = errors.New("too many failed attempts")
+
+	usernamePattern = regexp.MustCompile(`^[a-z0-9._-]{3,32}$`)
+
+	// PublicRoutes are accessible without signing in; all other routes are protected by `AuthMux`.
+	PublicRoutes = []string{"GET /login", "POST /login", "POST /api/verify", "GET /api/instructors/{username}/key"}
+
+	Users = NewAccounts()
+
+	// VerificationAttempts throttles instructors' password checks on divers' pages (see `diveVerificationHandler`).
+	VerificationAttempts = NewAttempts()
+)
+
+// Account is a user of the server.
+type Account struct {
+	Username     string `json:"username"`
+	Name         string `json:"name"` // display name
+	PasswordHash string `json:"password_hash"`
+	Admin        bool   `json:"admin,omitempty"`       // can view aggregated stats of all accounts
+	Instructor   bool   `json:"instructor,omitempty"`  // can verify dives (see `Verification`)
+	SigningKey   string `json:"signing_key,omitempty"` // ed25519 seed, base64; generated when the account becomes an instructor
+}
+
+type session struct {
+	account *Account
+	expires time.Time
+	csrf    string // token required on mutating requests (see `verifyCSRF`)
+}
+
+// Accounts holds user accounts and their sessions. Unlike `DiveLog`, its functions are thread-safe.
+type Accounts struct {
+	sync.RWMutex
+
+	accounts  map[string]*Account // by username
+	sessions  map[string]*session // by session token
+	changed   map[string]int      // number of changes of accounts since they were last saved, by username
+	dummyHash []byte              // compared against for unknown usernames, so they take as long as wrong passwords
+	dummyOnce sync.Once
+}
+
+type PersistedAccounts struct {
+	Accounts []*Account `json:"accounts"`
+}
+
+type (
+	accountKey struct{}
+	logKey     struct{}
+	csrfKey    struct{}
+)
+
+func NewAccounts() *Accounts {
+	return &Accounts{
+		accounts: make(map[string]*Account),
+		sessions: make(map[string]*session),
+		changed:  make(map[string]int),
+	}
+}
+
+// Empty reports whether there are no accounts yet, in which case the first one can be created by anyone who reaches
+// the sign-in page.
+func (a *Accounts) Empty() bool {
+	a.RLock()
+	defer a.RUnlock()
+	return len(a.accounts) == 0
+}
+
+func (a *Accounts) Find(username string) *Account {
+	a.RLock()
+	defer a.RUnlock()
+	return a.accounts[username]
+}
+
+// All returns all accounts, ordered by username.
+func (a *Accounts) All() []*Account {
+	a.RLock()
+	defer a.RUnlock()
+	all := make([]*Account, 0, len(a.accounts))
+	for _, account := range a.accounts {
+		all = append(all, account)
+	}
+	slices.SortFunc(all, func(x, y *Account) int { return strings.Compare(x.Username, y.Username) })
+	return all
+}
+
+// Add creates an account with the password hashed. The first account is an admin. Input must be validated by the
+// caller. The caller is responsible for persisting accounts (see `saveAccounts`).
+func (a *Accounts) Add(username string, name string, password string) (*Account, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("password hashing failed: %v", err)
+	}
+
+	a.Lock()
+	defer a.Unlock()
+	if a.accounts[username] != nil {
+		return nil, ErrAccountExists
+	}
+	account := &Account{Username: username, Name: name, PasswordHash: string(hash), Admin: len(a.accounts) == 0}
+	a.accounts[username] = account
+	a.changed[username]++
+	return account, nil
+}
+
+// SetInstructor grants or revokes the instructor role. A signing key is generated on first grant, and kept if the role
+// is revoked, so dives verified before remain verifiable. Accounts are read without the lock once found, so the
+// account is replaced by a modified copy, also in its sessions, instead of being modified.
+func (a *Accounts) SetInstructor(username string, instructor bool) error {
+	a.Lock()
+	defer a.Unlock()
+	existing := a.accounts[username]
+	if existing == nil {
+		return fmt.Errorf("account %q not found", username)
+	}
+	account := *existing
+	if instructor && account.SigningKey == "" {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return fmt.Errorf("signing key generation failed: %v", err)
+		}
+		account.SigningKey = base64.StdEncoding.EncodeToString(key.Seed())
+	}
+	account.Instructor = instructor
+	a.replace(existing, &account)
+	a.changed[username]++
+	return nil
+}
+
+// replace stores the account in place of the existing one, if any, also in its sessions. The caller must hold the
+// lock.
+func (a *Accounts) replace(existing *Account, account *Account) {
+	a.accounts[account.Username] = account
+	if existing == nil {
+		return
+	}
+	for _, s := range a.sessions {
+		if s.account == existing {
+			s.account = account
+		}
+	}
+}
+
+// merge adopts the persisted accounts, except those changed since they were last saved, and returns all accounts along
+// with the numbers of their changes, to be marked as saved (see `saved`). Accounts may be persisted by another process
+// (see `addAccount`), so they are merged before they are saved.
+func (a *Accounts) merge(persisted []*Account) (all []*Account, changes map[string]int) {
+	a.Lock()
+	for _, account := range persisted {
+		if existing := a.accounts[account.Username]; a.changed[account.Username] == 0 && (existing == nil || *existing != *account) {
+			a.replace(existing, account)
+		}
+	}
+	changes = maps.Clone(a.changed)
+	a.Unlock()
+	return a.All(), changes
+}
+
+// saved marks accounts as saved, unless they were changed again since they were merged.
+func (a *Accounts) saved(changes map[string]int) {
+	a.Lock()
+	defer a.Unlock()
+	for username, n := range changes {
+		if a.changed[username] == n {
+			delete(a.changed, username)
+		}
+	}
+}
+
+// Authenticate returns the account if the password matches, or `ErrInvalidCredentials`.
+func (a *Accounts) Authenticate(username string, password string) (*Account, error) {
+	account := a.Find(username)
+	if account == nil {
+		a.dummyOnce.Do(func() {
+			a.dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+		})
+		bcrypt.CompareHashAndPassword(a.dummyHash, []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+	if bcrypt.CompareHashAndPassword([]byte(account.PasswordHash), []byte(password)) != nil {
+		return nil, ErrInvalidCredentials
+	}
+	return account, nil
+}
+
+// Attempts counts failed password checks by key (e.g. an account or a session), so guessing can be throttled: once
+// there were `MaxFailedAttempts` of them for a key within the `FailedAttemptWindow`, further checks are refused until
+// the earliest one is out of the window.
+type Attempts struct {
+	sync.Mutex
+	failed map[string][]time.Time // times of recent failed checks, by key, oldest first
+}
+
+func NewAttempts() *Attempts {
+	return &Attempts{failed: make(map[string][]time.Time)}
+}
+
+// Allowed reports whether a password check is allowed for all of the keys.
+func (a *Attempts) Allowed(now time.Time, keys ...string) bool {
+	a.Lock()
+	defer a.Unlock()
+	for _, key := range keys {
+		if len(a.recent(key, now)) >= MaxFailedAttempts {
+			return false
+		}
+	}
+	return true
+}
+
+// Fail records a failed password check for each of the keys.
+func (a *Attempts) Fail(now time.Time, keys ...string) {
+	a.Lock()
+	defer a.Unlock()
+	for _, key := range keys {
+		a.failed[key] = append(a.recent(key, now), now)
+	}
+}
+
+// recent drops failed checks for the key that are out of the window, and returns the rest.
+func (a *Attempts) recent(key string, now time.Time) []time.Time {
+	failed := a.failed[key]
+	for len(failed) > 0 && now.Sub(failed[0]) >= FailedAttemptWindow {
+		failed = failed[1:]
+	}
+	if len(failed) == 0 {
+		delete(a.failed, key)
+	} else {
+		a.failed[key] = failed
+	}
+	return failed
+}
+
+// NewSession starts a session for the account, and returns its (secret) token.
+func (a *Accounts) NewSession(account *Account, now time.Time) (token string, expires time.Time) {
+	token = randomToken()
+	expires = now.Add(SessionLifetime)
+
+	a.Lock()
+	defer a.Unlock()
+	a.sessions[token] = &session{account: account, expires: expires, csrf: randomToken()}
+	return
+}
+
+// CSRFToken returns the CSRF token of the session, or an empty string if the session doesn't exist.
+func (a *Accounts) CSRFToken(token string) string {
+	a.RLock()
+	defer a.RUnlock()
+	if s := a.sessions[token]; s != nil {
+		return s.csrf
+	}
+	return ""
+}
+
+// randomToken returns a random, URL-safe token with 256 bits of entropy.
+func randomToken() string {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		panic(fmt.Errorf("crypto/rand failed: %v", err))
+	}
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// Session returns the account signed in with the session token, or nil if the session doesn't exist or has expired.
+func (a *Accounts) Session(token string, now time.Time) *Account {
+	a.Lock()
+	defer a.Unlock()
+	s := a.sessions[token]
+	if s == nil {
+		return nil
+	}
+	if !now.Before(s.expires) {
+		delete(a.sessions, token)
+		return nil
+	}
+	return s.account
+}
+
+func (a *Accounts) EndSession(token string) {
+	a.Lock()
+	defer a.Unlock()
+	delete(a.sessions, token)
+}
+
+// PublicKey returns the public key of the account's signing key, or nil if it has none.
+func (account *Account) PublicKey() ed25519.PublicKey {
+	if key := account.privateKey(); key != nil {
+		return key.Public().(ed25519.PublicKey)
+	}
+	return nil
+}
+
+func (account *Account) privateKey() ed25519.PrivateKey {
+	seed, err := base64.StdEncoding.DecodeString(account.SigningKey)
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil
+	}
+	return ed25519.NewKeyFromSeed(seed)
+}
+
+// AccountOf returns the account signed in for the request, or nil outside of routes protected by `AuthMux`.
+func AccountOf(r *http.Request) *Account {
+	account, _ := r.Context().Value(accountKey{}).(*Account)
+	return account
+}
+
+// LogOf returns the dive log of the account signed in for the request, or nil outside of routes protected by
+// `AuthMux`. Handlers only ever see the log of the signed-in account.
+func LogOf(r *http.Request) *DiveLog {
+	mlog, _ := r.Context().Value(logKey{}).(*DiveLog)
+	return mlog
+}
+
+// AuthMux registers handlers with the underlying mux, and requires a signed-in user on all routes except
+// `PublicRoutes`. Mutating requests are protected from cross-site request forgery on all routes.
+type AuthMux struct {
+	Mux HandlerMux
+}
+
+func (am *AuthMux) Handle(pattern string, handler http.Handler) {
+	handler = verifyCSRF(handler)
+	if !slices.Contains(PublicRoutes, pattern) {
+		handler = requireAccount(handler)
+	}
+	am.Mux.Handle(pattern, handler)
+}
+
+// requireAccount is an adapter that passes the request on only if it belongs to a valid session, with the account
+// in the request context. Otherwise, the client is sent to sign in, and back to the requested page afterwards.
+func requireAccount(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var account *Account
+		cookie, err := r.Cookie(SessionCookieName)
+		if err == nil {
+			account = Users.Session(cookie.Value, time.Now())
+		}
+		if account != nil {
+			mlog, err := Logs.Open(account)
+			if err != nil {
+				trace(logging.SevError, "open dive log of %s failed: %v", account.Username, err)
+				http.Error(w, "dive log unavailable", http.StatusInternalServerError)
+				return
+			}
+			ctx := context.WithValue(r.Context(), accountKey{}, account)
+			ctx = context.WithValue(ctx, logKey{}, mlog)
+			ctx = context.WithValue(ctx, csrfKey{}, Users.CSRFToken(cookie.Value))
+			h.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		login := "/login?" + NextTag + "=" + url.QueryEscape(r.URL.RequestURI())
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/api/"):
+			http.Error(w, "sign in required", http.StatusUnauthorized)
+		case r.Header.Get("HX-Request") == "true":
+			w.Header().Set("HX-Redirect", login) // htmx would otherwise swap the sign-in page into the target
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			http.Redirect(w, r, login, http.StatusSeeOther)
+		}
+	})
+}
+
+// sessionToken returns the token of the session the request belongs to, or an empty string.
+func sessionToken(r *http.Request) string {
+	if cookie, err := r.Cookie(SessionCookieName); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+func setSessionCookie(w http.ResponseWriter, token string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// loadAccounts reads accounts from the data directory. A missing file is not an error: there are no accounts yet.
+func loadAccounts() error {
+	persisted, err := readAccounts()
+	if err != nil {
+		return err
+	}
+	Users.merge(persisted)
+	return nil
+}
+
+// readAccounts reads accounts persisted in the data directory, if any.
+func readAccounts() ([]*Account, error) {
+	file, err := os.Open(filepath.Join(DataDirectory, AccountsFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("read accounts operation failed: %v", err)
+	}
+	defer file.Close()
+
+	persisted := &PersistedAccounts{}
+	if err = json.NewDecoder(file).Decode(persisted); err != nil {
+		return nil, fmt.Errorf("decode accounts operation failed: %v", err)
+	}
+	for _, account := range persisted.Accounts {
+		if !usernamePattern.MatchString(account.Username) || account.PasswordHash == "" {
+			return nil, fmt.Errorf("invalid account %q", account.Username)
+		}
+	}
+	return persisted.Accounts, nil
+}
+
+// saveAccounts writes accounts to the data directory, replacing the file atomically. Accounts may be added from the
+// command line while the server runs (see `addAccount`), so the file is locked, and accounts saved by the other process
+// are merged (see `Accounts.merge`) before the file is replaced.
+func saveAccounts() error {
+	unlock, err := lockAccountsFile()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	persisted, err := readAccounts()
+	if err != nil {
+		return err
+	}
+	accounts, changes := Users.merge(persisted)
+
+	tmpPath := filepath.Join(DataDirectory, TempAccountsFileName)
+	tmpFile, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("temp accounts file creation failed: %v", err)
+	}
+	defer os.Remove(tmpPath)
+	defer tmpFile.Close()
+
+	if err = NewEncoder(tmpFile).Encode(&PersistedAccounts{Accounts: accounts}); err != nil {
+		return fmt.Errorf("encode accounts operation failed: %v", err)
+	}
+	if err = os.Rename(tmpPath, filepath.Join(DataDirectory, AccountsFileName)); err != nil {
+		return fmt.Errorf("write accounts operation failed: %v", err)
+	}
+	Users.saved(changes)
+	return nil
+}
+
+// lockAccountsFile takes the lock of the accounts file, shared by all processes, and returns the function that
+// releases it. The lock is a file created exclusively, so it works on any platform.
+func lockAccountsFile() (unlock func(), err error) {
+	path := filepath.Join(DataDirectory, AccountsLockFileName)
+	for deadline := time.Now().Add(AccountsLockTimeout); ; {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			file.Close()
+			return func() { os.Remove(path) }, nil
+		} else if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("accounts lock operation failed: %v", err)
+		}
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > AccountsLockTimeout {
+			os.Remove(path) // left behind by a crashed process
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("accounts lock operation failed: %s is held by another process", path)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
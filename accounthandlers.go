@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cicovic-andrija/libgo/logging"
+)
+
+// HTTPS handler for the sign-in page. Until the first account is created, the page creates it instead.
+func loginPageHandler(w http.ResponseWriter, r *http.Request) {
+	if Users.Empty() {
+		reloadAccounts()
+	}
+	page := &Page{
+		Title: "Sign In",
+		Next:  safeNext(r.FormValue(NextTag)),
+		Setup: Users.Empty(),
+	}
+	if page.Setup {
+		page.Title = "Create Account"
+	}
+	render("login.html", w, r, page)
+}
+
+func loginHandler(w http.ResponseWriter, r *http.Request) {
+	if Users.Find(strings.ToLower(strings.TrimSpace(r.FormValue(UsernameTag)))) == nil {
+		reloadAccounts()
+	}
+	var (
+		page = &Page{
+			Title:       "Sign In",
+			InputErrors: make(map[string]string),
+			Next:        safeNext(r.FormValue(NextTag)),
+			Setup:       Users.Empty(),
+			Username:    r.FormValue(UsernameTag),
+		}
+		account *Account
+		err     error
+	)
+
+	if page.Setup {
+		page.Title = "Create Account"
+		account = createFirstAccount(r, page.InputErrors)
+	} else if account, err = Users.Authenticate(strings.ToLower(strings.TrimSpace(page.Username)), r.FormValue(PasswordTag)); err != nil {
+		page.InputErrors[PasswordTag] = "Wrong username or password."
+	}
+	if account == nil {
+		render("login.html", w, r, page)
+		return
+	}
+
+	token, expires := Users.NewSession(account, time.Now())
+	setSessionCookie(w, token, expires)
+	trace(logging.SevInfo, "user %s signed in", account.Username)
+	http.Redirect(w, r, page.Next, http.StatusSeeOther)
+}
+
+// reloadAccounts reads accounts from the data directory again, as they may have been added from the command line while
+// the server runs (see `addAccount`).
+func reloadAccounts() {
+	if err := loadAccounts(); err != nil {
+		trace(logging.SevError, "load accounts operation failed: %v", err)
+	}
+}
+
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(SessionCookieName); err == nil {
+		Users.EndSession(cookie.Value)
+	}
+	clearSessionCookie(w)
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// createFirstAccount creates and persists an account from the setup form, or returns nil.
+func createFirstAccount(r *http.Request, errorMap map[string]string) *Account {
+	var (
+		ok       = true
+		username string
+		name     string
+	)
+	if value, errMsg := validateUsernameInput(r.FormValue(UsernameTag)); errMsg != "" {
+		ok = false
+		errorMap[UsernameTag] = errMsg
+	} else {
+		username = value
+	}
+	if value, errMsg := validateNonEmptyString(r.FormValue(AccountNameTag)); errMsg != "" {
+		ok = false
+		errorMap[AccountNameTag] = errMsg
+	} else {
+		name = value
+	}
+	if errMsg := validatePasswordInput(r.FormValue(PasswordTag), r.FormValue(PasswordConfirmTag)); errMsg != "" {
+		ok = false
+		errorMap[PasswordTag] = errMsg
+	}
+	if !ok {
+		return nil
+	}
+
+	account, err := Users.Add(username, name, r.FormValue(PasswordTag))
+	if err != nil {
+		errorMap[UsernameTag] = "The account could not be created, please try again."
+		return nil
+	}
+	if err = saveAccounts(); err != nil {
+		trace(logging.SevError, "persistence of accounts failed: %v", err)
+	}
+	return account
+}
+
+// safeNext returns the path to go to after signing in. Only local paths are accepted, so the sign-in page can't be
+// used to redirect elsewhere.
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
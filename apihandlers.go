@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/cicovic-andrija/libgo/logging"
+)
+
+// JSON API. Resources returned by the API are built from the same in-memory model as the hypermedia pages, and list
+// endpoints accept the same URL query (see `DiveQuery`).
+
+// DiveResource is the JSON representation of a dive: its persisted record, extended with computed attributes.
+type DiveResource struct {
+	ID  string `json:"id"`
+	Num int    `json:"num"`
+	*DiveRecord
+}
+
+// DiveListResource is the JSON representation of a single page of dives.
+type DiveListResource struct {
+	Dives      []*DiveResource `json:"dives"`
+	Total      int             `json:"total"`
+	HasMore    bool            `json:"has_more"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+	Fields     []*CustomField  `json:"fields,omitempty"` // definitions of custom fields, whose values dives hold by ID
+}
+
+func NewDiveResource(dive *Dive) *DiveResource {
+	return &DiveResource{
+		ID:         dive.ID(),
+		Num:        dive.Num(),
+		DiveRecord: dive.Data,
+	}
+}
+
+func apiDivesHandler(w http.ResponseWriter, r *http.Request) {
+	query, err := ParseDiveQuery(r.URL.Query())
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	mlog := LogOf(r)
+	mlog.RLock()
+	defer mlog.RUnlock()
+	result := query.Run(mlog)
+
+	resource := &DiveListResource{
+		Dives:  make([]*DiveResource, 0, len(result.Dives)),
+		Total:  result.Total,
+		Fields: CustomFields.All(),
+	}
+	for _, dive := range result.Dives {
+		resource.Dives = append(resource.Dives, NewDiveResource(dive))
+	}
+	if result.Next != nil {
+		resource.HasMore = true
+		resource.NextCursor = result.Next.Encode()
+	}
+
+	writeJSON(w, http.StatusOK, resource)
+}
+
+func apiDiveHandler(w http.ResponseWriter, r *http.Request) {
+	mlog := LogOf(r)
+	mlog.RLock()
+	defer mlog.RUnlock()
+	dive := mlog.Find(r.PathValue(IDTag))
+	if dive == nil {
+		writeJSONError(w, http.StatusNotFound, "dive not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, NewDiveResource(dive))
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := NewEncoder(w).Encode(v); err != nil {
+		trace(logging.SevError, "%v", err)
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
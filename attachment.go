@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/jpeg"
+	_ "image/png" // decoder, for thumbnails
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cicovic-andrija/libgo/logging"
+)
+
+// Attachments (photos, videos, documents) are stored in the log directory, named by the SHA-256 hash of their content,
+// so a file attached to several dives, or uploaded twice, is stored once.
+
+const (
+	AttachmentsDirectory = "attachments" // in the log directory
+	ThumbnailSuffix      = ".thumb.jpg"
+
+	AttachmentTag = "attachment"
+	HashTag       = "hash"
+	MoveToTag     = "to"
+
+	MaxUploadSize      = 512 << 20 // of a single request, which can upload several files
+	MaxAttachmentName  = 255
+	ThumbnailSize      = 320      // px, the longer side
+	MaxThumbnailPixels = 80 << 20 // larger images are not decoded, as they would take too much memory
+
+	// PhotoMatchMargin is how long before a dive starts, or after it ends, a photo is still matched to it.
+	PhotoMatchMargin = 30 * time.Minute
+)
+
+// InlineTypes are MIME types of attachments shown in the browser; other attachments are downloaded.
+var InlineTypes = []string{"image/jpeg", "image/png", "image/gif", "image/webp", "video/mp4", "video/webm"}
+
+// Attachment is a file attached to a dive.
+type Attachment struct {
+	Hash      string `json:"hash"` // SHA-256 of the content, hex; also the name of the stored file
+	Name      string `json:"name"` // original file name
+	Type      string `json:"type"` // MIME type, detected from the content
+	Size      int64  `json:"size"`
+	TakenAt   string `json:"taken_at,omitempty"`  // photos, from EXIF; on-site time, like `DiveRecord.DateTime`
+	Thumbnail bool   `json:"thumbnail,omitempty"` // whether a thumbnail was generated
+}
+
+func (a *Attachment) Image() bool {
+	return strings.HasPrefix(a.Type, "image/")
+}
+
+func (a *Attachment) Video() bool {
+	return strings.HasPrefix(a.Type, "video/")
+}
+
+// Inline reports whether the attachment is safe to show in the browser (see `InlineTypes`).
+func (a *Attachment) Inline() bool {
+	return slices.Contains(InlineTypes, a.Type)
+}
+
+// FormattedSize returns the size of the file, e.g. "2.4 MB".
+func (a *Attachment) FormattedSize() string {
+	switch {
+	case a.Size >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(a.Size)/(1<<20))
+	case a.Size >= 1<<10:
+		return fmt.Sprintf("%.1f kB", float64(a.Size)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", a.Size)
+}
+
+// Taken returns the time the photo was taken, or zero if it is not known.
+func (a *Attachment) Taken() time.Time {
+	t, _ := time.Parse(DateTimeLayout, a.TakenAt)
+	return t
+}
+
+// Attachment returns the dive's attachment with the given hash, or nil.
+func (dr *DiveRecord) Attachment(hash string) *Attachment {
+	if i := slices.IndexFunc(dr.Attachments, func(a *Attachment) bool { return a.Hash == hash }); i >= 0 {
+		return dr.Attachments[i]
+	}
+	return nil
+}
+
+// FormattedAttachments returns names of the dive's attachments, comma-separated.
+func (dr *DiveRecord) FormattedAttachments() string {
+	names := make([]string, 0, len(dr.Attachments))
+	for _, a := range dr.Attachments {
+		names = append(names, a.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+func (dr *DiveRecord) attachmentHashes() []string {
+	hashes := make([]string, 0, len(dr.Attachments))
+	for _, a := range dr.Attachments {
+		hashes = append(hashes, a.Hash)
+	}
+	return hashes
+}
+
+// AttachmentPath returns the path of the stored file with the given hash.
+func (dl *DiveLog) AttachmentPath(hash string) string {
+	return filepath.Join(dl.dir, AttachmentsDirectory, hash)
+}
+
+func (dl *DiveLog) ThumbnailPath(hash string) string {
+	return dl.AttachmentPath(hash) + ThumbnailSuffix
+}
+
+// AttachmentStored reports whether the file of the attachment, and its thumbnail if it has one, are stored.
+func (dl *DiveLog) AttachmentStored(attachment *Attachment) bool {
+	return fileExists(dl.AttachmentPath(attachment.Hash)) &&
+		(!attachment.Thumbnail || fileExists(dl.ThumbnailPath(attachment.Hash)))
+}
+
+// StoreAttachment stores a file, unless a file with the same content is already stored, and returns an attachment
+// for it, with the time a JPEG photo was taken and a thumbnail of a JPEG or PNG image. The file must then be attached
+// to a dive (see `Attach`). It only writes to the log directory, so it can be called without locking the log, but then
+// the file may be removed (see `pruneAttachments`) before it is attached: callers must check that it is still stored
+// once the log is locked (see `AttachmentStored`).
+func (dl *DiveLog) StoreAttachment(name string, content io.Reader) (*Attachment, error) {
+	if dl.dir == "" {
+		return nil, fmt.Errorf("the log is in memory only")
+	}
+	dir := filepath.Join(dl.dir, AttachmentsDirectory)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("attachments directory creation failed: %v", err)
+	}
+	tmpFile, err := os.CreateTemp(dir, "upload-*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("temp attachment file creation failed: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	var (
+		hash = sha256.New()
+		head = make([]byte, 512) // enough to detect the content type
+	)
+	n, err := io.ReadFull(content, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		if err == io.EOF {
+			return nil, fmt.Errorf("file %q is empty", name)
+		}
+		return nil, fmt.Errorf("upload failed: %v", err)
+	}
+	head = head[:n]
+	size, err := io.Copy(io.MultiWriter(tmpFile, hash), io.MultiReader(bytes.NewReader(head), content))
+	if err != nil {
+		return nil, fmt.Errorf("upload failed: %v", err)
+	}
+
+	attachment := &Attachment{
+		Hash: hex.EncodeToString(hash.Sum(nil)),
+		Name: filepath.Base(strings.ReplaceAll(name, "\\", "/")), // some browsers send the whole path
+		Type: http.DetectContentType(head),
+		Size: size,
+	}
+	if len(attachment.Name) > MaxAttachmentName {
+		attachment.Name = strings.ToValidUTF8(attachment.Name[len(attachment.Name)-MaxAttachmentName:], "")
+	}
+	attachment.Type, _, _ = strings.Cut(attachment.Type, ";")
+	path := dl.AttachmentPath(attachment.Hash)
+	if err = os.Rename(tmpFile.Name(), path); err != nil {
+		return nil, fmt.Errorf("write attachment operation failed: %v", err)
+	}
+
+	if attachment.Type == "image/jpeg" {
+		if file, err := os.Open(path); err == nil {
+			if taken, err := ExifTime(file); err == nil {
+				attachment.TakenAt = taken.Format(DateTimeLayout)
+			}
+			file.Close()
+		}
+	}
+	if attachment.Type == "image/jpeg" || attachment.Type == "image/png" {
+		if err = writeThumbnail(path, dl.ThumbnailPath(attachment.Hash)); err != nil {
+			trace(logging.SevWarn, "thumbnail of %s not generated: %v", path, err)
+		} else {
+			attachment.Thumbnail = true
+		}
+	}
+	return attachment, nil
+}
+
+// Attach attaches a stored file to the dive, unless it is already attached.
+func (dl *DiveLog) Attach(dive *Dive, attachment *Attachment) {
+	if dive.Data.Attachment(attachment.Hash) != nil {
+		return
+	}
+	dl.touch(dive.id)
+	dive.Data.Attachments = append(dive.Data.Attachments, attachment)
+	dl.persist()
+}
+
+// Detach removes the attachment from the dive, and the stored file if no other dive, including those in the trash,
+// has it attached. Files are not kept for past revisions of dives (see `Revert`).
+func (dl *DiveLog) Detach(dive *Dive, hash string) (found bool) {
+	n := len(dive.Data.Attachments)
+	dl.touch(dive.id)
+	dive.Data.Attachments = slices.DeleteFunc(dive.Data.Attachments, func(a *Attachment) bool { return a.Hash == hash })
+	if found = len(dive.Data.Attachments) < n; found {
+		dl.pruneAttachments(hash)
+		dl.persist()
+	}
+	return
+}
+
+// MoveAttachment moves the attachment from one dive to another.
+func (dl *DiveLog) MoveAttachment(from *Dive, to *Dive, hash string) (found bool) {
+	attachment := from.Data.Attachment(hash)
+	if attachment == nil {
+		return false
+	}
+	dl.touch(from.id)
+	from.Data.Attachments = slices.DeleteFunc(from.Data.Attachments, func(a *Attachment) bool { return a.Hash == hash })
+	dl.Attach(to, attachment)
+	dl.persist()
+	return true
+}
+
+// SuggestDive returns the dive a photo was likely taken on, according to the time it was taken: the dive in progress
+// at the time, or else a dive that started or ended within `PhotoMatchMargin`. It returns nil if the time is unknown
+// or no dive matches.
+func (dl *DiveLog) SuggestDive(attachment *Attachment) *Dive {
+	taken := attachment.Taken()
+	if taken.IsZero() {
+		return nil
+	}
+	for _, margin := range []time.Duration{0, PhotoMatchMargin} {
+		for _, dive := range dl.sorted {
+			if !taken.Before(dive.DateTimeIn.Add(-margin)) && !taken.After(dive.TimeOut().Add(margin)) {
+				return dive
+			}
+		}
+	}
+	return nil
+}
+
+// pruneAttachments removes stored files with the given hashes, and their thumbnails, if no dive, including those in
+// the trash, has them attached.
+func (dl *DiveLog) pruneAttachments(hashes ...string) {
+	if dl.dir == "" {
+		return
+	}
+	for _, hash := range hashes {
+		attached := slices.ContainsFunc(dl.sorted, func(dive *Dive) bool { return dive.Data.Attachment(hash) != nil })
+		for _, td := range dl.trash {
+			attached = attached || td.Record.Attachment(hash) != nil
+		}
+		if !attached {
+			os.Remove(dl.AttachmentPath(hash))
+			os.Remove(dl.ThumbnailPath(hash))
+		}
+	}
+}
+
+// writeThumbnail writes a JPEG thumbnail of the image, scaled down to fit `ThumbnailSize`.
+func writeThumbnail(imagePath string, thumbnailPath string) error {
+	file, err := os.Open(imagePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	config, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return err
+	}
+	if config.Width*config.Height > MaxThumbnailPixels {
+		return fmt.Errorf("image of %dx%d px is too large", config.Width, config.Height)
+	}
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return err
+	}
+
+	thumbnail, err := os.Create(thumbnailPath)
+	if err != nil {
+		return err
+	}
+	defer thumbnail.Close()
+	return jpeg.Encode(thumbnail, Thumbnail(img, ThumbnailSize), &jpeg.Options{Quality: 80})
+}
+
+// Thumbnail scales the image down to fit a square of the given size, averaging the pixels of the image that make up
+// each pixel of the thumbnail. Smaller images are not scaled.
+func Thumbnail(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w <= size && h <= size {
+		return img
+	}
+	tw, th := size, max(1, h*size/w)
+	if h > w {
+		tw, th = max(1, w*size/h), size
+	}
+
+	thumbnail := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for ty := 0; ty < th; ty++ {
+		y0, y1 := bounds.Min.Y+ty*h/th, bounds.Min.Y+(ty+1)*h/th
+		for tx := 0; tx < tw; tx++ {
+			x0, x1 := bounds.Min.X+tx*w/tw, bounds.Min.X+(tx+1)*w/tw
+			var r, g, b, a, n uint64
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					pr, pg, pb, pa := img.At(x, y).RGBA()
+					r, g, b, a, n = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa), n+1
+				}
+			}
+			i := thumbnail.PixOffset(tx, ty)
+			thumbnail.Pix[i+0] = uint8(r / n >> 8)
+			thumbnail.Pix[i+1] = uint8(g / n >> 8)
+			thumbnail.Pix[i+2] = uint8(b / n >> 8)
+			thumbnail.Pix[i+3] = uint8(a / n >> 8)
+		}
+	}
+	return thumbnail
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+
+	"github.com/cicovic-andrija/libgo/logging"
+)
+
+// Attachments are served by the handlers below, to the owner of the dive log only, rather than by the file server.
+
+// HTTPS handler that uploads files and attaches them to the dive, as a single change.
+func diveAttachmentUploadHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, MaxUploadSize)
+	mlog := LogOf(r)
+
+	errMsg := ""
+	if err := r.ParseMultipartForm(32 << 20); err != nil { // files larger than this are buffered on the disk
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			errMsg = fmt.Sprintf("Please upload at most %d MB at once.", MaxUploadSize>>20)
+		} else {
+			errMsg = "Please choose the files to attach."
+		}
+	} else {
+		defer r.MultipartForm.RemoveAll()
+	}
+	var attachments []*Attachment
+	if errMsg == "" {
+		files := r.MultipartForm.File[AttachmentTag]
+		if len(files) == 0 {
+			errMsg = "Please choose the files to attach."
+		}
+		// Files are stored before the log is locked, as it may take a while.
+		for _, header := range files {
+			file, err := header.Open()
+			if err == nil {
+				var attachment *Attachment
+				attachment, err = mlog.StoreAttachment(header.Filename, file)
+				file.Close()
+				if err == nil {
+					attachments = append(attachments, attachment)
+				}
+			}
+			if err != nil {
+				trace(logging.SevError, "attachment %q not stored: %v", header.Filename, err)
+				errMsg = fmt.Sprintf("File %q could not be attached.", header.Filename)
+				break
+			}
+		}
+	}
+
+	mlog.Lock()
+	defer mlog.Unlock()
+	for _, attachment := range attachments {
+		// Removal of another dive with the same file may have removed it before the log was locked.
+		if errMsg == "" && !mlog.AttachmentStored(attachment) {
+			errMsg = fmt.Sprintf("File %q could not be attached, please try again.", attachment.Name)
+		}
+	}
+	dive := mlog.Find(r.PathValue(IDTag))
+	if dive == nil || errMsg != "" {
+		// Files stored by this upload are removed, unless they are attached to other dives.
+		for _, attachment := range attachments {
+			mlog.pruneAttachments(attachment.Hash)
+		}
+	}
+	if dive == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if errMsg != "" {
+		page := divePage(mlog, dive)
+		page.InputErrors = map[string]string{AttachmentTag: errMsg}
+		render("dive.html", w, r, page)
+		return
+	}
+	change := mlog.Begin(AccountOf(r), "Attached")
+	for _, attachment := range attachments {
+		mlog.Attach(dive, attachment)
+	}
+	change.Commit()
+	http.Redirect(w, r, "/dives/"+dive.ID(), http.StatusSeeOther)
+}
+
+func diveAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	serveAttachment(w, r, false)
+}
+
+func diveThumbnailHandler(w http.ResponseWriter, r *http.Request) {
+	serveAttachment(w, r, true)
+}
+
+// serveAttachment serves a file attached to the dive, or its thumbnail. Only files of `InlineTypes` are shown in the
+// browser, and never as an active document.
+func serveAttachment(w http.ResponseWriter, r *http.Request, thumbnail bool) {
+	var (
+		attachment *Attachment
+		path       string
+	)
+	mlog := LogOf(r)
+	mlog.RLock()
+	if dive := mlog.Find(r.PathValue(IDTag)); dive != nil {
+		attachment = dive.Data.Attachment(r.PathValue(HashTag))
+	}
+	if attachment != nil {
+		path = mlog.AttachmentPath(attachment.Hash)
+		if thumbnail {
+			path = mlog.ThumbnailPath(attachment.Hash)
+		}
+	}
+	mlog.RUnlock()
+	if attachment == nil || (thumbnail && !attachment.Thumbnail) {
+		http.NotFound(w, r)
+		return
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		http.NotFound(w, r) // e.g. a revision reverted to refers to a file since removed
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	contentType, disposition := attachment.Type, "inline"
+	if thumbnail {
+		contentType = "image/jpeg"
+	} else if !attachment.Inline() {
+		disposition = "attachment"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": attachment.Name}))
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age=31536000, immutable") // named by content
+	http.ServeContent(w, r, "", info.ModTime(), file)
+}
+
+func diveAttachmentRemovalHandler(w http.ResponseWriter, r *http.Request) {
+	mlog := LogOf(r)
+	mlog.Lock()
+	defer mlog.Unlock()
+	dive := mlog.Find(r.PathValue(IDTag))
+	if dive == nil || dive.Data.Attachment(r.PathValue(HashTag)) == nil {
+		http.NotFound(w, r)
+		return
+	}
+	change := mlog.Begin(AccountOf(r), "Detached")
+	mlog.Detach(dive, r.PathValue(HashTag))
+	change.Commit()
+	fmt.Fprint(w, "") // this is an async call, return hypermedia in the response to client
+}
+
+// HTTPS handler that moves an attachment to another dive, e.g. the one a photo was taken on (see `SuggestDive`).
+func diveAttachmentMoveHandler(w http.ResponseWriter, r *http.Request) {
+	mlog := LogOf(r)
+	mlog.Lock()
+	defer mlog.Unlock()
+	from, to := mlog.Find(r.PathValue(IDTag)), mlog.Find(r.FormValue(MoveToTag))
+	if from == nil || to == nil || from == to || from.Data.Attachment(r.PathValue(HashTag)) == nil {
+		http.NotFound(w, r)
+		return
+	}
+	change := mlog.Begin(AccountOf(r), fmt.Sprintf("Attachment moved from dive #%d to #%d", from.Num(), to.Num()))
+	mlog.MoveAttachment(from, to, r.PathValue(HashTag))
+	change.Commit()
+	http.Redirect(w, r, "/dives/"+from.ID(), http.StatusSeeOther)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	BatchDiveTag   = "dive"
+	BatchActionTag = "batch_action"
+	BatchSiteTag   = "batch_site"
+	BatchTripTag   = "batch_trip"
+	BatchDecoTag   = "batch_deco"
+	BatchTagTag    = "batch_tag"
+
+	BatchDelete = "delete"
+	BatchSite   = "site"
+	BatchTrip   = "trip"
+	BatchDeco   = "deco"
+	BatchTag    = "tag"
+)
+
+// Batch is an action applied to a selection of dives from the dive list.
+type Batch struct {
+	Action string
+	Value  string // site ID, trip ID (empty to unassign), deco. flag ("true"/"false"), or tag
+	Dives  DiveList
+}
+
+// Description returns a short description of the batch, recorded as the action of the change.
+func (b *Batch) Description() string {
+	switch b.Action {
+	case BatchDelete:
+		return fmt.Sprintf("Moved to trash (%d dive(s))", len(b.Dives))
+	case BatchSite:
+		return fmt.Sprintf("Site set (%d dive(s))", len(b.Dives))
+	case BatchTrip:
+		return fmt.Sprintf("Trip set (%d dive(s))", len(b.Dives))
+	case BatchDeco:
+		return fmt.Sprintf("Deco. flag set (%d dive(s))", len(b.Dives))
+	case BatchTag:
+		return fmt.Sprintf("Tagged %q (%d dive(s))", b.Value, len(b.Dives))
+	}
+	return b.Action
+}
+
+// ApplyBatch applies the batch to all of its dives, or, if it is invalid, to none. It should be applied as a single
+// `Change`, so the log is persisted once.
+func (dl *DiveLog) ApplyBatch(b *Batch, now time.Time) error {
+	if len(b.Dives) == 0 {
+		return fmt.Errorf("no dives selected")
+	}
+
+	switch b.Action {
+	case BatchDelete:
+		for _, dive := range b.Dives {
+			dl.Trash(dive.ID(), now)
+		}
+	case BatchSite:
+		if dl.sites.Find(b.Value) == nil {
+			return fmt.Errorf("site %q not found", b.Value)
+		}
+		for _, dive := range b.Dives {
+			dl.touch(dive.id)
+			unlinkGeo(dive, dl.sites.Find(dive.Data.SiteID))
+			dive.Data.SiteID = b.Value
+			dl.linkSite(dive)
+			dl.index.Add(dive)
+		}
+		dl.persist()
+	case BatchTrip:
+		if b.Value != "" && dl.FindTrip(b.Value) == nil {
+			return fmt.Errorf("trip %q not found", b.Value)
+		}
+		dl.AssignTrip(b.Value, b.Dives)
+	case BatchDeco:
+		if b.Value != "true" && b.Value != "false" {
+			return fmt.Errorf("invalid deco. flag %q", b.Value)
+		}
+		for _, dive := range b.Dives {
+			dl.touch(dive.id)
+			dive.Data.DecoDive = b.Value == "true"
+		}
+		dl.persist()
+	case BatchTag:
+		tags, err := ParseTags(b.Value)
+		if err != nil || len(tags) != 1 {
+			return fmt.Errorf("invalid tag %q", b.Value)
+		}
+		b.Value = tags[0]
+		dl.AddTag(b.Value, b.Dives)
+	default:
+		return fmt.Errorf("unknown batch action %q", b.Action)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"strings"
+)
+
+const (
+	// Dimensions of the chart in SVG user units; the chart scales to the width of the page.
+	ChartWidth  = 640
+	ChartHeight = 200
+
+	chartTop    = 16 // room for values above bars
+	chartBottom = 20 // room for labels below bars
+
+	// With more bars than MaxChartLabels, only every n-th bar is labeled so labels don't overlap.
+	MaxChartLabels = 16
+)
+
+// Chart is a bar chart, rendered on the server as SVG.
+type Chart struct {
+	Title string
+	Unit  string // unit of values, shown in tooltips
+	Bars  []*ChartBar
+}
+
+// ChartBar is a single bar in a `Chart`.
+type ChartBar struct {
+	Label string
+	Value float64
+}
+
+// Add appends a bar to the chart.
+func (c *Chart) Add(label string, value float64) {
+	c.Bars = append(c.Bars, &ChartBar{Label: label, Value: value})
+}
+
+// Empty reports whether all bars of the chart are zero.
+func (c *Chart) Empty() bool {
+	for _, bar := range c.Bars {
+		if bar.Value > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// SVG renders the chart as an inline SVG element.
+func (c *Chart) SVG() template.HTML {
+	var (
+		sb       strings.Builder
+		maxValue float64
+		plotH    = float64(ChartHeight - chartTop - chartBottom)
+		every    = 1
+	)
+	for _, bar := range c.Bars {
+		maxValue = max(maxValue, bar.Value)
+	}
+	if len(c.Bars) > MaxChartLabels {
+		every = (len(c.Bars) + MaxChartLabels - 1) / MaxChartLabels
+	}
+
+	fmt.Fprintf(&sb, `<svg class="chart" viewBox="0 0 %d %d" role="img" aria-label="%s">`,
+		ChartWidth, ChartHeight, html.EscapeString(c.Title))
+	fmt.Fprintf(&sb, `<line x1="0" y1="%d" x2="%d" y2="%d" stroke="var(--border)"/>`,
+		ChartHeight-chartBottom, ChartWidth, ChartHeight-chartBottom)
+	if len(c.Bars) == 0 || maxValue <= 0 {
+		sb.WriteString(`</svg>`)
+		return template.HTML(sb.String())
+	}
+
+	slot := float64(ChartWidth) / float64(len(c.Bars))
+	for i, bar := range c.Bars {
+		var (
+			h = bar.Value / maxValue * plotH
+			x = float64(i) * slot
+			y = float64(ChartHeight-chartBottom) - h
+		)
+		fmt.Fprintf(&sb, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="var(--accent)"><title>%s: %s</title></rect>`,
+			x+slot*0.1, y, slot*0.8, h, html.EscapeString(bar.Label), html.EscapeString(c.formatValue(bar.Value)))
+		if bar.Value > 0 && len(c.Bars) <= MaxChartLabels {
+			fmt.Fprintf(&sb, `<text x="%.1f" y="%.1f" font-size="11" text-anchor="middle">%s</text>`,
+				x+slot/2, y-4, html.EscapeString(fmt.Sprintf("%g", bar.Value)))
+		}
+		if i%every == 0 {
+			fmt.Fprintf(&sb, `<text x="%.1f" y="%d" font-size="11" text-anchor="middle" fill="var(--text-light)">%s</text>`,
+				x+slot/2, ChartHeight-6, html.EscapeString(bar.Label))
+		}
+	}
+	sb.WriteString(`</svg>`)
+	return template.HTML(sb.String())
+}
+
+func (c *Chart) formatValue(value float64) string {
+	if c.Unit == "" {
+		return fmt.Sprintf("%g", value)
+	}
+	return fmt.Sprintf("%g %s", value, c.Unit)
+}
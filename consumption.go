@@ -0,0 +1,220 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+const (
+	// A dive is flagged for review when its RMV is farther than OutlierThreshold robust standard deviations from the
+	// median RMV of all dives, which requires at least OutlierMinDives dives with known consumption.
+	OutlierThreshold = 3.0
+	OutlierMinDives  = 5
+)
+
+// Consumption is the gas consumption on a dive, normalized to the surface: SAC as pressure drop per minute, and RMV as
+// volume of gas breathed per minute.
+type Consumption struct {
+	Dive       *Dive
+	TankVolume float32 // L
+	Used       int     // bar
+	AvgDepth   float32 // m
+	SAC        float64 // bar/min
+	RMV        float64 // L/min
+	Outlier    bool    // consumption is abnormal compared to other dives, and should be reviewed
+}
+
+// ConsumptionGroup holds the average consumption over a group of dives, e.g. dives at a site or in a month.
+type ConsumptionGroup struct {
+	Key     string // ID of the site or gear item, or the period
+	Name    string
+	Dives   int
+	MeanRMV float64
+}
+
+// ConsumptionStats holds consumption on all dives where it's known, with trends and outliers.
+type ConsumptionStats struct {
+	Dives     []*Consumption
+	MeanRMV   float64
+	MedianRMV float64
+	ByMonth   []*ConsumptionGroup
+	BySite    []*ConsumptionGroup
+	BySuit    []*ConsumptionGroup
+	ByTank    []*ConsumptionGroup
+	Outliers  []*Consumption
+}
+
+// TankVolume returns the volume of the tank used on the dive: as recorded, or from the tank in the dive's gear.
+// If neither is known, zero is returned.
+func (dl *DiveLog) TankVolume(dive *Dive) float32 {
+	if dive.Data.TankVolume > 0 {
+		return dive.Data.TankVolume
+	}
+	if tank := dl.gearOfKind(dive, GearTank); tank != nil {
+		return tank.Volume
+	}
+	return 0
+}
+
+// gearOfKind returns the first gear item of the kind used on the dive, or nil.
+func (dl *DiveLog) gearOfKind(dive *Dive, kind string) *GearItem {
+	for _, id := range dive.Data.Gear {
+		if item := dl.gear[id]; item != nil && item.Kind == kind {
+			return item
+		}
+	}
+	return nil
+}
+
+// Consumption returns the gas consumption on the dive, or nil if the tank, pressures, duration or depth are not known.
+// If the average depth was not recorded, it is estimated from the (approximate) profile.
+func (dl *DiveLog) Consumption(dive *Dive) *Consumption {
+	var (
+		data   = dive.Data
+		volume = dl.TankVolume(dive)
+		used   = data.TankPressureStart - data.TankPressureEnd
+		avg    = data.AvgDepth
+	)
+	if avg <= 0 {
+		if profile := dive.Profile(); profile != nil {
+			_, avg = ProfileDepths(profile)
+		}
+	}
+	if volume <= 0 || data.TankPressureEnd <= 0 || used <= 0 || avg <= 0 || data.Duration.Minutes() <= 0 {
+		return nil
+	}
+
+	ata := AmbientPressure(float64(avg)) / SurfacePressure
+	sac := float64(used) / data.Duration.Minutes() / ata
+	return &Consumption{
+		Dive:       dive,
+		TankVolume: volume,
+		Used:       used,
+		AvgDepth:   avg,
+		SAC:        sac,
+		RMV:        sac * float64(volume),
+	}
+}
+
+// ConsumptionStats returns consumption on all dives where it's known, in chronological order, grouped in various ways,
+// and with outliers flagged. Statistics are cached until the log changes, and must not be modified.
+func (dl *DiveLog) ConsumptionStats() *ConsumptionStats {
+	dl.derived.Lock()
+	stats := dl.derived.consumption
+	dl.derived.Unlock()
+	if stats != nil {
+		return stats
+	}
+
+	stats = dl.consumptionStats()
+	dl.derived.Lock()
+	dl.derived.consumption = stats
+	dl.derived.Unlock()
+	return stats
+}
+
+func (dl *DiveLog) consumptionStats() *ConsumptionStats {
+	stats := &ConsumptionStats{}
+	for _, dive := range dl.sorted {
+		if consumption := dl.Consumption(dive); consumption != nil {
+			stats.Dives = append(stats.Dives, consumption)
+		}
+	}
+	if len(stats.Dives) == 0 {
+		return stats
+	}
+
+	rmvs := make([]float64, 0, len(stats.Dives))
+	for _, consumption := range stats.Dives {
+		rmvs = append(rmvs, consumption.RMV)
+		stats.MeanRMV += consumption.RMV / float64(len(stats.Dives))
+	}
+	stats.MedianRMV = median(rmvs)
+
+	// Median absolute deviation is a measure of spread which, unlike standard deviation, isn't skewed by the very
+	// outliers it's used to find. Scaled, it estimates standard deviation of normally distributed values.
+	if len(stats.Dives) >= OutlierMinDives {
+		deviations := make([]float64, 0, len(rmvs))
+		for _, rmv := range rmvs {
+			deviations = append(deviations, math.Abs(rmv-stats.MedianRMV))
+		}
+		if spread := 1.4826 * median(deviations); spread > 0 {
+			for _, consumption := range stats.Dives {
+				if math.Abs(consumption.RMV-stats.MedianRMV) > OutlierThreshold*spread {
+					consumption.Outlier = true
+					stats.Outliers = append(stats.Outliers, consumption)
+				}
+			}
+		}
+	}
+
+	stats.ByMonth = groupConsumption(stats.Dives, func(c *Consumption) (string, string) {
+		return c.Dive.DateTimeIn.Format("2006-01"), c.Dive.DateTimeIn.Format("January 2006")
+	})
+	stats.BySite = groupConsumption(stats.Dives, func(c *Consumption) (string, string) {
+		return c.Dive.Data.SiteID, c.Dive.Data.Site
+	})
+	stats.BySuit = groupConsumption(stats.Dives, func(c *Consumption) (string, string) {
+		if suit := dl.gearOfKind(c.Dive, GearSuit); suit != nil {
+			return suit.ID, suit.Name
+		}
+		return "", ""
+	})
+	stats.ByTank = groupConsumption(stats.Dives, func(c *Consumption) (string, string) {
+		if tank := dl.gearOfKind(c.Dive, GearTank); tank != nil {
+			return tank.ID, tank.Name
+		}
+		return "", ""
+	})
+	return stats
+}
+
+// Outlier reports whether consumption on the dive is abnormal compared to other dives.
+func (stats *ConsumptionStats) Outlier(dive *Dive) bool {
+	for _, consumption := range stats.Outliers {
+		if consumption.Dive == dive {
+			return true
+		}
+	}
+	return false
+}
+
+// groupConsumption groups consumption by the key returned by keyOf, skipping empty keys. Groups are in the order of
+// their first dive.
+func groupConsumption(dives []*Consumption, keyOf func(*Consumption) (key string, name string)) []*ConsumptionGroup {
+	var (
+		groups []*ConsumptionGroup
+		byKey  = make(map[string]*ConsumptionGroup)
+	)
+	for _, consumption := range dives {
+		key, name := keyOf(consumption)
+		if key == "" {
+			continue
+		}
+		group := byKey[key]
+		if group == nil {
+			group = &ConsumptionGroup{Key: key, Name: name}
+			byKey[key] = group
+			groups = append(groups, group)
+		}
+		group.Dives++
+		group.MeanRMV += consumption.RMV
+	}
+	for _, group := range groups {
+		group.MeanRMV /= float64(group.Dives)
+	}
+	return groups
+}
+
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	if n := len(sorted); n%2 == 1 {
+		return sorted[n/2]
+	} else {
+		return (sorted[n/2-1] + sorted[n/2]) / 2
+	}
+}
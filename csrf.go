@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/subtle"
+	"mime"
+	"net/http"
+	"net/url"
+)
+
+// Cross-site request forgery protection. Every session has a secret token, which pages send back on htmx requests
+// through the `hx-headers` attribute of the body (see the "lead" template), or in a field of a URL-encoded form.
+// Multipart forms must send the header, so their bodies aren't parsed before handlers limit their size.
+
+const (
+	CSRFHeader = "X-CSRF-Token"
+	CSRFTag    = "csrf_token"
+)
+
+// CSRFTokenOf returns the CSRF token of the session the request belongs to, or an empty string outside of routes
+// that require a signed-in user.
+func CSRFTokenOf(r *http.Request) string {
+	token, _ := r.Context().Value(csrfKey{}).(string)
+	return token
+}
+
+// verifyCSRF is an adapter that rejects mutating requests sent from another origin, or, if the request belongs to a
+// session, without its CSRF token.
+func verifyCSRF(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		if !sameOrigin(r) {
+			http.Error(w, "cross-origin request rejected", http.StatusForbidden)
+			return
+		}
+		if expected := CSRFTokenOf(r); expected != "" {
+			token := r.Header.Get(CSRFHeader)
+			mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if token == "" && mediaType == "application/x-www-form-urlencoded" {
+				token = r.PostFormValue(CSRFTag)
+			}
+			if subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+				http.Error(w, "invalid CSRF token", http.StatusForbidden)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// sameOrigin reports whether the request was sent from a page of this server, according to the `Origin` header, or
+// `Referer` if there is no origin. Clients that send neither (e.g. not browsers) must rely on the token alone.
+func sameOrigin(r *http.Request) bool {
+	source := r.Header.Get("Origin")
+	if source == "" {
+		source = r.Header.Get("Referer")
+	}
+	if source == "" {
+		return true
+	}
+	u, err := url.Parse(source)
+	return err == nil && u.Scheme == "https" && u.Host == r.Host
+}
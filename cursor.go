@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor is a position in an ordered list of dives: it identifies the last dive a client has seen. Cursors are
+// based on the ordering key of a dive rather than its index, so a list that changes between two requests (a dive
+// is inserted or deleted) is continued exactly where the client left off, without duplicated or skipped rows.
+//
+// Chronological lists are ordered by (DateTimeIn, ID). Ranked lists (search results) are ordered by descending
+// score, and then by descending (DateTimeIn, ID).
+type Cursor struct {
+	DateTimeIn time.Time
+	ID         string
+	Ranked     bool
+	Score      float64
+}
+
+// ChronoCursor returns the cursor pointing at the dive in a chronologically ordered list.
+func ChronoCursor(dive *Dive) *Cursor {
+	return &Cursor{DateTimeIn: dive.DateTimeIn, ID: dive.id}
+}
+
+// RankedCursor returns the cursor pointing at the dive with the given score in a list ordered by relevance.
+func RankedCursor(dive *Dive, score float64) *Cursor {
+	return &Cursor{DateTimeIn: dive.DateTimeIn, ID: dive.id, Ranked: true, Score: score}
+}
+
+// Precedes reports whether position c comes strictly before position other, in the ordering of c.
+func (c *Cursor) Precedes(other *Cursor) bool {
+	if c.Ranked {
+		if c.Score != other.Score {
+			return c.Score > other.Score
+		}
+		if !c.DateTimeIn.Equal(other.DateTimeIn) {
+			return c.DateTimeIn.After(other.DateTimeIn)
+		}
+		return c.ID > other.ID
+	}
+	if !c.DateTimeIn.Equal(other.DateTimeIn) {
+		return c.DateTimeIn.Before(other.DateTimeIn)
+	}
+	return c.ID < other.ID
+}
+
+// Encode returns the opaque, URL-safe string representation of the cursor.
+func (c *Cursor) Encode() string {
+	fields := []string{c.DateTimeIn.Format(DateTimeLayout), c.ID}
+	if c.Ranked {
+		fields = append(fields, strconv.FormatFloat(c.Score, 'g', -1, 64))
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(strings.Join(fields, "|")))
+}
+
+// DecodeCursor parses a cursor previously returned by `Cursor.Encode`.
+func DecodeCursor(encoded string) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	fields := strings.Split(string(raw), "|")
+	if len(fields) != 2 && len(fields) != 3 {
+		return nil, ErrInvalidCursor
+	}
+
+	cursor := &Cursor{ID: fields[1]}
+	if cursor.DateTimeIn, err = time.Parse(DateTimeLayout, fields[0]); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if len(fields) == 3 {
+		cursor.Ranked = true
+		if cursor.Score, err = strconv.ParseFloat(fields[2], 64); err != nil {
+			return nil, ErrInvalidCursor
+		}
+	}
+	return cursor, nil
+}
+
+// Paginate returns at most limit dives from s that follow the position after (or the first dives, if after is nil),
+// and the cursor pointing at the last returned dive if more dives follow it (nil otherwise). s must be ordered
+// consistently with the cursors returned by cursorOf.
+func Paginate(s DiveList, after *Cursor, limit int, cursorOf func(*Dive) *Cursor) (page DiveList, next *Cursor) {
+	if limit < 1 {
+		return nil, nil
+	}
+
+	start := 0
+	if after != nil {
+		start = sort.Search(len(s), func(i int) bool { return after.Precedes(cursorOf(s[i])) })
+	}
+	end := start + limit
+	if end >= len(s) {
+		return s[start:], nil
+	}
+
+	page = s[start:end]
+	return page, cursorOf(page[len(page)-1])
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Custom fields are defined by admins for the whole server, and persisted in the data directory next to accounts.
+// Their values are kept in dive records (see `DiveRecord.Custom`), keyed by field ID, so a removed field doesn't take
+// the logged values with it.
+
+const (
+	CustomFieldsFileName     = "fields.json"
+	TempCustomFieldsFileName = "fields.tmp.json"
+
+	// CustomFieldTagPrefix is followed by the field ID in the tag of the dive form input of a custom field.
+	CustomFieldTagPrefix = "custom_"
+
+	FieldNameTag    = "field_name"
+	FieldTypeTag    = "field_type"
+	FieldUnitTag    = "field_unit"
+	FieldMinTag     = "field_min"
+	FieldMaxTag     = "field_max"
+	FieldOptionsTag = "field_options"
+
+	FieldQueryTag      = "field"
+	FieldValueQueryTag = "field_value"
+
+	FieldText   = "text"
+	FieldNumber = "number"
+	FieldBool   = "bool"
+	FieldEnum   = "enum"
+
+	MaxCustomFieldNameLength = 64
+)
+
+var (
+	FieldTypes = []string{FieldText, FieldNumber, FieldBool, FieldEnum}
+
+	ErrFieldExists = errors.New("field already exists")
+
+	CustomFields = NewCustomFieldSet()
+)
+
+// CustomField is a dive attribute the schema doesn't know about, e.g. camera settings or a survey transect ID.
+// Values are strings (text and enum fields), numbers or booleans.
+type CustomField struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Type    string   `json:"type"` // one of `FieldTypes`
+	Unit    string   `json:"unit,omitempty"`
+	Min     *float64 `json:"min,omitempty"`     // range of number fields, inclusive
+	Max     *float64 `json:"max,omitempty"`     //
+	Options []string `json:"options,omitempty"` // values of enum fields
+}
+
+// Tag returns the tag of the dive form input of the field.
+func (f *CustomField) Tag() string {
+	return CustomFieldTagPrefix + f.ID
+}
+
+// Range returns the valid range of a number field, e.g. "0 to 100", or an empty string if it is not limited.
+func (f *CustomField) Range() string {
+	switch {
+	case f.Min != nil && f.Max != nil:
+		return fmt.Sprintf("%v to %v", *f.Min, *f.Max)
+	case f.Min != nil:
+		return fmt.Sprintf("at least %v", *f.Min)
+	case f.Max != nil:
+		return fmt.Sprintf("at most %v", *f.Max)
+	}
+	return ""
+}
+
+// Format returns a value of the field for display, with the unit.
+func (f *CustomField) Format(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case bool:
+		return formatYesNo(v)
+	case float64:
+		if f.Unit != "" {
+			return fmt.Sprintf("%v %s", v, f.Unit)
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+// InputValue returns a value of the field in the format accepted by `validateCustomFieldInput`.
+func (f *CustomField) InputValue(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+// Matches reports whether a value of the field matches a filter: text values contain the filter (ignoring case), and
+// other values are equal to it. An empty filter matches any value that is set.
+func (f *CustomField) Matches(value any, filter string) bool {
+	if value == nil {
+		return false
+	}
+	if filter == "" {
+		return true
+	}
+	if text, ok := value.(string); ok && f.Type == FieldText {
+		return strings.Contains(strings.ToLower(text), strings.ToLower(filter))
+	}
+	wanted, errMsg := validateCustomFieldInput(f, filter)
+	return errMsg == "" && wanted == value
+}
+
+// CustomValue returns the value of the custom field in the record, in the format of the dive form.
+func (dr *DiveRecord) CustomValue(f *CustomField) string {
+	return f.InputValue(dr.Custom[f.ID])
+}
+
+// FormattedCustomValue returns the value of the custom field in the record, for display.
+func (dr *DiveRecord) FormattedCustomValue(f *CustomField) string {
+	return f.Format(dr.Custom[f.ID])
+}
+
+// FormattedCustomValues returns all custom field values in the record, e.g. "Camera ISO: 400, Scooter: Yes", ordered
+// by field ID. Values of removed fields are included, under their IDs.
+func (dr *DiveRecord) FormattedCustomValues() string {
+	ids := make([]string, 0, len(dr.Custom))
+	for id := range dr.Custom {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	values := make([]string, 0, len(ids))
+	for _, id := range ids {
+		field := CustomFields.Find(id)
+		if field == nil {
+			field = &CustomField{ID: id, Name: id}
+		}
+		values = append(values, fmt.Sprintf("%s: %s", field.Name, field.Format(dr.Custom[id])))
+	}
+	return strings.Join(values, ", ")
+}
+
+// CustomFieldSet holds custom fields in the order they were defined. Unlike `DiveLog`, its functions are thread-safe.
+type CustomFieldSet struct {
+	sync.RWMutex
+	fields []*CustomField
+}
+
+type PersistedCustomFields struct {
+	Fields []*CustomField `json:"fields"`
+}
+
+func NewCustomFieldSet() *CustomFieldSet {
+	return &CustomFieldSet{fields: make([]*CustomField, 0)}
+}
+
+func (cs *CustomFieldSet) All() []*CustomField {
+	cs.RLock()
+	defer cs.RUnlock()
+	return slices.Clone(cs.fields)
+}
+
+func (cs *CustomFieldSet) Find(id string) *CustomField {
+	cs.RLock()
+	defer cs.RUnlock()
+	return cs.find(id)
+}
+
+func (cs *CustomFieldSet) find(id string) *CustomField {
+	if i := slices.IndexFunc(cs.fields, func(f *CustomField) bool { return f.ID == id }); i >= 0 {
+		return cs.fields[i]
+	}
+	return nil
+}
+
+// Add defines a new field, with an ID derived from its name. Input must be validated by the caller. The caller is
+// responsible for persisting fields (see `saveCustomFields`).
+func (cs *CustomFieldSet) Add(field *CustomField) error {
+	cs.Lock()
+	defer cs.Unlock()
+	if slices.ContainsFunc(cs.fields, func(f *CustomField) bool { return strings.EqualFold(f.Name, field.Name) }) {
+		return ErrFieldExists
+	}
+	field.ID = UniqueSlug(field.Name, "field", func(id string) bool { return cs.find(id) != nil })
+	cs.fields = append(cs.fields, field)
+	return nil
+}
+
+// Remove removes the definition of the field. Values already logged are kept in dive records.
+func (cs *CustomFieldSet) Remove(id string) (found bool) {
+	cs.Lock()
+	defer cs.Unlock()
+	n := len(cs.fields)
+	cs.fields = slices.DeleteFunc(cs.fields, func(f *CustomField) bool { return f.ID == id })
+	return len(cs.fields) < n
+}
+
+// loadCustomFields reads custom fields from the data directory. A missing file is not an error: no fields are defined.
+func loadCustomFields() error {
+	file, err := os.Open(filepath.Join(DataDirectory, CustomFieldsFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("read fields operation failed: %v", err)
+	}
+	defer file.Close()
+
+	persisted := &PersistedCustomFields{}
+	if err = json.NewDecoder(file).Decode(persisted); err != nil {
+		return fmt.Errorf("decode fields operation failed: %v", err)
+	}
+
+	CustomFields.Lock()
+	defer CustomFields.Unlock()
+	for _, field := range persisted.Fields {
+		if field.ID == "" || !slices.Contains(FieldTypes, field.Type) {
+			return fmt.Errorf("invalid field %q", field.ID)
+		}
+	}
+	CustomFields.fields = persisted.Fields
+	return nil
+}
+
+// saveCustomFields writes custom fields to the data directory, replacing the file atomically.
+func saveCustomFields() error {
+	tmpPath := filepath.Join(DataDirectory, TempCustomFieldsFileName)
+	tmpFile, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("temp fields file creation failed: %v", err)
+	}
+	defer os.Remove(tmpPath)
+	defer tmpFile.Close()
+
+	if err = NewEncoder(tmpFile).Encode(&PersistedCustomFields{Fields: CustomFields.All()}); err != nil {
+		return fmt.Errorf("encode fields operation failed: %v", err)
+	}
+	if err = os.Rename(tmpPath, filepath.Join(DataDirectory, CustomFieldsFileName)); err != nil {
+		return fmt.Errorf("write fields operation failed: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/cicovic-andrija/libgo/logging"
+)
+
+// HTTPS handler for the custom fields defined on the server, with a form to define a new one. Only admins can define
+// fields.
+func customFieldsHandler(w http.ResponseWriter, r *http.Request) {
+	if !AccountOf(r).Admin {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	render("fields.html", w, r, &Page{Title: "Custom Fields", Fields: CustomFields.All(), Field: &CustomField{}})
+}
+
+func customFieldFormHandler(w http.ResponseWriter, r *http.Request) {
+	if !AccountOf(r).Admin {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	page := &Page{Title: "Custom Fields", InputErrors: make(map[string]string)}
+	field, ok := parseCustomFieldFromRequest(r, page.InputErrors)
+	if ok {
+		if err := CustomFields.Add(field); errors.Is(err, ErrFieldExists) {
+			ok = false
+			page.InputErrors[FieldNameTag] = fmt.Sprintf("Field %q is already defined.", field.Name)
+		}
+	}
+
+	if ok {
+		if err := saveCustomFields(); err != nil {
+			trace(logging.SevError, "persistence of custom fields failed: %v", err)
+		}
+		http.Redirect(w, r, "/fields", http.StatusFound)
+	} else { // not ok
+		page.Fields = CustomFields.All()
+		page.Field = field
+		render("fields.html", w, r, page)
+	}
+}
+
+func customFieldRemovalHandler(w http.ResponseWriter, r *http.Request) {
+	if !AccountOf(r).Admin {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if !CustomFields.Remove(r.PathValue(IDTag)) {
+		http.NotFound(w, r)
+		return
+	}
+	if err := saveCustomFields(); err != nil {
+		trace(logging.SevError, "persistence of custom fields failed: %v", err)
+	}
+	fmt.Fprint(w, "") // this is an async call, return hypermedia in the response to client
+}
+
+// parseCustomFieldFromRequest reads the definition of a custom field. The unit and range apply to number fields, and
+// the options, comma-separated, to enum fields.
+func parseCustomFieldFromRequest(r *http.Request, errorMap map[string]string) (field *CustomField, ok bool) {
+	ok = true
+	field = &CustomField{Type: r.FormValue(FieldTypeTag)}
+
+	if name, errMsg := validateNonEmptyString(r.FormValue(FieldNameTag)); errMsg != "" || len(name) > MaxCustomFieldNameLength {
+		ok = false
+		errorMap[FieldNameTag] = fmt.Sprintf("Please provide a name of up to %d characters.", MaxCustomFieldNameLength)
+	} else {
+		field.Name = name
+	}
+	if !slices.Contains(FieldTypes, field.Type) {
+		ok = false
+		errorMap[FieldTypeTag] = "Please choose a field type."
+	}
+
+	if field.Type == FieldNumber {
+		field.Unit = strings.TrimSpace(r.FormValue(FieldUnitTag))
+		for _, bound := range []struct {
+			tag   string
+			value **float64
+		}{
+			{FieldMinTag, &field.Min},
+			{FieldMaxTag, &field.Max},
+		} {
+			if value := strings.TrimSpace(r.FormValue(bound.tag)); value != "" {
+				if number, err := strconv.ParseFloat(value, 64); err != nil || math.IsNaN(number) || math.IsInf(number, 0) {
+					ok = false
+					errorMap[bound.tag] = "Please provide a valid number."
+				} else {
+					*bound.value = &number
+				}
+			}
+		}
+		if field.Min != nil && field.Max != nil && *field.Min > *field.Max {
+			ok = false
+			errorMap[FieldMaxTag] = "Maximum must not be less than minimum."
+		}
+	}
+
+	if field.Type == FieldEnum {
+		for _, option := range strings.Split(r.FormValue(FieldOptionsTag), ",") {
+			if option = strings.TrimSpace(option); option != "" && !slices.Contains(field.Options, option) {
+				field.Options = append(field.Options, option)
+			}
+		}
+		if len(field.Options) < 2 {
+			ok = false
+			errorMap[FieldOptionsTag] = "Please provide at least two comma-separated options."
+		}
+	}
+
+	return
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// Bühlmann ZHL-16C decompression model with gradient factors.
+//
+// Pressures are in bar, depths in meters of sea water (10 m = 1 bar), times in minutes. Compartment 1 uses the "1b"
+// half-time (5 min), as most dive computers and planners do.
+
+const (
+	Compartments    = 16
+	SurfacePressure = 1.01325 // bar, at sea level
+	BarPerMeter     = 0.1     // bar/m of sea water
+	WaterVapour     = 0.0627  // bar, alveolar water vapour pressure (Bühlmann)
+	AirFN2          = 0.7902  // fraction of inert gas (N2 + Ar) in air, as used by Bühlmann
+
+	StopInterval  = 3.0   // m
+	LastStopDepth = 3.0   // m
+	MaxNDL        = 300.0 // min, reported when there is no practical no-decompression limit
+	MaxDecoTime   = 24 * 60.0
+	MaxDecoPPO2   = 1.6 // bar, max. ppO2 of a deco gas
+	CheckInterval = 1.0 // min, of dive time between NDL and TTS checks when evaluating a profile
+
+	DefaultGFLow  = 30 // %
+	DefaultGFHigh = 70 // %
+)
+
+var (
+	n2HalfTimes = [Compartments]float64{
+		5.0, 8.0, 12.5, 18.5, 27.0, 38.3, 54.3, 77.0, 109.0, 146.0, 187.0, 239.0, 305.0, 390.0, 498.0, 635.0,
+	}
+	n2A = [Compartments]float64{
+		1.1696, 1.0000, 0.8618, 0.7562, 0.6200, 0.5043, 0.4410, 0.4000,
+		0.3750, 0.3500, 0.3295, 0.3065, 0.2835, 0.2610, 0.2480, 0.2327,
+	}
+	n2B = [Compartments]float64{
+		0.5578, 0.6514, 0.7222, 0.7825, 0.8126, 0.8434, 0.8693, 0.8910,
+		0.9092, 0.9222, 0.9319, 0.9403, 0.9477, 0.9544, 0.9602, 0.9653,
+	}
+	heHalfTimes = [Compartments]float64{
+		1.88, 3.02, 4.72, 6.99, 10.21, 14.48, 20.53, 29.11, 41.20, 55.19, 70.69, 90.34, 115.29, 147.42, 188.24, 240.03,
+	}
+	heA = [Compartments]float64{
+		1.6189, 1.3830, 1.1919, 1.0458, 0.9220, 0.8205, 0.7305, 0.6502,
+		0.5950, 0.5545, 0.5333, 0.5189, 0.5181, 0.5176, 0.5172, 0.5119,
+	}
+	heB = [Compartments]float64{
+		0.4770, 0.5747, 0.6527, 0.7223, 0.7582, 0.7957, 0.8279, 0.8553,
+		0.8757, 0.8903, 0.8997, 0.9073, 0.9122, 0.9171, 0.9217, 0.9267,
+	}
+)
+
+// Tissues holds inert gas pressures in all compartments.
+type Tissues struct {
+	N2 [Compartments]float64
+	He [Compartments]float64
+}
+
+// DecoModel is the ZHL-16C model parametrized by gradient factors (fractions, e.g. 0.3 and 0.7).
+type DecoModel struct {
+	GFLow  float64
+	GFHigh float64
+}
+
+// DecoStop is a single stop of a decompression schedule.
+type DecoStop struct {
+	Depth   float64
+	Minutes float64
+	Gas     GasMix
+}
+
+// DecoResult is the outcome of evaluating a dive profile with a `DecoModel`.
+type DecoResult struct {
+	GFLow        float64
+	GFHigh       float64
+	RequiredDeco bool                  // at some point of the dive, a direct ascent to the surface was not allowed
+	MaxCeiling   float64               // deepest ceiling during the dive, in meters
+	MinNDL       float64               // shortest no-decompression limit during the dive, in minutes
+	MaxTTS       float64               // longest time to surface during the dive, in minutes
+	Loading      [Compartments]float64 // tissue loading at the end of the dive, in % of the surface M-value
+	End          *Tissues              // tissue state at the end of the dive
+}
+
+// DefaultDecoModel returns the model with configured gradient factors.
+func DefaultDecoModel() *DecoModel {
+	return &DecoModel{GFLow: float64(config.gfLow) / 100, GFHigh: float64(config.gfHigh) / 100}
+}
+
+// SurfaceTissues returns tissues saturated with air at the surface.
+func SurfaceTissues() *Tissues {
+	t := &Tissues{}
+	for i := range t.N2 {
+		t.N2[i] = (SurfacePressure - WaterVapour) * AirFN2
+	}
+	return t
+}
+
+// AmbientPressure returns the absolute pressure at the given depth.
+func AmbientPressure(depth float64) float64 {
+	return SurfacePressure + depth*BarPerMeter
+}
+
+// inspired returns the inspired partial pressure of the inert gas fraction f at the given ambient pressure.
+func inspired(ambient float64, f float64) float64 {
+	return max(0, ambient-WaterVapour) * f
+}
+
+// schreiner returns the compartment pressure after breathing gas for t minutes, while the inspired pressure of the
+// gas changes linearly from pInsp at rate r (bar/min), starting from compartment pressure p0.
+func schreiner(p0 float64, pInsp float64, r float64, t float64, halfTime float64) float64 {
+	k := math.Ln2 / halfTime
+	return pInsp + r*(t-1/k) - (pInsp-p0-r/k)*math.Exp(-k*t)
+}
+
+// Load updates tissues for a segment of the dive: depth changing linearly from startDepth to endDepth, over minutes,
+// while breathing gas.
+func (t *Tissues) Load(startDepth float64, endDepth float64, minutes float64, gas GasMix) {
+	if minutes <= 0 {
+		return
+	}
+	rate := (endDepth - startDepth) * BarPerMeter / minutes
+	ambient := AmbientPressure(startDepth)
+	pN2, rN2 := inspired(ambient, gas.FN2()), rate*gas.FN2()
+	pHe, rHe := inspired(ambient, gas.FHe()), rate*gas.FHe()
+	if gas.O2 == 21 && gas.He == 0 {
+		pN2, rN2 = inspired(ambient, AirFN2), rate*AirFN2
+	}
+	for i := 0; i < Compartments; i++ {
+		t.N2[i] = schreiner(t.N2[i], pN2, rN2, minutes, n2HalfTimes[i])
+		t.He[i] = schreiner(t.He[i], pHe, rHe, minutes, heHalfTimes[i])
+	}
+}
+
+// coefficients returns Bühlmann a and b coefficients of a compartment, weighted by its N2 and He pressures.
+func (t *Tissues) coefficients(i int) (p float64, a float64, b float64) {
+	p = t.N2[i] + t.He[i]
+	if p <= 0 {
+		return 0, n2A[i], n2B[i]
+	}
+	a = (n2A[i]*t.N2[i] + heA[i]*t.He[i]) / p
+	b = (n2B[i]*t.N2[i] + heB[i]*t.He[i]) / p
+	return
+}
+
+// ToleratedAmbient returns the lowest ambient pressure tissues tolerate with gradient factor gf.
+func (t *Tissues) ToleratedAmbient(gf float64) float64 {
+	tolerated := 0.0
+	for i := 0; i < Compartments; i++ {
+		p, a, b := t.coefficients(i)
+		tolerated = max(tolerated, (p-a*gf)/(gf/b+1-gf))
+	}
+	return tolerated
+}
+
+// Ceiling returns the shallowest depth tissues tolerate with gradient factor gf, or 0 if surfacing is allowed.
+func (t *Tissues) Ceiling(gf float64) float64 {
+	return max(0, (t.ToleratedAmbient(gf)-SurfacePressure)/BarPerMeter)
+}
+
+// Loading returns pressures in all compartments as percentages of the respective M-values at the surface.
+func (t *Tissues) Loading() (loading [Compartments]float64) {
+	for i := 0; i < Compartments; i++ {
+		p, a, b := t.coefficients(i)
+		loading[i] = 100 * p / (SurfacePressure/b + a)
+	}
+	return
+}
+
+// NDL returns the no-decompression limit at the given depth (in minutes): how long tissues can stay at the depth,
+// breathing gas, and still ascend directly to the surface within GF high.
+func (m *DecoModel) NDL(tissues *Tissues, depth float64, gas GasMix) float64 {
+	t := *tissues
+	for minutes := 0.0; minutes < MaxNDL; minutes++ {
+		if t.Ceiling(m.GFHigh) > 0 {
+			return minutes
+		}
+		t.Load(depth, depth, 1, gas)
+	}
+	return MaxNDL
+}
+
+// gfAt returns the gradient factor at the given depth, which increases linearly from GF low at the first stop to
+// GF high at the surface.
+func (m *DecoModel) gfAt(depth float64, firstStop float64) float64 {
+	if firstStop <= 0 {
+		return m.GFHigh
+	}
+	return m.GFHigh - (m.GFHigh-m.GFLow)*min(depth, firstStop)/firstStop
+}
+
+// BestGas returns the gas from the list with the highest fraction of oxygen that can be breathed at the given depth.
+// If there is none, current is returned.
+func BestGas(gases []GasMix, depth float64, current GasMix) GasMix {
+	best := current
+	for _, gas := range gases {
+		if gas.FO2()*AmbientPressure(depth) <= MaxDecoPPO2+1e-9 && gas.O2 > best.O2 {
+			best = gas
+		}
+	}
+	return best
+}
+
+// Ascent simulates the ascent from depth to the surface, with the decompression stops required by the model,
+// switching to the best available gas at every stop. Tissues are updated. It returns the stops (including the ones
+// where the gas was switched without waiting) and the total time to surface.
+func (m *DecoModel) Ascent(t *Tissues, depth float64, gas GasMix, gases []GasMix) (stops []DecoStop, tts float64) {
+	firstStop := math.Ceil(t.Ceiling(m.GFLow)/StopInterval) * StopInterval
+	if firstStop > 0 && firstStop < LastStopDepth {
+		firstStop = LastStopDepth
+	}
+	if firstStop >= depth {
+		firstStop = math.Floor(depth/StopInterval) * StopInterval
+	}
+
+	ascend := func(from float64, to float64) {
+		minutes := (from - to) / AscentRate
+		t.Load(from, to, minutes, gas)
+		tts += minutes
+	}
+
+	if firstStop <= 0 {
+		ascend(depth, 0)
+		return nil, tts
+	}
+
+	ascend(depth, firstStop)
+	for stop := firstStop; stop > 0; {
+		next := max(0, stop-StopInterval)
+		if next > 0 && next < LastStopDepth {
+			next = 0
+		}
+		switched := BestGas(gases, stop, gas) != gas
+		gas = BestGas(gases, stop, gas)
+		waited := 0.0
+		for t.Ceiling(m.gfAt(next, firstStop)) > next && tts < MaxDecoTime {
+			t.Load(stop, stop, 1, gas)
+			waited++
+			tts++
+		}
+		if waited > 0 || switched {
+			stops = append(stops, DecoStop{Depth: stop, Minutes: waited, Gas: gas})
+		}
+		ascend(stop, next)
+		stop = next
+	}
+	return stops, tts
+}
+
+// Evaluate runs the profile through the model, starting from the given tissue state (or tissues saturated at the
+// surface, if nil). Gas switches in the profile refer to gases by 1-based index. Ceilings are checked on every
+// sample, NDL and TTS only once per `CheckInterval` and on gas switches, as profiles from dive computers are sampled
+// every few seconds.
+func (m *DecoModel) Evaluate(profile []ProfileSample, gases []GasMix, initial *Tissues) *DecoResult {
+	result := &DecoResult{GFLow: m.GFLow, GFHigh: m.GFHigh, MinNDL: MaxNDL}
+	if len(gases) == 0 {
+		gases = []GasMix{Air}
+	}
+
+	tissues := SurfaceTissues()
+	if initial != nil {
+		*tissues = *initial
+	}
+
+	sinceCheck := CheckInterval
+	WalkProfile(profile, gases, func(startDepth, depth, minutes float64, gas, next GasMix) {
+		tissues.Load(startDepth, depth, minutes, gas)
+		sinceCheck += minutes
+
+		ceiling := tissues.Ceiling(m.GFHigh)
+		result.MaxCeiling = max(result.MaxCeiling, ceiling)
+		if ceiling > 0 {
+			result.RequiredDeco = true
+		}
+		if depth > 0 && (sinceCheck >= CheckInterval || next != gas) {
+			sinceCheck = 0
+			result.MinNDL = min(result.MinNDL, m.NDL(tissues, depth, next))
+			ascent := *tissues
+			_, tts := m.Ascent(&ascent, depth, next, gases)
+			result.MaxTTS = max(result.MaxTTS, tts)
+		}
+	})
+
+	result.Loading = tissues.Loading()
+	result.End = tissues
+	return result
+}
+
+// Deco returns the result of evaluating the dive's profile with the default model, starting from the given tissue
+// state (nil for tissues saturated at the surface), or nil if the dive has no profile.
+func (d *Dive) Deco(initial *Tissues) *DecoResult {
+	profile := d.Profile()
+	if profile == nil {
+		return nil
+	}
+	return DefaultDecoModel().Evaluate(profile, d.Data.BreathingGases(), initial)
+}
+
+// FlagMismatch reports whether the manually set deco flag of a dive disagrees with the computed result.
+func (r *DecoResult) FlagMismatch(dive *Dive) bool {
+	return r.RequiredDeco != dive.Data.DecoDive
+}
+
+// FormattedGF returns gradient factors of the model as percentages, e.g. "30/70".
+func (r *DecoResult) FormattedGF() string {
+	return fmt.Sprintf("%.0f/%.0f", r.GFLow*100, r.GFHigh*100)
+}
+
+// FormattedLoading returns tissue loadings rounded to whole percentages, for display.
+func (r *DecoResult) FormattedLoading() []struct{ Compartment, Percent int } {
+	loading := make([]struct{ Compartment, Percent int }, 0, Compartments)
+	for i, l := range r.Loading {
+		loading = append(loading, struct{ Compartment, Percent int }{i + 1, int(math.Round(l))})
+	}
+	return loading
+}
+
+// FormattedNDL returns the shortest no-decompression limit, for display.
+func (r *DecoResult) FormattedNDL() string {
+	if r.MinNDL >= MaxNDL {
+		return fmt.Sprintf("> %d min", int(MaxNDL))
+	}
+	return fmt.Sprintf("%d min", int(r.MinNDL))
+}
@@ -47,6 +47,15 @@ func (d *Dive) reconstructFrom(diveRecord *DiveRecord) (*Dive, error) {
 	return d, nil
 }
 
+// newDiveUID returns a random, unique identifier of a dive record (see `DiveRecord.UID`).
+func newDiveUID() string {
+	uid, err := RandHexString(16)
+	if err != nil {
+		panic(fmt.Errorf("crypto/rand failed: %v", err))
+	}
+	return uid
+}
+
 // ID returns the ID of the dive.
 func (d *Dive) ID() string {
 	return d.id
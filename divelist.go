@@ -1,7 +1,19 @@
 package main
 
+import "time"
+
 type DiveList []*Dive
 
+// DiveSummary holds aggregate statistics of a list of dives.
+type DiveSummary struct {
+	Dives      int
+	BottomTime time.Duration
+	MaxDepth   float32
+	AvgDepth   float32 // mean of average depths, over dives with a known average depth
+	First      *Dive
+	Last       *Dive
+}
+
 func (s DiveList) Filter(predicate func(*Dive) bool) []*Dive {
 	filtered := make([]*Dive, 0, len(s))
 	for _, dive := range s {
@@ -11,3 +23,33 @@ func (s DiveList) Filter(predicate func(*Dive) bool) []*Dive {
 	}
 	return filtered
 }
+
+// Summary returns aggregate statistics of dives in the list. The list must be in chronological order.
+func (s DiveList) Summary() *DiveSummary {
+	var (
+		summary = &DiveSummary{Dives: len(s)}
+		sumAvg  float32
+		numAvg  int
+	)
+	for _, dive := range s {
+		summary.BottomTime += dive.Data.Duration.Value()
+		summary.MaxDepth = max(summary.MaxDepth, dive.Data.MaxDepth)
+		if dive.Data.AvgDepth > 0 {
+			sumAvg += dive.Data.AvgDepth
+			numAvg++
+		}
+	}
+	if numAvg > 0 {
+		summary.AvgDepth = sumAvg / float32(numAvg)
+	}
+	if len(s) > 0 {
+		summary.First = s[0]
+		summary.Last = s[len(s)-1]
+	}
+	return summary
+}
+
+// FormattedBottomTime returns the total bottom time in hours and minutes (e.g. "12h 05m").
+func (summary *DiveSummary) FormattedBottomTime() string {
+	return FormatHoursMinutes(summary.BottomTime)
+}
@@ -11,13 +11,28 @@ import (
 const (
 	InvalidIndex = -1
 
-	IDTag       = "id"
-	SiteTag     = "site"
-	DateTag     = "date"
-	TimeInTag   = "time_in"
-	DurationTag = "duration"
-	GeoTag      = "geo"
-	DecoDiveTag = "deco_dive"
+	IDTag         = "id"
+	SiteTag       = "site"
+	DateTag       = "date"
+	TimeInTag     = "time_in"
+	DurationTag   = "duration"
+	GeoTag        = "geo"
+	DecoDiveTag   = "deco_dive"
+	NoteTag       = "note"
+	TripTag       = "trip"
+	BuddiesTag    = "buddies"
+	GuideTag      = "guide"
+	InstructorTag = "instructor"
+	GearTag       = "gear"
+	MaxDepthTag   = "max_depth"
+	AvgDepthTag   = "avg_depth"
+	GasesTag      = "gases"
+	ProfileTag    = "profile"
+
+	TankVolumeTag        = "tank_volume"
+	TankPressureStartTag = "tank_pressure_start"
+	TankPressureEndTag   = "tank_pressure_end"
+	ConfirmWarningsTag   = "confirm_warnings"
 
 	TimeLayout                = "15:04"
 	DateLayout                = "2006-01-02"
@@ -30,17 +45,52 @@ const (
 type DiveLog struct {
 	sync.RWMutex
 
-	dives         map[string]*Dive //
-	sorted        DiveList         //
-	renumbered    atomic.Bool      //
-	sequence      uint64           // persistence: always one ahead from persistent storage, incremented on save
-	lastPersisted time.Time        // persistence: read from persistent storage, set on save
+	dives            map[string]*Dive        //
+	sorted           DiveList                //
+	renumbered       atomic.Bool             //
+	index            *SearchIndex            // full-text search over sites, locations and notes
+	sites            *SiteCatalog            // dive sites referenced by dives
+	trips            map[string]*Trip        // trips dives are assigned to
+	people           map[string]*Person      // people directory: buddies, guides and instructors on dives
+	directory        []*Person               // people directory ordered by name (see `People`)
+	gear             map[string]*GearItem    // equipment inventory
+	plans            map[string]*Plan        // saved dive plans
+	trash            map[string]*TrashedDive // deleted dives, until restored or purged
+	history          []*Revision             // changes of dive records, in order (see `Change`)
+	pending          *Change                 // change in progress, persisted once committed
+	derived          derivedCache            // results computed from dives, until the log changes
+	dir              string                  // persistence: directory of the log file, or empty if the log is in memory only
+	sequence         uint64                  // persistence: always one ahead from persistent storage, incremented on save
+	lastPersisted    time.Time               // persistence: read from persistent storage, set on save
+	historyPersisted int                     // persistence: number of revisions in persistent storage
+}
+
+// derivedCache holds results that are expensive to compute from dives, and are dropped whenever the log changes (see
+// `DiveLog.persist`). It is locked separately, as it is filled by readers holding only the read lock of the log.
+type derivedCache struct {
+	sync.Mutex
+	repetitive  map[*Dive]*Repetitive
+	consumption *ConsumptionStats
+}
+
+func (c *derivedCache) reset() {
+	c.Lock()
+	c.repetitive = nil
+	c.consumption = nil
+	c.Unlock()
 }
 
 func NewDiveLog() *DiveLog {
 	return &DiveLog{
 		dives:  make(map[string]*Dive),
 		sorted: make(DiveList, 0),
+		index:  NewSearchIndex(),
+		sites:  NewSiteCatalog(),
+		trips:  make(map[string]*Trip),
+		people: make(map[string]*Person),
+		gear:   make(map[string]*GearItem),
+		plans:  make(map[string]*Plan),
+		trash:  make(map[string]*TrashedDive),
 	}
 }
 
@@ -52,7 +102,19 @@ func (dl *DiveLog) Find(id string) *Dive {
 	return dl.dives[id]
 }
 
+// FindUID returns the dive with the record of the given UID (see `DiveRecord.UID`), or nil.
+func (dl *DiveLog) FindUID(uid string) *Dive {
+	for _, dive := range dl.sorted {
+		if dive.Data.UID == uid {
+			return dive
+		}
+	}
+	return nil
+}
+
 // Reconstruct `dives` from a list of dive records. Also, make sure `sorted` is initialized with a sorted dive list.
+// Dives that don't reference a site from the catalog (e.g. loaded from an older log format) are linked to a site
+// by name, and new sites are added to the catalog as needed.
 func (dl *DiveLog) Reconstruct(diveRecords []*DiveRecord) error {
 	dives := make(DiveList, 0, len(diveRecords))
 	for i, diveRecord := range diveRecords {
@@ -67,10 +129,19 @@ func (dl *DiveLog) Reconstruct(diveRecords []*DiveRecord) error {
 
 	sort.Slice(dives, func(i int, j int) bool { return dives[i].DateTimeIn.Before(dives[j].DateTimeIn) })
 	dl.sorted = dives
+	dl.index = NewSearchIndex()
 
 	for ix, dive := range dl.sorted {
 		dive.ix = ix
+		if dive.Data.UID == "" { // logged before records had one
+			dive.Data.UID = newDiveUID()
+		}
+		dl.linkSite(dive)
+		dl.linkTrip(dive)
+		dl.linkPeople(dive)
+		dl.linkGear(dive)
 		dl.dives[dive.id] = dive
+		dl.index.Add(dive)
 	}
 
 	dl.renumbered.Store(false)
@@ -78,8 +149,19 @@ func (dl *DiveLog) Reconstruct(diveRecords []*DiveRecord) error {
 	return nil
 }
 
+// Insert adds the dive to the log. A record without a UID, or with one already taken (e.g. an imported copy), is
+// assigned a new one.
 func (dl *DiveLog) Insert(dive *Dive) {
+	if dive.Data.UID == "" || dl.FindUID(dive.Data.UID) != nil {
+		dive.Data.UID = newDiveUID()
+	}
+	dl.touch(dive.id)
+	dl.linkSite(dive)
+	dl.linkTrip(dive)
+	dl.linkPeople(dive)
+	dl.linkGear(dive)
 	dl.dives[dive.id] = dive
+	dl.index.Add(dive)
 	dl.sorted = append(dl.sorted, dive)
 	dive.ix = len(dl.sorted) - 1
 	if len(dl.sorted) > 1 {
@@ -94,11 +176,13 @@ func (dl *DiveLog) Insert(dive *Dive) {
 		dl.renumbered.Store(true)
 	}
 
-	go saveAsync()
+	dl.persist()
 }
 
+// Replace replaces the existing dive with the new one, which keeps the ID and UID of the existing dive.
 func (dl *DiveLog) Replace(existing *Dive, new *Dive) {
 	new.id = existing.id
+	new.Data.UID = existing.Data.UID
 	// TODO: Figure out a smarter way to do this, including TimeIn.
 	dl.Delete(existing.id)
 	dl.Insert(new)
@@ -113,7 +197,9 @@ func (dl *DiveLog) Delete(id string) (found bool) {
 	if !found {
 		return
 	}
+	dl.touch(id)
 	delete(dl.dives, id)
+	dl.index.Remove(id)
 
 	if dive.ix < len(dl.sorted)-1 {
 		for i := dive.ix; i < len(dl.sorted)-1; i++ {
@@ -126,6 +212,22 @@ func (dl *DiveLog) Delete(id string) (found bool) {
 	return
 }
 
+// Search returns dives matching the full-text query, ordered by relevance (most relevant first). Dives with equal
+// relevance are ordered from the most recent one (see `Cursor`).
+func (dl *DiveLog) Search(query string) []SearchHit {
+	scores := dl.index.Search(query)
+	hits := make([]SearchHit, 0, len(scores))
+	for id, score := range scores {
+		if dive := dl.dives[id]; dive != nil {
+			hits = append(hits, SearchHit{Dive: dive, Score: score})
+		}
+	}
+	sort.Slice(hits, func(i int, j int) bool {
+		return RankedCursor(hits[i].Dive, hits[i].Score).Precedes(RankedCursor(hits[j].Dive, hits[j].Score))
+	})
+	return hits
+}
+
 func (dl *DiveLog) IsRenumbered() bool {
 	return dl.renumbered.CompareAndSwap(true, false)
 }
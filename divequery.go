@@ -0,0 +1,154 @@
+package main
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	PageSize    = 10
+	MaxPageSize = 100
+
+	BuddyQueryTag  = "buddy"
+	CursorQueryTag = "cursor"
+	LimitQueryTag  = "limit"
+)
+
+// DiveQuery is a set of filters applied to the dive log, and a position within the (filtered) results. It is shared
+// by the hypermedia and JSON handlers, so both interpret the same URL query the same way.
+type DiveQuery struct {
+	Search string
+	Trip   string
+	Buddy  string // ID of a person on the dive, in any role
+	Tag    string
+	Field  string // ID of a custom field, set on matching dives (see `CustomField.Matches`)
+	Value  string // of the custom field
+	Before time.Time
+	After  time.Time
+	Cursor *Cursor
+	Limit  int
+}
+
+// DiveQueryResult is a single page of dives matched by a `DiveQuery`.
+type DiveQueryResult struct {
+	Dives DiveList
+	Total int     // number of dives matching the filters, across all pages
+	Next  *Cursor // position of the last dive in Dives, or nil if there are no more matching dives
+}
+
+// ParseDiveQuery reads the query from URL query parameters. Invalid filter values are ignored; an invalid cursor is
+// reported through ErrInvalidCursor, along with a query that starts from the first page.
+func ParseDiveQuery(values url.Values) (*DiveQuery, error) {
+	var err error
+
+	query := &DiveQuery{
+		Search: strings.TrimSpace(values.Get(SearchQueryTag)),
+		Trip:   values.Get(TripTag),
+		Buddy:  values.Get(BuddyQueryTag),
+		Tag:    NormalizeTag(values.Get(TagQueryTag)),
+		Field:  values.Get(FieldQueryTag),
+		Value:  strings.TrimSpace(values.Get(FieldValueQueryTag)),
+		Limit:  DefaultPageSize(),
+	}
+
+	if beforeValue := values.Get(BeforeQueryTag); beforeValue != "" {
+		if beforeDate, parseErr := time.Parse(DateLayout, beforeValue); parseErr == nil {
+			query.Before = beforeDate
+		}
+	}
+
+	if afterValue := values.Get(AfterQueryTag); afterValue != "" {
+		if afterDate, parseErr := time.Parse(DateLayout, afterValue); parseErr == nil {
+			query.After = afterDate
+		}
+	}
+
+	if limit, parseErr := strconv.Atoi(values.Get(LimitQueryTag)); parseErr == nil && limit > 0 {
+		query.Limit = min(limit, MaxPageSize)
+	}
+
+	if cursorValue := values.Get(CursorQueryTag); cursorValue != "" {
+		query.Cursor, err = DecodeCursor(cursorValue)
+	}
+
+	return query, err
+}
+
+// DefaultPageSize returns the configured number of dives on a single page.
+func DefaultPageSize() int {
+	if config.pageSize > 0 {
+		return min(config.pageSize, MaxPageSize)
+	}
+	return PageSize
+}
+
+// InDateRange reports whether the dive is within the date range of the query.
+func (q *DiveQuery) InDateRange(dive *Dive) bool {
+	return (q.Before.IsZero() || dive.DateTimeIn.Before(q.Before)) && (q.After.IsZero() || dive.DateTimeIn.After(q.After))
+}
+
+// Run executes the query. The caller must hold at least a read lock on dl.
+func (q *DiveQuery) Run(dl *DiveLog) *DiveQueryResult {
+	filtered, cursorOf := q.filter(dl)
+
+	// A cursor from a different kind of list (e.g. search query was cleared) can't be continued.
+	cursor := q.Cursor
+	if cursor != nil && cursor.Ranked != (q.Search != "") {
+		cursor = nil
+	}
+
+	result := &DiveQueryResult{Total: len(filtered)}
+	result.Dives, result.Next = Paginate(filtered, cursor, q.Limit, cursorOf)
+	return result
+}
+
+// All returns all dives matching the filters of the query, regardless of its position and limit, e.g. for exports.
+// The caller must hold at least a read lock on dl.
+func (q *DiveQuery) All(dl *DiveLog) DiveList {
+	filtered, _ := q.filter(dl)
+	return filtered
+}
+
+// filter returns dives matching the filters, ordered by relevance to the search query, if any, or chronologically,
+// along with the cursor function of the order.
+func (q *DiveQuery) filter(dl *DiveLog) (DiveList, func(*Dive) *Cursor) {
+	var (
+		filtered = dl.All()
+		cursorOf = ChronoCursor
+	)
+
+	if q.Search != "" {
+		hits := dl.Search(q.Search)
+		scores := make(map[string]float64, len(hits))
+		filtered = make(DiveList, 0, len(hits))
+		for _, hit := range hits {
+			scores[hit.Dive.id] = hit.Score
+			filtered = append(filtered, hit.Dive)
+		}
+		cursorOf = func(dive *Dive) *Cursor { return RankedCursor(dive, scores[dive.id]) }
+	}
+
+	if q.Trip != "" {
+		filtered = filtered.Filter(func(dive *Dive) bool { return dive.Data.TripID == q.Trip })
+	}
+
+	if q.Buddy != "" {
+		filtered = filtered.Filter(func(dive *Dive) bool { return dive.Data.Role(q.Buddy) != "" })
+	}
+
+	if q.Tag != "" {
+		filtered = filtered.Filter(func(dive *Dive) bool { return dive.Data.HasTag(q.Tag) })
+	}
+
+	if field := CustomFields.Find(q.Field); field != nil {
+		filtered = filtered.Filter(func(dive *Dive) bool { return field.Matches(dive.Data.Custom[field.ID], q.Value) })
+	}
+
+	if !q.Before.IsZero() || !q.After.IsZero() {
+		filtered = filtered.Filter(q.InDateRange)
+	}
+
+	return filtered, cursorOf
+}
@@ -1,40 +1,90 @@
 package main
 
+import (
+	"maps"
+	"slices"
+)
+
 // DiveRecord is a set of dive parameters denoted in human-readable format. It can be constructed from human input
 // or unmarshalled from structured data (JSON). Data may be read from a network request (e.g. HTTP form / JSON object),
 // or from the disk (e.g. JSON file / relational database). It can be marshalled to a structured format (JSON).
 // When loaded into memory, it holds the data referenced by application's model of a dive (see `Dive`).
 type DiveRecord struct {
+	UID string `json:"uid,omitempty"` // assigned by the log; unlike the ID of a dive, it doesn't follow the date and time
+
 	DateTime string   `json:"date_time"` // mandatory fields
 	Duration Duration `json:"duration"`  //
 	Site     string   `json:"site"`      //
 
-	Geo      string  `json:"geo,omitempty"`       // optional fields
-	MaxDepth float32 `json:"max_depth,omitempty"` //
-	AvgDepth float32 `json:"avg_depth,omitempty"` //
-	DecoDive bool    `json:"deco_dive"`           // flags should be explicit, so no omitempty
+	SiteID            string             `json:"site_id,omitempty"`             // optional fields; reference to the site catalog (see `Site`)
+	Geo               string             `json:"geo,omitempty"`                 //
+	Entry             *LatLon            `json:"entry,omitempty"`               // GPS positions; if not set, the site's position is used (see `Position`)
+	Exit              *LatLon            `json:"exit,omitempty"`                //
+	TripID            string             `json:"trip_id,omitempty"`             // reference to a trip (see `Trip`)
+	People            []*DiveParticipant `json:"people,omitempty"`              // buddies, guides and instructors (see `Person`)
+	Gear              []string           `json:"gear,omitempty"`                // IDs of gear items used on the dive (see `GearItem`)
+	MaxDepth          float32            `json:"max_depth,omitempty"`           //
+	AvgDepth          float32            `json:"avg_depth,omitempty"`           //
+	DecoDive          bool               `json:"deco_dive"`                     // flags should be explicit, so no omitempty
+	Gases             []GasMix           `json:"gases,omitempty"`               // breathing gases; the first one is the bottom gas (see `GasMix`)
+	Profile           []ProfileSample    `json:"profile,omitempty"`             // depth samples (see `ProfileSample`)
+	TankVolume        float32            `json:"tank_volume,omitempty"`         // water capacity in liters; if not set, taken from the tank in `Gear`
+	TankPressureStart int                `json:"tank_pressure_start,omitempty"` // bar
+	TankPressureEnd   int                `json:"tank_pressure_end,omitempty"`   // bar
+	Note              string             `json:"note,omitempty"`                //
+	Tags              []string           `json:"tags,omitempty"`                // free-form labels, normalized (see `ParseTags`)
+	Custom            map[string]any     `json:"custom,omitempty"`              // values of custom fields by field ID (see `CustomField`)
+	Attachments       []*Attachment      `json:"attachments,omitempty"`         // photos, videos and documents (see `Attachment`)
+	Verification      *Verification      `json:"verification,omitempty"`        // instructor's signature (see `Verification`)
 
 	// AirTemp           float32 `json:"air_temp"`            //
 	// Altitude          uint    `json:"altitude"`            //
 	// BodyOfWater       string  `json:"body_of_water"`       //
-	// CNSEnd            uint    `json:"cns_end"`             //
-	// CNSStart          uint    `json:"cns_start"`           //
 	// Current           string  `json:"current"`             //
-	// DiveComputer      string  `json:"dive_computer"`       //
 	// Entry             string  `json:"entry"`               //
-	// Gas               string  `json:"gas"`                 //
 	// NightDive         bool    `json:"night_dive"`          //
-	// Note              string  `json:"note"`                //
-	// O2                uint    `json:"o2"`                  //
 	// Operator          string  `json:"operator"`            //
 	// PerfectWeight     bool    `json:"perfect_weight"`      //
-	// Suit              string  `json:"suit"`                //
-	// TankPressureEnd   uint    `json:"tank_pressure_end"`   //
-	// TankPressureStart uint    `json:"tank_pressure_start"` //
-	// TankType          string  `json:"tank_type"`           //
 	// Visibility        string  `json:"visibility"`          //
 	// WaterMaxTemp      float32 `json:"water_max_temp"`      //
 	// WaterMinTemp      float32 `json:"water_min_temp"`      //
 	// Weather           string  `json:"weather"`             //
 	// Weights           uint    `json:"weights"`             //
 }
+
+// Clone returns a deep copy of the record.
+func (dr *DiveRecord) Clone() *DiveRecord {
+	clone := *dr
+	if dr.People != nil {
+		clone.People = make([]*DiveParticipant, 0, len(dr.People))
+		for _, participant := range dr.People {
+			p := *participant
+			clone.People = append(clone.People, &p)
+		}
+	}
+	if dr.Attachments != nil {
+		clone.Attachments = make([]*Attachment, 0, len(dr.Attachments))
+		for _, attachment := range dr.Attachments {
+			a := *attachment
+			clone.Attachments = append(clone.Attachments, &a)
+		}
+	}
+	clone.Gear = slices.Clone(dr.Gear)
+	clone.Tags = slices.Clone(dr.Tags)
+	clone.Custom = maps.Clone(dr.Custom) // values are strings, numbers or booleans
+	clone.Gases = slices.Clone(dr.Gases)
+	clone.Profile = slices.Clone(dr.Profile)
+	if dr.Entry != nil {
+		entry := *dr.Entry
+		clone.Entry = &entry
+	}
+	if dr.Exit != nil {
+		exit := *dr.Exit
+		clone.Exit = &exit
+	}
+	if dr.Verification != nil {
+		v := *dr.Verification
+		clone.Verification = &v
+	}
+	return &clone
+}
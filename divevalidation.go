@@ -1,6 +1,9 @@
 package main
 
 import (
+	"fmt"
+	"math"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -50,3 +53,249 @@ func validateDurationInMinInput(inputStr string) (d time.Duration, errMsg string
 	}
 	return
 }
+
+func validateDepthInput(inputStr string) (depth float32, errMsg string) {
+	value, err := strconv.ParseFloat(strings.TrimSpace(inputStr), 32)
+	if err != nil {
+		errMsg = "Please provide a valid depth in meters."
+	} else if value <= 0 || value > 350 {
+		errMsg = "Depth must be between 0 and 350 meters."
+	} else {
+		depth = float32(value)
+	}
+	return
+}
+
+func validatePositionInput(inputStr string) (position *LatLon, errMsg string) {
+	position, err := ParseLatLon(inputStr)
+	if err != nil {
+		errMsg = "Please provide a position as latitude and longitude, e.g. -8.7953, 115.5270 or 8°47'43\"S 115°31'37\"E."
+	} else if position.Lat < -90 || position.Lat > 90 || position.Lon < -180 || position.Lon > 180 {
+		position, errMsg = nil, "Latitude must be between -90 and 90, and longitude between -180 and 180 degrees."
+	}
+	return
+}
+
+func validateCountInput(inputStr string) (n int, errMsg string) {
+	n, err := strconv.Atoi(strings.TrimSpace(inputStr))
+	if err != nil || n < 0 {
+		n, errMsg = 0, "Please provide a whole, non-negative number."
+	}
+	return
+}
+
+func validateTankVolumeInput(inputStr string) (volume float32, errMsg string) {
+	value, err := strconv.ParseFloat(strings.TrimSpace(inputStr), 32)
+	if err != nil {
+		errMsg = "Please provide a valid tank volume in liters."
+	} else if value < 1 || value > 50 {
+		errMsg = "Tank volume must be between 1 and 50 liters."
+	} else {
+		volume = float32(value)
+	}
+	return
+}
+
+func validateGradientFactorInput(inputStr string) (gf int, errMsg string) {
+	gf, err := strconv.Atoi(strings.TrimSpace(inputStr))
+	if err != nil || gf < 1 || gf > 100 {
+		gf, errMsg = 0, "Please provide a gradient factor between 1 and 100 (%)."
+	}
+	return
+}
+
+func validateSACInput(inputStr string) (sac float64, errMsg string) {
+	sac, err := strconv.ParseFloat(strings.TrimSpace(inputStr), 64)
+	if err != nil || sac < 5 || sac > 60 {
+		sac, errMsg = 0, "Please provide a surface air consumption between 5 and 60 L/min."
+	}
+	return
+}
+
+func validatePressureInput(inputStr string) (pressure int, errMsg string) {
+	pressure, err := strconv.Atoi(strings.TrimSpace(inputStr))
+	if err != nil || pressure < 0 || pressure > 350 {
+		pressure, errMsg = 0, "Please provide a tank pressure between 0 and 350 bar."
+	}
+	return
+}
+
+// validateGasesInput validates a list of gases against the maximum depth of the dive: the partial pressure of oxygen
+// in the bottom gas (the first one) must not exceed the configured limit, and in other gases `MaxDecoPPO2`.
+func validateGasesInput(inputStr string, maxDepth float32) (gases []GasMix, errMsg string) {
+	gases, err := ParseGases(inputStr)
+	if err != nil {
+		return nil, "Please provide a comma-separated list of gases, e.g. \"air, EAN50\"."
+	}
+	if maxDepth <= 0 || len(gases) == 0 {
+		return
+	}
+	if bottom := gases[0]; bottom.PartialPressure(float64(maxDepth)) > config.maxPPO2+1e-9 {
+		return nil, fmt.Sprintf("Bottom gas %s exceeds ppO2 of %.2f bar at %.1f m (its MOD is %.1f m).",
+			bottom, config.maxPPO2, maxDepth, bottom.MOD(config.maxPPO2))
+	}
+	return
+}
+
+// validateProfileGasesInput validates partial pressures of oxygen actually breathed according to the profile: up to
+// the configured limit on the bottom gas (the first one), and up to `MaxDecoPPO2` on other gases.
+func validateProfileGasesInput(profile []ProfileSample, gases []GasMix) (errMsg string) {
+	WalkProfile(profile, gases, func(startDepth, endDepth, minutes float64, gas, _ GasMix) {
+		limit := MaxDecoPPO2
+		if len(gases) == 0 || gas == gases[0] {
+			limit = config.maxPPO2
+		}
+		if depth := max(startDepth, endDepth); errMsg == "" && gas.PartialPressure(depth) > limit+1e-9 {
+			errMsg = fmt.Sprintf("Gas %s is breathed at %.1f m, which exceeds ppO2 of %.2f bar (its MOD is %.1f m).",
+				gas, depth, limit, gas.MOD(limit))
+		}
+	})
+	return
+}
+
+func validateTagsInput(inputStr string) (tags []string, errMsg string) {
+	tags, err := ParseTags(inputStr)
+	if err != nil {
+		return nil, fmt.Sprintf("Please provide comma-separated tags of letters, digits, spaces, \"-\" or \"_\", up to %d characters each.", MaxTagLength)
+	}
+	return
+}
+
+// validateCustomFieldInput validates a value of the custom field. Custom fields are optional, so an empty value is
+// valid, and is nil.
+func validateCustomFieldInput(field *CustomField, inputStr string) (value any, errMsg string) {
+	inputStr = strings.TrimSpace(inputStr)
+	if inputStr == "" {
+		return nil, ""
+	}
+	switch field.Type {
+	case FieldText:
+		value = inputStr
+	case FieldNumber:
+		number, err := strconv.ParseFloat(inputStr, 64)
+		if err != nil || math.IsNaN(number) || math.IsInf(number, 0) {
+			return nil, fmt.Sprintf("Please provide a valid number for %s.", field.Name)
+		}
+		if (field.Min != nil && number < *field.Min) || (field.Max != nil && number > *field.Max) {
+			return nil, fmt.Sprintf("%s must be %s.", field.Name, field.Range())
+		}
+		value = number
+	case FieldBool:
+		flag, err := strconv.ParseBool(inputStr)
+		if err != nil {
+			return nil, fmt.Sprintf("Please choose yes or no for %s.", field.Name)
+		}
+		value = flag
+	case FieldEnum:
+		if !slices.Contains(field.Options, inputStr) {
+			return nil, fmt.Sprintf("Please choose one of: %s.", strings.Join(field.Options, ", "))
+		}
+		value = inputStr
+	}
+	return
+}
+
+// validateAvgDepth validates the average depth against the maximum depth, if both are known.
+func validateAvgDepth(avgDepth float32, maxDepth float32) (errMsg string) {
+	if avgDepth > 0 && maxDepth > 0 && avgDepth > maxDepth {
+		errMsg = "Average depth must not be greater than maximum depth."
+	}
+	return
+}
+
+const (
+	// Dive times are on-site, without a time zone, so a dive is in the future only if it starts later than the current
+	// time in the easternmost time zone.
+	MaxTimeZoneOffset = 14 * time.Hour
+
+	// Dives closer together than MinSurfaceInterval are likely a single dive with a brief ascent, logged twice.
+	MinSurfaceInterval = 10 * time.Minute
+)
+
+// DiveIssues holds problems found by validating a dive record as a whole. Errors are hard, and the dive can't be saved
+// until they are fixed. Warnings are soft, and can be acknowledged by the diver. Both are keyed by the tag of the
+// input they concern, and their messages are client-facing.
+type DiveIssues struct {
+	Errors   map[string]string
+	Warnings map[string]string
+}
+
+// ValidateDive validates the dive record as a whole, and against its neighbours in the log. If the dive replaces an
+// existing one, the existing dive is not considered a neighbour. Fields of the record must be individually valid.
+func (dl *DiveLog) ValidateDive(dive *Dive, existing *Dive, now time.Time) *DiveIssues {
+	var (
+		issues = &DiveIssues{Errors: make(map[string]string), Warnings: make(map[string]string)}
+		data   = dive.Data
+	)
+
+	if dive.DateTimeIn.After(now.UTC().Add(MaxTimeZoneOffset)) {
+		issues.Errors[DateTag] = "Dive date and time must not be in the future."
+	}
+
+	if data.AvgDepth > 0 && data.MaxDepth <= 0 {
+		issues.Errors[MaxDepthTag] = "Please provide the maximum depth along with the average depth."
+	} else if errMsg := validateAvgDepth(data.AvgDepth, data.MaxDepth); errMsg != "" {
+		issues.Errors[AvgDepthTag] = errMsg
+	}
+
+	if data.TripID != "" && dl.FindTrip(data.TripID) == nil {
+		issues.Errors[TripTag] = "Please choose one of the trips, or none."
+	}
+
+	var prev, next *Dive
+	for _, other := range dl.sorted {
+		if other == existing {
+			continue
+		}
+		if other.DateTimeIn.After(dive.DateTimeIn) {
+			next = other
+			break
+		}
+		prev = other
+	}
+	if prev != nil {
+		if prev.TimeOut().After(dive.DateTimeIn) {
+			issues.Errors[TimeInTag] = fmt.Sprintf("The dive overlaps dive #%d, which ends at %s.",
+				prev.Num(), prev.TimeOut().Format("January 2, 2006. 15:04"))
+		} else if SurfaceIntervalBetween(prev, dive) < MinSurfaceInterval {
+			issues.Warnings[TimeInTag] = fmt.Sprintf("Only %s at the surface after dive #%d, is this a separate dive?",
+				FormatHoursMinutes(SurfaceIntervalBetween(prev, dive)), prev.Num())
+		}
+	}
+	if next != nil && dive.TimeOut().After(next.DateTimeIn) {
+		issues.Errors[DurationTag] = fmt.Sprintf("The dive overlaps dive #%d, which starts at %s.",
+			next.Num(), next.DateTimeIn.Format("January 2, 2006. 15:04"))
+	}
+
+	// An approximate (square) profile overstates the time spent at depth, so it can only tell that a dive didn't
+	// require decompression, not that it did.
+	if deco := dive.Deco(nil); deco != nil && deco.FlagMismatch(dive) {
+		if len(data.Profile) > 1 && deco.RequiredDeco {
+			issues.Warnings[DecoDiveTag] = "According to the profile, the dive required decompression."
+		} else if len(data.Profile) > 1 {
+			issues.Warnings[DecoDiveTag] = "According to the profile, the dive did not require decompression."
+		} else if data.DecoDive {
+			issues.Warnings[DecoDiveTag] = "The dive is too shallow or short to have required decompression."
+		}
+	}
+
+	return issues
+}
+
+func validateUsernameInput(inputStr string) (username string, errMsg string) {
+	if username = strings.ToLower(strings.TrimSpace(inputStr)); !usernamePattern.MatchString(username) {
+		username, errMsg = "", "Username must be 3 to 32 letters, digits, dots, dashes or underscores."
+	}
+	return
+}
+
+func validatePasswordInput(password string, confirmation string) (errMsg string) {
+	if len(password) < MinPasswordLength {
+		errMsg = fmt.Sprintf("Password must be at least %d characters long.", MinPasswordLength)
+	} else if len(password) > 72 { // bcrypt ignores the rest
+		errMsg = "Password must be at most 72 characters long."
+	} else if password != confirmation {
+		errMsg = "Passwords don't match."
+	}
+	return
+}
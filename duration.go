@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"time"
 )
 
@@ -38,3 +39,8 @@ func (d *Duration) UnmarshalJSON(b []byte) error {
 func (d *Duration) Value() time.Duration {
 	return d.Duration
 }
+
+// FormatHoursMinutes formats d as a number of hours and minutes (e.g. "12h 05m"), rounded down to a minute.
+func FormatHoursMinutes(d time.Duration) string {
+	return fmt.Sprintf("%dh %02dm", int(d.Hours()), int(d.Minutes())%60)
+}
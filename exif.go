@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+// Only as much of EXIF is read as is needed to tell when a photo was taken. Like dive times, EXIF times are on-site
+// (the camera's clock), without a time zone.
+
+const (
+	ExifTimeLayout = "2006:01:02 15:04:05"
+
+	exifTagDateTime         = 0x0132 // IFD0, time the file was last changed
+	exifTagExifIFD          = 0x8769 // IFD0, offset of the EXIF IFD
+	exifTagDateTimeOriginal = 0x9003 // EXIF IFD, time the photo was taken
+	exifTypeASCII           = 2
+	exifTypeLong            = 4
+)
+
+var ErrNoExifTime = errors.New("no EXIF time")
+
+// ExifTime returns the time a JPEG photo was taken, from the DateTimeOriginal tag of its EXIF metadata, or, if
+// missing, from the DateTime tag.
+func ExifTime(r io.Reader) (time.Time, error) {
+	br := bufio.NewReader(r)
+	if soi, err := br.Peek(2); err != nil || soi[0] != 0xFF || soi[1] != 0xD8 {
+		return time.Time{}, errors.New("not a JPEG image")
+	}
+	br.Discard(2)
+
+	// Metadata segments precede the image data, so the search stops at the start of scan.
+	for {
+		var marker [4]byte
+		if _, err := io.ReadFull(br, marker[:]); err != nil || marker[0] != 0xFF {
+			return time.Time{}, ErrNoExifTime
+		}
+		if marker[1] == 0xDA || marker[1] == 0xD9 {
+			return time.Time{}, ErrNoExifTime
+		}
+		length := int(binary.BigEndian.Uint16(marker[2:])) - 2
+		if length < 0 {
+			return time.Time{}, ErrNoExifTime
+		}
+		if marker[1] != 0xE1 { // APP1
+			if _, err := br.Discard(length); err != nil {
+				return time.Time{}, ErrNoExifTime
+			}
+			continue
+		}
+		segment := make([]byte, length)
+		if _, err := io.ReadFull(br, segment); err != nil {
+			return time.Time{}, ErrNoExifTime
+		}
+		if tiff, found := bytes.CutPrefix(segment, []byte("Exif\x00\x00")); found {
+			return exifTime(tiff)
+		}
+	}
+}
+
+// exifTime reads the time from TIFF-structured EXIF data.
+func exifTime(tiff []byte) (time.Time, error) {
+	var order binary.ByteOrder
+	switch {
+	case bytes.HasPrefix(tiff, []byte("II*\x00")):
+		order = binary.LittleEndian
+	case bytes.HasPrefix(tiff, []byte("MM\x00*")):
+		order = binary.BigEndian
+	default:
+		return time.Time{}, ErrNoExifTime
+	}
+
+	// entry returns the type, count and value (or offset of the value) of the tag in the IFD at the offset.
+	entry := func(ifd uint32, tag uint16) (typ uint16, count uint32, value []byte, found bool) {
+		if ifd == 0 || int64(ifd)+2 > int64(len(tiff)) {
+			return
+		}
+		n := int64(order.Uint16(tiff[ifd:]))
+		for i := int64(0); i < n; i++ {
+			start := int64(ifd) + 2 + i*12
+			if start+12 > int64(len(tiff)) {
+				return
+			}
+			e := tiff[start : start+12]
+			if order.Uint16(e) == tag {
+				return order.Uint16(e[2:]), order.Uint32(e[4:]), e[8:12], true
+			}
+		}
+		return
+	}
+	ascii := func(ifd uint32, tag uint16) string {
+		typ, count, value, found := entry(ifd, tag)
+		if !found || typ != exifTypeASCII {
+			return ""
+		}
+		if count > 4 {
+			offset := int64(order.Uint32(value))
+			if offset+int64(count) > int64(len(tiff)) {
+				return ""
+			}
+			value = tiff[offset : offset+int64(count)]
+		} else {
+			value = value[:count]
+		}
+		return strings.TrimRight(string(value), "\x00 ")
+	}
+
+	if len(tiff) < 8 {
+		return time.Time{}, ErrNoExifTime
+	}
+	ifd0 := order.Uint32(tiff[4:])
+	str := ""
+	if typ, _, value, found := entry(ifd0, exifTagExifIFD); found && typ == exifTypeLong {
+		str = ascii(order.Uint32(value), exifTagDateTimeOriginal)
+	}
+	if str == "" {
+		str = ascii(ifd0, exifTagDateTime)
+	}
+	t, err := time.Parse(ExifTimeLayout, str)
+	if err != nil {
+		return time.Time{}, ErrNoExifTime
+	}
+	return t, nil
+}
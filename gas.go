@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidGas = errors.New("invalid gas mix")
+
+// GasMix is a breathing gas, given as percentages of oxygen and helium. The rest is nitrogen.
+type GasMix struct {
+	O2 int `json:"o2"`
+	He int `json:"he,omitempty"`
+}
+
+// Air is the default breathing gas.
+var Air = GasMix{O2: 21}
+
+// ParseGasMix parses the usual notations of breathing gases: "air", "EAN32" (or "Nx32", "32%"), "oxygen", and
+// trimix as "18/45" or "Tx18/45" (oxygen/helium).
+func ParseGasMix(str string) (GasMix, error) {
+	s := strings.ToLower(strings.TrimSpace(str))
+	switch s {
+	case "air":
+		return Air, nil
+	case "o2", "oxygen":
+		return GasMix{O2: 100}, nil
+	}
+
+	for _, prefix := range []string{"ean", "nx", "tx"} {
+		s = strings.TrimPrefix(s, prefix)
+	}
+	s = strings.TrimSuffix(s, "%")
+
+	var (
+		gas GasMix
+		err error
+	)
+	o2Str, heStr, isTrimix := strings.Cut(s, "/")
+	if gas.O2, err = strconv.Atoi(strings.TrimSpace(o2Str)); err != nil {
+		return gas, ErrInvalidGas
+	}
+	if isTrimix {
+		if gas.He, err = strconv.Atoi(strings.TrimSpace(heStr)); err != nil {
+			return gas, ErrInvalidGas
+		}
+	}
+	if gas.O2 < 5 || gas.O2 > 100 || gas.He < 0 || gas.O2+gas.He > 100 {
+		return gas, ErrInvalidGas
+	}
+	return gas, nil
+}
+
+// String returns the usual notation of the gas (see `ParseGasMix`).
+func (g GasMix) String() string {
+	switch {
+	case g.He > 0:
+		return fmt.Sprintf("%d/%d", g.O2, g.He)
+	case g.O2 == 21:
+		return "air"
+	case g.O2 == 100:
+		return "oxygen"
+	default:
+		return fmt.Sprintf("EAN%d", g.O2)
+	}
+}
+
+func (g GasMix) FO2() float64 {
+	return float64(g.O2) / 100
+}
+
+func (g GasMix) FHe() float64 {
+	return float64(g.He) / 100
+}
+
+func (g GasMix) FN2() float64 {
+	return 1 - g.FO2() - g.FHe()
+}
+
+// FormatGases returns a comma-separated list of gases, the inverse of `ParseGases`.
+func FormatGases(gases []GasMix) string {
+	names := make([]string, 0, len(gases))
+	for _, gas := range gases {
+		names = append(names, gas.String())
+	}
+	return strings.Join(names, ", ")
+}
+
+// ParseGases parses a comma-separated list of gases (see `ParseGasMix`).
+func ParseGases(str string) ([]GasMix, error) {
+	var gases []GasMix
+	for _, part := range strings.Split(str, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		gas, err := ParseGasMix(part)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", err, strings.TrimSpace(part))
+		}
+		gases = append(gases, gas)
+	}
+	return gases, nil
+}
+
+// FormattedGases returns the recorded gases of the dive in the format accepted by `ParseGases`.
+func (dr *DiveRecord) FormattedGases() string {
+	return FormatGases(dr.Gases)
+}
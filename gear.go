@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+)
+
+// Kinds of gear items.
+const (
+	GearRegulator = "regulator"
+	GearBCD       = "bcd"
+	GearComputer  = "computer"
+	GearSuit      = "suit"
+	GearTank      = "tank"
+	GearOther     = "other"
+)
+
+var GearKinds = []string{GearRegulator, GearBCD, GearComputer, GearSuit, GearTank, GearOther}
+
+// GearItem is a piece of equipment in the inventory. Dives reference gear items used on them by ID (see
+// `DiveRecord.Gear`).
+type GearItem struct {
+	ID              string  `json:"id"`
+	Kind            string  `json:"kind"`
+	Name            string  `json:"name"` // e.g. brand and model
+	Serial          string  `json:"serial,omitempty"`
+	Purchased       string  `json:"purchased,omitempty"`    // DateLayout
+	LastService     string  `json:"last_service,omitempty"` // DateLayout
+	ServiceDays     int     `json:"service_interval_days,omitempty"`
+	ServiceDives    int     `json:"service_interval_dives,omitempty"`
+	Volume          float32 `json:"volume,omitempty"`           // tanks only: water capacity in liters
+	WorkingPressure int     `json:"working_pressure,omitempty"` // tanks only: in bar
+	Retired         bool    `json:"retired"`
+	Notes           string  `json:"notes,omitempty"`
+}
+
+// GearUsage holds accumulated usage of a gear item, and its service status.
+type GearUsage struct {
+	Item              *GearItem
+	Dives             int
+	Time              time.Duration
+	DivesSinceService int
+	ServiceDue        string // reason why service is due, or an empty string if it isn't
+}
+
+func (g *GearItem) lastServiceOrPurchase() time.Time {
+	for _, value := range []string{g.LastService, g.Purchased} {
+		if date, err := time.Parse(DateLayout, value); err == nil {
+			return date
+		}
+	}
+	return time.Time{}
+}
+
+// NextServiceDate returns the date when the item is due for service by date, or zero time if it has no service
+// interval in days.
+func (g *GearItem) NextServiceDate() time.Time {
+	if last := g.lastServiceOrPurchase(); g.ServiceDays > 0 && !last.IsZero() {
+		return last.AddDate(0, 0, g.ServiceDays)
+	}
+	return time.Time{}
+}
+
+// FormattedTime returns the total time the item was used underwater, in hours and minutes.
+func (u *GearUsage) FormattedTime() string {
+	return FormatHoursMinutes(u.Time)
+}
+
+// UsesGear reports whether the gear item with the given ID was used on the dive.
+func (dr *DiveRecord) UsesGear(id string) bool {
+	return slices.Contains(dr.Gear, id)
+}
+
+// Gear returns all gear items, ordered by kind and name.
+func (dl *DiveLog) Gear() []*GearItem {
+	items := make([]*GearItem, 0, len(dl.gear))
+	for _, item := range dl.gear {
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i int, j int) bool {
+		if ki, kj := slices.Index(GearKinds, items[i].Kind), slices.Index(GearKinds, items[j].Kind); ki != kj {
+			return ki < kj
+		}
+		if items[i].Name != items[j].Name {
+			return items[i].Name < items[j].Name
+		}
+		return items[i].ID < items[j].ID
+	})
+	return items
+}
+
+// ActiveGear returns gear items that are not retired, ordered like `Gear`.
+func (dl *DiveLog) ActiveGear() []*GearItem {
+	return Filter(dl.Gear(), func(item *GearItem) bool { return !item.Retired })
+}
+
+func (dl *DiveLog) FindGear(id string) *GearItem {
+	return dl.gear[id]
+}
+
+// GearDives returns dives on which the gear item with the given ID was used, in chronological order.
+func (dl *DiveLog) GearDives(id string) DiveList {
+	return dl.sorted.Filter(func(dive *Dive) bool { return dive.Data.UsesGear(id) })
+}
+
+// GearUsage returns accumulated usage of the gear item, and its service status at the given time.
+func (dl *DiveLog) GearUsage(item *GearItem, now time.Time) *GearUsage {
+	usage := &GearUsage{Item: item}
+	lastService := item.lastServiceOrPurchase()
+	for _, dive := range dl.GearDives(item.ID) {
+		usage.Dives++
+		usage.Time += dive.Data.Duration.Value()
+		if !dive.DateTimeIn.Before(lastService) {
+			usage.DivesSinceService++
+		}
+	}
+
+	if item.Retired {
+		return usage
+	}
+	if next := item.NextServiceDate(); !next.IsZero() && !now.Before(next) {
+		usage.ServiceDue = fmt.Sprintf("service was due on %s", next.Format("January 2, 2006"))
+	} else if item.ServiceDives > 0 && usage.DivesSinceService >= item.ServiceDives {
+		usage.ServiceDue = fmt.Sprintf("%d dives since last service", usage.DivesSinceService)
+	}
+	return usage
+}
+
+// ServiceDue returns usage of all active gear items that are due for service at the given time.
+func (dl *DiveLog) ServiceDue(now time.Time) []*GearUsage {
+	var due []*GearUsage
+	for _, item := range dl.ActiveGear() {
+		if usage := dl.GearUsage(item, now); usage.ServiceDue != "" {
+			due = append(due, usage)
+		}
+	}
+	return due
+}
+
+// ReconstructGear initializes the gear inventory from a list of items. It must be called before `Reconstruct`, so
+// dives can be linked to gear.
+func (dl *DiveLog) ReconstructGear(items []*GearItem) error {
+	byID := make(map[string]*GearItem, len(items))
+	for i, item := range items {
+		if item.ID == "" || byID[item.ID] != nil {
+			return fmt.Errorf("reconstruction failed: invalid or duplicate gear ID @ %s", fmt.Sprintf("/gear/%d", i))
+		}
+		byID[item.ID] = item
+	}
+	dl.gear = byID
+	return nil
+}
+
+// AddGear inserts a new item into the inventory, assigning it a unique ID.
+func (dl *DiveLog) AddGear(item *GearItem) {
+	item.ID = UniqueSlug(item.Name, item.Kind, func(id string) bool { return dl.gear[id] != nil })
+	dl.gear[item.ID] = item
+
+	dl.persist()
+}
+
+// UpdateGear replaces the item data, keeping its ID.
+func (dl *DiveLog) UpdateGear(existing *GearItem, item *GearItem) {
+	item.ID = existing.ID
+	*existing = *item
+
+	dl.persist()
+}
+
+// RemoveGear deletes the item from the inventory, and from all dives it was used on.
+func (dl *DiveLog) RemoveGear(id string) {
+	for _, dive := range dl.GearDives(id) {
+		dl.touch(dive.id)
+		dive.Data.Gear = slices.DeleteFunc(dive.Data.Gear, func(gearID string) bool { return gearID == id })
+	}
+	delete(dl.gear, id)
+
+	dl.persist()
+}
+
+// linkGear drops references to unknown gear items from the dive.
+func (dl *DiveLog) linkGear(dive *Dive) {
+	dive.Data.Gear = slices.DeleteFunc(dive.Data.Gear, func(id string) bool { return dl.gear[id] == nil })
+	if len(dive.Data.Gear) == 0 {
+		dive.Data.Gear = nil
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	GearKindTag            = "kind"
+	GearNameTag            = "name"
+	GearSerialTag          = "serial"
+	GearPurchasedTag       = "purchased"
+	GearLastServiceTag     = "last_service"
+	GearServiceDaysTag     = "service_interval_days"
+	GearServiceDivesTag    = "service_interval_dives"
+	GearVolumeTag          = "volume"
+	GearWorkingPressureTag = "working_pressure"
+	GearRetiredTag         = "retired"
+	GearNotesTag           = "notes"
+)
+
+// HTTPS handler for the gear dashboard: inventory, usage and service status.
+func gearHandler(w http.ResponseWriter, r *http.Request) {
+	page := &Page{Title: "Gear"}
+
+	mlog := LogOf(r)
+	mlog.RLock()
+	defer mlog.RUnlock()
+	now := time.Now()
+	for _, item := range mlog.Gear() {
+		usage := mlog.GearUsage(item, now)
+		page.GearUsages = append(page.GearUsages, usage)
+		if usage.ServiceDue != "" {
+			page.ServiceDue = append(page.ServiceDue, usage)
+		}
+	}
+
+	render("gear.html", w, r, page)
+}
+
+func gearItemHandler(w http.ResponseWriter, r *http.Request) {
+	mlog := LogOf(r)
+	mlog.RLock()
+	defer mlog.RUnlock()
+
+	item := mlog.FindGear(r.PathValue(IDTag))
+	if item == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	page := &Page{
+		Title:     item.Name,
+		GearItem:  item,
+		GearUsage: mlog.GearUsage(item, time.Now()),
+		Dives:     mlog.GearDives(item.ID),
+	}
+
+	render("gearitem.html", w, r, page)
+}
+
+func newGearItemHandler(w http.ResponseWriter, r *http.Request) {
+	page := &Page{
+		Title:    "New Gear Item",
+		GearItem: &GearItem{Kind: GearOther},
+	}
+	render("gearitem.html", w, r, page)
+}
+
+func gearItemFormHandler(w http.ResponseWriter, r *http.Request) {
+	var (
+		page     = &Page{InputErrors: make(map[string]string)}
+		existing *GearItem
+	)
+
+	mlog := LogOf(r)
+	mlog.Lock()
+	defer mlog.Unlock()
+
+	if id := r.PathValue(IDTag); id != "" { // .../{id}/edit
+		if existing = mlog.FindGear(id); existing == nil {
+			http.NotFound(w, r)
+			return
+		}
+	} // else .../new
+
+	if item, ok := parseGearItemFromRequest(r, page.InputErrors); ok {
+		if existing != nil {
+			mlog.UpdateGear(existing, item)
+		} else {
+			mlog.AddGear(item)
+		}
+		http.Redirect(w, r, "/gear/"+item.ID, http.StatusFound)
+	} else { // not ok
+		page.Title = "New Gear Item"
+		if existing != nil {
+			page.Title = existing.Name
+			item.ID = existing.ID
+			page.GearUsage = mlog.GearUsage(existing, time.Now())
+			page.Dives = mlog.GearDives(existing.ID)
+		}
+		page.GearItem = item
+		render("gearitem.html", w, r, page)
+	}
+}
+
+// HTTPS handler that records a service of the gear item, performed today.
+func gearServiceHandler(w http.ResponseWriter, r *http.Request) {
+	mlog := LogOf(r)
+	mlog.Lock()
+	defer mlog.Unlock()
+
+	item := mlog.FindGear(r.PathValue(IDTag))
+	if item == nil {
+		http.NotFound(w, r)
+		return
+	}
+	serviced := *item
+	serviced.LastService = dateToStr(time.Now())
+	mlog.UpdateGear(item, &serviced)
+
+	http.Redirect(w, r, "/gear/"+item.ID, http.StatusFound)
+}
+
+func gearItemRemovalHandler(w http.ResponseWriter, r *http.Request) {
+	mlog := LogOf(r)
+	mlog.Lock()
+	defer mlog.Unlock()
+
+	if mlog.FindGear(r.PathValue(IDTag)) == nil {
+		http.NotFound(w, r)
+		return
+	}
+	change := mlog.Begin(AccountOf(r), "Gear removal")
+	mlog.RemoveGear(r.PathValue(IDTag))
+	change.Commit()
+
+	if r.Header.Get("HX-Trigger") == "delete-btn" {
+		http.Redirect(w, r, "/gear", http.StatusSeeOther) // 303 because this is a redirect to a DELETE request
+	} else {
+		fmt.Fprint(w, "") // this is an async call, return hypermedia in the response to client
+	}
+}
+
+func parseGearItemFromRequest(r *http.Request, errorMap map[string]string) (item *GearItem, ok bool) {
+	ok = true
+	item = &GearItem{Kind: r.FormValue(GearKindTag)}
+
+	if !slices.Contains(GearKinds, item.Kind) {
+		ok = false
+		item.Kind = GearOther
+		errorMap[GearKindTag] = "Please select the kind of gear."
+	}
+
+	if name, errMsg := validateNonEmptyString(r.FormValue(GearNameTag)); errMsg != "" {
+		ok = false
+		errorMap[GearNameTag] = "Please provide the name of the gear item, e.g. its brand and model."
+	} else {
+		item.Name = name
+	}
+
+	for _, field := range []struct {
+		tag   string
+		value *string
+	}{
+		{GearPurchasedTag, &item.Purchased},
+		{GearLastServiceTag, &item.LastService},
+	} {
+		if *field.value = strings.TrimSpace(r.FormValue(field.tag)); *field.value != "" {
+			if _, errMsg := validateDateInput(*field.value); errMsg != "" {
+				ok = false
+				errorMap[field.tag] = "Please provide a valid date."
+			}
+		}
+	}
+
+	for _, field := range []struct {
+		tag   string
+		value *int
+	}{
+		{GearServiceDaysTag, &item.ServiceDays},
+		{GearServiceDivesTag, &item.ServiceDives},
+		{GearWorkingPressureTag, &item.WorkingPressure},
+	} {
+		if value := strings.TrimSpace(r.FormValue(field.tag)); value != "" {
+			if n, errMsg := validateCountInput(value); errMsg != "" {
+				ok = false
+				errorMap[field.tag] = errMsg
+			} else {
+				*field.value = n
+			}
+		}
+	}
+
+	if value := strings.TrimSpace(r.FormValue(GearVolumeTag)); value != "" {
+		if volume, errMsg := validateTankVolumeInput(value); errMsg != "" {
+			ok = false
+			errorMap[GearVolumeTag] = errMsg
+		} else {
+			item.Volume = volume
+		}
+	}
+
+	item.Retired = r.FormValue(GearRetiredTag) == "true"
+
+	// Optional parameters: accept even an empty value after input is trimmed.
+	item.Serial, _ = validateNonEmptyString(r.FormValue(GearSerialTag))
+	item.Notes, _ = validateNonEmptyString(r.FormValue(GearNotesTag))
+
+	return
+}
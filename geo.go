@@ -0,0 +1,320 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	EntryPositionTag = "entry_position"
+	ExitPositionTag  = "exit_position"
+
+	KMLNamespace = "http://www.opengis.net/kml/2.2"
+)
+
+var ErrInvalidPosition = errors.New("invalid position")
+
+// ParseLatLon parses a position in one of the common formats, with latitude first unless hemispheres say otherwise:
+//   - decimal degrees, e.g. "-8.7953, 115.527" or "-8.7953 115.527" (also "geo:" URIs),
+//   - degrees with hemispheres, e.g. "8.7953 S, 115.527 E" or "S 8.7953 E 115.527",
+//   - degrees and decimal minutes, e.g. "8° 47.718' S, 115° 31.62' E",
+//   - degrees, minutes and seconds, e.g. "8°47'43.1\"S 115°31'37.2\"E".
+//
+// It doesn't check the range of the latitude and longitude.
+func ParseLatLon(str string) (*LatLon, error) {
+	str = strings.ToUpper(strings.TrimSpace(str))
+	if uri, found := strings.CutPrefix(str, "GEO:"); found { // e.g. "geo:-8.7953,115.527,-12;u=10" (RFC 5870)
+		uri, _, _ = strings.Cut(uri, ";")
+		if parts := strings.Split(uri, ","); len(parts) == 3 { // with altitude
+			uri = parts[0] + "," + parts[1]
+		}
+		str = uri
+	}
+
+	// Tokens are numbers and hemisphere letters; symbols of degrees, minutes and seconds are separators.
+	var (
+		tokens   []string
+		groups   [][]string // numbers of each coordinate, with the hemisphere letter first if there is one
+		comma    = -1       // number of tokens before the comma separating coordinates
+		letters  = 0
+		fields   = strings.FieldsFunc(str, func(r rune) bool { return unicode.IsSpace(r) || strings.ContainsRune("°º˚'′’\"″”", r) })
+		isLetter = func(token string) bool { return len(token) == 1 && strings.Contains("NSEW", token) }
+	)
+	for _, field := range fields {
+		for field != "" {
+			if strings.HasPrefix(field, ",") || strings.HasPrefix(field, ";") {
+				if comma >= 0 {
+					return nil, ErrInvalidPosition
+				}
+				comma, field = len(tokens), field[1:]
+				continue
+			}
+			end := strings.IndexFunc(field, func(r rune) bool { return strings.ContainsRune("NSEW,;", r) })
+			if end == 0 {
+				tokens, field = append(tokens, field[:1]), field[1:]
+				letters++
+				continue
+			}
+			if end < 0 {
+				end = len(field)
+			}
+			tokens, field = append(tokens, field[:end]), field[end:]
+		}
+	}
+
+	switch {
+	case letters == 2 && isLetter(tokens[0]): // e.g. "S 8.7953 E 115.527"
+		for i := 1; i < len(tokens); i++ {
+			if isLetter(tokens[i]) {
+				groups = [][]string{tokens[:i], tokens[i:]}
+			}
+		}
+	case letters == 2 && isLetter(tokens[len(tokens)-1]): // e.g. "8.7953 S 115.527 E"
+		for i := 0; i < len(tokens)-1; i++ {
+			if isLetter(tokens[i]) {
+				first, second := tokens[:i+1], tokens[i+1:]
+				groups = [][]string{
+					append([]string{first[len(first)-1]}, first[:len(first)-1]...),
+					append([]string{second[len(second)-1]}, second[:len(second)-1]...),
+				}
+			}
+		}
+	case letters == 0 && comma > 0:
+		groups = [][]string{tokens[:comma], tokens[comma:]}
+	case letters == 0 && len(tokens) == 2:
+		groups = [][]string{tokens[:1], tokens[1:]}
+	}
+	if len(groups) != 2 {
+		return nil, ErrInvalidPosition
+	}
+
+	var (
+		hemispheres [2]string
+		values      [2]float64
+		isLatitude  = func(hemisphere string) bool { return hemisphere == "N" || hemisphere == "S" }
+	)
+	for i, group := range groups {
+		if len(group) > 0 && isLetter(group[0]) {
+			hemispheres[i], group = group[0], group[1:]
+		}
+		value, err := parseDegrees(group)
+		if err != nil {
+			return nil, err
+		}
+		if hemispheres[i] == "S" || hemispheres[i] == "W" {
+			value = -value
+		}
+		values[i] = value
+	}
+	lat := 0
+	if hemispheres[0] != "" {
+		if isLatitude(hemispheres[0]) == isLatitude(hemispheres[1]) {
+			return nil, ErrInvalidPosition
+		}
+		if !isLatitude(hemispheres[0]) {
+			lat = 1
+		}
+	}
+	return &LatLon{Lat: values[lat], Lon: values[1-lat]}, nil
+}
+
+// parseDegrees parses degrees, optionally followed by minutes and seconds. Only the last of them can have a fraction,
+// and only degrees can be negative (which is unusual with a hemisphere).
+func parseDegrees(numbers []string) (float64, error) {
+	if len(numbers) == 0 || len(numbers) > 3 {
+		return 0, ErrInvalidPosition
+	}
+	var (
+		value    float64
+		negative = strings.HasPrefix(numbers[0], "-")
+	)
+	for i, number := range numbers {
+		if i < len(numbers)-1 && strings.Contains(number, ".") {
+			return 0, ErrInvalidPosition
+		}
+		if i > 0 && (strings.HasPrefix(number, "-") || strings.HasPrefix(number, "+")) {
+			return 0, ErrInvalidPosition
+		}
+		n, err := strconv.ParseFloat(number, 64)
+		if err != nil || math.IsInf(n, 0) || math.IsNaN(n) || (i > 0 && n >= 60) {
+			return 0, ErrInvalidPosition
+		}
+		value += math.Abs(n) / math.Pow(60, float64(i))
+	}
+	if negative {
+		value = -value
+	}
+	return value, nil
+}
+
+// FormattedEntry returns the entry position of the dive in the format of the dive form.
+func (dr *DiveRecord) FormattedEntry() string {
+	if dr.Entry == nil {
+		return ""
+	}
+	return dr.Entry.String()
+}
+
+func (dr *DiveRecord) FormattedExit() string {
+	if dr.Exit == nil {
+		return ""
+	}
+	return dr.Exit.String()
+}
+
+// Position returns where the dive took place: its entry position, or, if it is not known, the position of its site.
+// It returns nil if neither is known.
+func (dl *DiveLog) Position(dive *Dive) *LatLon {
+	if dive.Data.Entry != nil {
+		return dive.Data.Entry
+	}
+	if site := dl.sites.Find(dive.Data.SiteID); site != nil {
+		return site.Position
+	}
+	return nil
+}
+
+// Dive maps are exported in two formats of GIS tools, GeoJSON (RFC 7946) and KML 2.2. A dive is a point at its
+// position (see `Position`), or a line from its entry to its exit position, if both are known; dives without a
+// position are left out.
+
+type GeoJSONFeatureCollection struct {
+	Type     string            `json:"type"` // "FeatureCollection"
+	Features []*GeoJSONFeature `json:"features"`
+}
+
+type GeoJSONFeature struct {
+	Type       string             `json:"type"` // "Feature"
+	ID         string             `json:"id"`
+	Geometry   *GeoJSONGeometry   `json:"geometry"`
+	Properties *GeoJSONProperties `json:"properties"`
+}
+
+type GeoJSONGeometry struct {
+	Type        string `json:"type"`        // "Point" or "LineString"
+	Coordinates any    `json:"coordinates"` // longitude first
+}
+
+type GeoJSONProperties struct {
+	Num      int     `json:"num"`
+	DateTime string  `json:"date_time"`
+	Site     string  `json:"site"`
+	Duration string  `json:"duration"`
+	MaxDepth float32 `json:"max_depth,omitempty"`
+	Tags     string  `json:"tags,omitempty"`
+	Source   string  `json:"position_source"` // "dive" or "site"
+}
+
+// geometry returns the coordinates of the dive, longitude first, and whether they are the dive's own (rather than the
+// site's). It returns nil if the dive has no position.
+func (dl *DiveLog) geometry(dive *Dive) (coordinates [][2]float64, own bool) {
+	position := dl.Position(dive)
+	if position == nil {
+		return nil, false
+	}
+	coordinates = [][2]float64{{position.Lon, position.Lat}}
+	if dive.Data.Entry != nil && dive.Data.Exit != nil {
+		coordinates = append(coordinates, [2]float64{dive.Data.Exit.Lon, dive.Data.Exit.Lat})
+	}
+	return coordinates, dive.Data.Entry != nil
+}
+
+// WriteGeoJSON writes the dives as a GeoJSON feature collection.
+func (dl *DiveLog) WriteGeoJSON(w io.Writer, dives DiveList) error {
+	collection := &GeoJSONFeatureCollection{Type: "FeatureCollection", Features: make([]*GeoJSONFeature, 0, len(dives))}
+	for _, dive := range dives {
+		coordinates, own := dl.geometry(dive)
+		if coordinates == nil {
+			continue
+		}
+		feature := &GeoJSONFeature{
+			Type:     "Feature",
+			ID:       dive.ID(),
+			Geometry: &GeoJSONGeometry{Type: "Point", Coordinates: coordinates[0]},
+			Properties: &GeoJSONProperties{
+				Num:      dive.Num(),
+				DateTime: dive.Data.DateTime,
+				Site:     dive.Site(),
+				Duration: dive.Data.Duration.String(),
+				MaxDepth: dive.Data.MaxDepth,
+				Tags:     dive.Data.FormattedTags(),
+				Source:   "site",
+			},
+		}
+		if len(coordinates) > 1 {
+			feature.Geometry = &GeoJSONGeometry{Type: "LineString", Coordinates: coordinates}
+		}
+		if own {
+			feature.Properties.Source = "dive"
+		}
+		collection.Features = append(collection.Features, feature)
+	}
+	return NewEncoder(w).Encode(collection)
+}
+
+type KMLDocument struct {
+	XMLName    xml.Name        `xml:"kml"`
+	Namespace  string          `xml:"xmlns,attr"`
+	Name       string          `xml:"Document>name"`
+	Placemarks []*KMLPlacemark `xml:"Document>Placemark"`
+}
+
+type KMLPlacemark struct {
+	ID          string          `xml:"id,attr"`
+	Name        string          `xml:"name"`
+	Description string          `xml:"description"`
+	When        string          `xml:"TimeStamp>when"`
+	Point       *KMLCoordinates `xml:"Point,omitempty"`
+	LineString  *KMLCoordinates `xml:"LineString,omitempty"`
+}
+
+type KMLCoordinates struct {
+	Coordinates string `xml:"coordinates"` // "lon,lat" tuples separated by spaces
+}
+
+// WriteKML writes the dives as a KML document.
+func (dl *DiveLog) WriteKML(w io.Writer, dives DiveList) error {
+	doc := &KMLDocument{Namespace: KMLNamespace, Name: "Dive Log"}
+	for _, dive := range dives {
+		coordinates, _ := dl.geometry(dive)
+		if coordinates == nil {
+			continue
+		}
+		tuples := make([]string, 0, len(coordinates))
+		for _, c := range coordinates {
+			tuples = append(tuples, strconv.FormatFloat(c[0], 'f', -1, 64)+","+strconv.FormatFloat(c[1], 'f', -1, 64))
+		}
+		placemark := &KMLPlacemark{
+			ID:          dive.ID(),
+			Name:        fmt.Sprintf("#%d %s", dive.Num(), dive.Site()),
+			Description: fmt.Sprintf("%s, %s", dive.DateTimeIn.Format("January 2, 2006. 15:04"), dive.Data.Duration),
+			When:        dive.DateTimeIn.Format("2006-01-02T15:04:05"), // on-site time, without a time zone
+		}
+		if dive.Data.MaxDepth > 0 {
+			placemark.Description += fmt.Sprintf(", %v m", dive.Data.MaxDepth)
+		}
+		if len(tuples) > 1 {
+			placemark.LineString = &KMLCoordinates{Coordinates: strings.Join(tuples, " ")}
+		} else {
+			placemark.Point = &KMLCoordinates{Coordinates: tuples[0]}
+		}
+		doc.Placemarks = append(doc.Placemarks, placemark)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "    ")
+	if err := encoder.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/cicovic-andrija/libgo/logging"
+)
+
+// HTTPS handler that exports dives matching the filters of the dive list (see `DiveQuery`) as GeoJSON.
+func divesGeoJSONHandler(w http.ResponseWriter, r *http.Request) {
+	exportMap(w, r, "application/geo+json", "dives.geojson", (*DiveLog).WriteGeoJSON)
+}
+
+// HTTPS handler that exports dives matching the filters of the dive list (see `DiveQuery`) as KML.
+func divesKMLHandler(w http.ResponseWriter, r *http.Request) {
+	exportMap(w, r, "application/vnd.google-earth.kml+xml", "dives.kml", (*DiveLog).WriteKML)
+}
+
+func exportMap(w http.ResponseWriter, r *http.Request, contentType string, fileName string, write func(*DiveLog, io.Writer, DiveList) error) {
+	query, err := ParseDiveQuery(r.URL.Query()) // all pages are exported, the cursor is only validated
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	mlog := LogOf(r)
+	mlog.RLock()
+	defer mlog.RUnlock()
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+fileName+`"`)
+	if err := write(mlog, w, query.All(mlog)); err != nil {
+		trace(logging.SevError, "%s export failed: %v", fileName, err)
+	}
+}
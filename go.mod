@@ -2,4 +2,7 @@ module github.com/cicovic-andrija/ddhs
 
 go 1.22.0
 
-require github.com/cicovic-andrija/libgo v1.2.0
+require (
+	github.com/cicovic-andrija/libgo v1.2.0
+	golang.org/x/crypto v0.20.0
+)
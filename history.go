@@ -0,0 +1,458 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	HistoryFileName = "history.jsonl" // in the directory of the log; one revision per line, appended on save
+
+	RevisionTag = "revision"
+
+	// AuditLimit is the number of latest revisions shown in the audit log.
+	AuditLimit = 200
+)
+
+// Revision is a recorded change of a dive record: the record before and after the change, either of which is nil if
+// the dive was created or deleted. Revisions are numbered by a sequence per log, and belong to the dive by the UID of
+// its record, as the ID of a dive follows its date and time when the log is loaded.
+type Revision struct {
+	Seq     int         `json:"seq"`
+	DiveUID string      `json:"dive_uid"`
+	DiveID  string      `json:"dive_id"` // at the time of the change
+	Time    string      `json:"time"`    // RFC 3339
+	Author  string      `json:"author"`  // username of the account the change was made by
+	Action  string      `json:"action"`  // e.g. "Edited", "Site merge"
+	Before  *DiveRecord `json:"before,omitempty"`
+	After   *DiveRecord `json:"after,omitempty"`
+}
+
+func (rev *Revision) Deleted() bool {
+	return rev.After == nil
+}
+
+// FormattedTime returns the time of the revision in the server's time zone, e.g. "2006-01-02 15:04".
+func (rev *Revision) FormattedTime() string {
+	return formatLocalTime(rev.Time)
+}
+
+func formatLocalTime(rfc3339 string) string {
+	t, err := time.Parse(time.RFC3339, rfc3339)
+	if err != nil {
+		return rfc3339
+	}
+	return t.Local().Format(DateLayout + " " + TimeLayout)
+}
+
+// Change is a series of log mutations applied as one: revisions of the dives it changed are recorded, and the log is
+// persisted once, when the change is committed. It is started with `DiveLog.Begin` and must be finished with `Commit`
+// while the log is still locked for writing, even if nothing was changed. Mutators report dives they are about to
+// change (see `DiveLog.touch`), including through catalogs (e.g. a site merge), and records of those dives are compared
+// as a whole.
+type Change struct {
+	dl       *DiveLog
+	author   string
+	action   string
+	before   map[string]*DiveRecord // clones of records of touched dives, by dive ID; nil for dives created since
+	modified bool                   // the log was mutated, see `DiveLog.persist`
+}
+
+// Begin starts a change of the log made by the account. Changes can't be nested.
+func (dl *DiveLog) Begin(author *Account, action string) *Change {
+	change := &Change{
+		dl:     dl,
+		action: action,
+		before: make(map[string]*DiveRecord),
+	}
+	if author != nil {
+		change.author = author.Username
+	}
+	dl.pending = change
+	return change
+}
+
+// touch must be called by mutators before the dive with the given ID is changed, created or deleted. While a change is
+// in progress, it keeps a clone of the record as it was when the change began.
+func (dl *DiveLog) touch(id string) {
+	c := dl.pending
+	if c == nil {
+		return
+	}
+	if _, touched := c.before[id]; touched {
+		return
+	}
+	var before *DiveRecord
+	if dive := dl.dives[id]; dive != nil {
+		before = dive.Data.Clone()
+	}
+	c.before[id] = before
+}
+
+// Commit records a revision of every touched dive that was created, changed or deleted since the change began,
+// persists the log if it was mutated, and returns the number of revisions.
+func (c *Change) Commit() int {
+	var (
+		dl  = c.dl
+		now = time.Now().UTC().Format(time.RFC3339)
+		ids = make([]string, 0)
+	)
+	if dl.pending == c {
+		dl.pending = nil
+	}
+	if c.modified {
+		defer dl.persist()
+	}
+	for id, before := range c.before {
+		if dive := dl.dives[id]; (dive == nil) != (before == nil) || (dive != nil && !reflect.DeepEqual(before, dive.Data)) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		rev := &Revision{
+			Seq:    len(dl.history) + 1,
+			DiveID: id,
+			Time:   now,
+			Author: c.author,
+			Action: c.action,
+			Before: c.before[id],
+		}
+		if dive := dl.dives[id]; dive != nil {
+			rev.After = dive.Data.Clone()
+			rev.DiveUID = rev.After.UID
+		} else {
+			rev.DiveUID = rev.Before.UID
+		}
+		dl.history = append(dl.history, rev)
+	}
+	return len(ids)
+}
+
+// History returns revisions of the dive with the given ID, latest first. The dive may have been deleted.
+func (dl *DiveLog) History(id string) []*Revision {
+	revisions := make([]*Revision, 0)
+	uid := dl.uidOf(id)
+	if uid == "" {
+		return revisions
+	}
+	for i := len(dl.history) - 1; i >= 0; i-- {
+		if dl.history[i].DiveUID == uid {
+			revisions = append(revisions, dl.history[i])
+		}
+	}
+	return revisions
+}
+
+// uidOf returns the UID of the record of the dive with the given ID, or of the deleted dive that had the ID when it
+// was last changed. It returns an empty string if there is no such dive.
+func (dl *DiveLog) uidOf(id string) string {
+	if dive := dl.dives[id]; dive != nil {
+		return dive.Data.UID
+	}
+	for i := len(dl.history) - 1; i >= 0; i-- {
+		if dl.history[i].DiveID == id {
+			return dl.history[i].DiveUID
+		}
+	}
+	return ""
+}
+
+// FindRevision returns the revision with the sequence number, or nil.
+func (dl *DiveLog) FindRevision(seq int) *Revision {
+	if seq < 1 || seq > len(dl.history) {
+		return nil
+	}
+	return dl.history[seq-1]
+}
+
+// Revert restores the dive to its record after the revision, and returns it. A deleted dive is restored under the ID
+// of its date and time, unless it is in the trash, from where it must be restored first (see `Restore`). The record
+// must still be valid in the log as it is now (see `ValidateDive`), e.g. it must not overlap dives logged since.
+func (dl *DiveLog) Revert(rev *Revision) (*Dive, error) {
+	if rev.After == nil {
+		return nil, fmt.Errorf("revision %d deleted the dive", rev.Seq)
+	}
+	for _, td := range dl.trash {
+		if td.Record.UID == rev.DiveUID {
+			return nil, fmt.Errorf("dive %s is in the trash, restore it first", td.ID)
+		}
+	}
+	dive, err := EmptyDive().reconstructFrom(rev.After.Clone())
+	if err != nil {
+		return nil, err
+	}
+	existing := dl.FindUID(rev.DiveUID)
+	if existing == nil && dl.Find(dive.id) != nil {
+		return nil, fmt.Errorf("revision %d can't be restored: another dive starts at %s", rev.Seq, rev.After.DateTime)
+	}
+	if issues := dl.ValidateDive(dive, existing, time.Now()); len(issues.Errors) > 0 {
+		errMsgs := make([]string, 0, len(issues.Errors))
+		for _, errMsg := range issues.Errors {
+			errMsgs = append(errMsgs, errMsg)
+		}
+		sort.Strings(errMsgs)
+		return nil, fmt.Errorf("revision %d can't be restored: %s", rev.Seq, strings.Join(errMsgs, " "))
+	}
+	if existing != nil {
+		dl.Replace(existing, dive)
+	} else {
+		dl.Insert(dive)
+	}
+	return dive, nil
+}
+
+// FieldChange is a field of a dive record that differs between two revisions.
+type FieldChange struct {
+	Field  string
+	Before string
+	After  string
+}
+
+// RevisionDiff is a revision with the fields it changed.
+type RevisionDiff struct {
+	*Revision
+	Changes []*FieldChange
+}
+
+// revisionFields are fields of a dive record compared between revisions, formatted for display. Fields that can't be
+// displayed in full are also compared by `same`.
+var revisionFields = []struct {
+	name   string
+	format func(dl *DiveLog, dr *DiveRecord) string
+	same   func(a *DiveRecord, b *DiveRecord) bool
+}{
+	{name: "Date and Time", format: func(_ *DiveLog, dr *DiveRecord) string { return strings.Replace(dr.DateTime, "T", " ", 1) }},
+	{name: "Duration", format: func(_ *DiveLog, dr *DiveRecord) string {
+		if dr.Duration.Duration == 0 {
+			return ""
+		}
+		return dr.Duration.String()
+	}},
+	{name: "Site", format: func(_ *DiveLog, dr *DiveRecord) string { return dr.Site }},
+	{name: "Geo. Location", format: func(_ *DiveLog, dr *DiveRecord) string { return dr.Geo }},
+	{name: "Entry Position", format: func(_ *DiveLog, dr *DiveRecord) string { return dr.FormattedEntry() }},
+	{name: "Exit Position", format: func(_ *DiveLog, dr *DiveRecord) string { return dr.FormattedExit() }},
+	{name: "Trip", format: func(dl *DiveLog, dr *DiveRecord) string {
+		if trip := dl.FindTrip(dr.TripID); trip != nil {
+			return trip.Name
+		}
+		return dr.TripID
+	}},
+	{name: "People", format: func(dl *DiveLog, dr *DiveRecord) string {
+		people := make([]string, 0, len(dr.People))
+		for _, participant := range dr.People {
+			name := participant.PersonID
+			if person := dl.FindPerson(participant.PersonID); person != nil {
+				name = person.Name
+			}
+			people = append(people, fmt.Sprintf("%s (%s)", name, participant.Role))
+		}
+		return strings.Join(people, ", ")
+	}},
+	{name: "Gear", format: func(dl *DiveLog, dr *DiveRecord) string {
+		gear := make([]string, 0, len(dr.Gear))
+		for _, id := range dr.Gear {
+			if item := dl.FindGear(id); item != nil {
+				id = item.Name
+			}
+			gear = append(gear, id)
+		}
+		return strings.Join(gear, ", ")
+	}},
+	{name: "Max. Depth", format: func(_ *DiveLog, dr *DiveRecord) string { return formatDepth(dr.MaxDepth) }},
+	{name: "Avg. Depth", format: func(_ *DiveLog, dr *DiveRecord) string { return formatDepth(dr.AvgDepth) }},
+	{name: "Deco. Dive", format: func(_ *DiveLog, dr *DiveRecord) string { return formatYesNo(dr.DecoDive) }},
+	{name: "Gases", format: func(_ *DiveLog, dr *DiveRecord) string { return FormatGases(dr.Gases) }},
+	{
+		name: "Profile",
+		format: func(_ *DiveLog, dr *DiveRecord) string {
+			if len(dr.Profile) == 0 {
+				return ""
+			}
+			return fmt.Sprintf("%d samples", len(dr.Profile))
+		},
+		same: func(a *DiveRecord, b *DiveRecord) bool { return slices.Equal(a.Profile, b.Profile) },
+	},
+	{name: "Tank Volume", format: func(_ *DiveLog, dr *DiveRecord) string { return formatNonZero(dr.TankVolume, "L") }},
+	{name: "Start Pressure", format: func(_ *DiveLog, dr *DiveRecord) string { return formatNonZero(dr.TankPressureStart, "bar") }},
+	{name: "End Pressure", format: func(_ *DiveLog, dr *DiveRecord) string { return formatNonZero(dr.TankPressureEnd, "bar") }},
+	{name: "Note", format: func(_ *DiveLog, dr *DiveRecord) string { return dr.Note }},
+	{name: "Tags", format: func(_ *DiveLog, dr *DiveRecord) string { return dr.FormattedTags() }},
+	{name: "Custom Fields", format: func(_ *DiveLog, dr *DiveRecord) string { return dr.FormattedCustomValues() }},
+	{name: "Attachments", format: func(_ *DiveLog, dr *DiveRecord) string { return dr.FormattedAttachments() }},
+	{name: "Verification", format: func(_ *DiveLog, dr *DiveRecord) string {
+		if v := dr.Verification; v != nil {
+			return fmt.Sprintf("%s on %s", v.Name, v.FormattedSignedAt())
+		}
+		return ""
+	}},
+}
+
+// Diff returns fields changed by the revision.
+func (dl *DiveLog) Diff(rev *Revision) *RevisionDiff {
+	var (
+		diff   = &RevisionDiff{Revision: rev, Changes: make([]*FieldChange, 0)}
+		before = rev.Before
+		after  = rev.After
+	)
+	if before == nil {
+		before = &DiveRecord{}
+	}
+	if after == nil {
+		after = &DiveRecord{}
+	}
+	for _, field := range revisionFields {
+		a, b := field.format(dl, before), field.format(dl, after)
+		if a != b || (field.same != nil && !field.same(before, after)) {
+			diff.Changes = append(diff.Changes, &FieldChange{Field: field.name, Before: a, After: b})
+		}
+	}
+	return diff
+}
+
+func formatDepth(depth float32) string {
+	return formatNonZero(depth, "m")
+}
+
+func formatNonZero[T float32 | int](value T, unit string) string {
+	if value == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%v %s", value, unit)
+}
+
+func formatYesNo(b bool) string {
+	if b {
+		return "Yes"
+	}
+	return "No"
+}
+
+// loadHistory reads revisions persisted in the directory of the log, if any.
+func (dl *DiveLog) loadHistory() error {
+	file, err := os.Open(filepath.Join(dl.dir, HistoryFileName))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("read history operation failed: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), MaxDiveExportSize*2)
+	for scanner.Scan() {
+		rev := &Revision{}
+		if err = json.Unmarshal(scanner.Bytes(), rev); err != nil || rev.Seq != len(dl.history)+1 {
+			return fmt.Errorf("%w: history, line %d", ErrCorruptedLog, len(dl.history)+1)
+		}
+		dl.history = append(dl.history, rev)
+	}
+	if err = scanner.Err(); err != nil {
+		return fmt.Errorf("read history operation failed: %v", err)
+	}
+	dl.historyPersisted = len(dl.history)
+	return nil
+}
+
+// saveHistory appends revisions recorded since the last save.
+func (dl *DiveLog) saveHistory() error {
+	if dl.historyPersisted == len(dl.history) {
+		return nil
+	}
+	file, err := os.OpenFile(filepath.Join(dl.dir, HistoryFileName), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("history file creation failed: %v", err)
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for _, rev := range dl.history[dl.historyPersisted:] {
+		if err = encoder.Encode(rev); err != nil {
+			return fmt.Errorf("encode history operation failed: %v", err)
+		}
+	}
+	if err = writer.Flush(); err != nil {
+		return fmt.Errorf("write history operation failed: %v", err)
+	}
+	dl.historyPersisted = len(dl.history)
+	return nil
+}
+
+// AuditEntry is the metadata of a revision in the audit log of all accounts: who changed which dive of an account and
+// when, and the names of the changed fields. Dive records are private to each account, so they are not included.
+type AuditEntry struct {
+	Account *Account
+	Seq     int
+	DiveID  string
+	Time    string // RFC 3339
+	Author  string
+	Action  string
+	Fields  []string
+
+	mlog *DiveLog
+	rev  *Revision
+}
+
+// FormattedTime returns the time of the change in the server's time zone, e.g. "2006-01-02 15:04".
+func (e *AuditEntry) FormattedTime() string {
+	return formatLocalTime(e.Time)
+}
+
+// FormattedFields returns the names of the changed fields, separated by commas.
+func (e *AuditEntry) FormattedFields() string {
+	return strings.Join(e.Fields, ", ")
+}
+
+// Audit returns the latest revisions of dive logs of the accounts, up to `AuditLimit`.
+func (lr *LogRegistry) Audit(accounts []*Account) ([]*AuditEntry, error) {
+	entries := make([]*AuditEntry, 0)
+	for _, account := range accounts {
+		mlog, err := lr.Open(account)
+		if err != nil {
+			return nil, err
+		}
+		mlog.RLock()
+		for i := len(mlog.history) - 1; i >= 0; i-- {
+			rev := mlog.history[i]
+			entries = append(entries, &AuditEntry{
+				Account: account,
+				Seq:     rev.Seq,
+				DiveID:  rev.DiveID,
+				Time:    rev.Time,
+				Author:  rev.Author,
+				Action:  rev.Action,
+				mlog:    mlog,
+				rev:     rev,
+			})
+		}
+		mlog.RUnlock()
+	}
+	sort.SliceStable(entries, func(i int, j int) bool { return entries[i].Time > entries[j].Time })
+	if len(entries) > AuditLimit {
+		entries = entries[:AuditLimit]
+	}
+
+	// Only the shown revisions are compared. Revisions don't change once recorded, but their diffs read the catalogs of
+	// the log.
+	for _, e := range entries {
+		e.mlog.RLock()
+		e.Fields = make([]string, 0)
+		for _, change := range e.mlog.Diff(e.rev).Changes {
+			e.Fields = append(e.Fields, change.Field)
+		}
+		e.mlog.RUnlock()
+		e.mlog, e.rev = nil, nil
+	}
+	return entries, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/cicovic-andrija/libgo/logging"
+)
+
+// HTTPS handler for the change history of a dive, which is available even after the dive was deleted.
+func diveHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue(IDTag)
+	mlog := LogOf(r)
+	mlog.RLock()
+	defer mlog.RUnlock()
+
+	revisions := mlog.History(id)
+	dive := mlog.Find(id)
+	if dive == nil && len(revisions) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	page := &Page{Title: "Deleted Dive", Dive: dive}
+	if dive != nil {
+		page.Title = fmt.Sprintf("Dive #%d", dive.Num())
+	}
+	page.Revisions = make([]*RevisionDiff, 0, len(revisions))
+	for _, rev := range revisions {
+		page.Revisions = append(page.Revisions, mlog.Diff(rev))
+	}
+	render("history.html", w, r, page)
+}
+
+// HTTPS handler that reverts a dive to a revision from its history, restoring the dive if it was deleted.
+func diveRevertHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue(IDTag)
+	mlog := LogOf(r)
+	mlog.Lock()
+	defer mlog.Unlock()
+
+	seq, _ := strconv.Atoi(r.FormValue(RevisionTag))
+	rev := mlog.FindRevision(seq)
+	if rev == nil || rev.DiveUID != mlog.uidOf(id) {
+		http.NotFound(w, r)
+		return
+	}
+
+	change := mlog.Begin(AccountOf(r), fmt.Sprintf("Reverted to revision %d", rev.Seq))
+	dive, err := mlog.Revert(rev)
+	change.Commit()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	trace(logging.SevInfo, "dive %s of %s reverted to revision %d", id, AccountOf(r).Username, rev.Seq)
+
+	// A restored dive may be under a different ID, the one of its date and time.
+	http.Redirect(w, r, "/dives/"+dive.ID()+"/history", http.StatusSeeOther)
+}
+
+// HTTPS handler for the audit log of all accounts, available to admins only.
+func auditHandler(w http.ResponseWriter, r *http.Request) {
+	if !AccountOf(r).Admin {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	page := &Page{Title: "Audit Log"}
+	entries, err := Logs.Audit(Users.All())
+	if err != nil {
+		trace(logging.SevError, "audit log failed: %v", err)
+		http.Error(w, "dive logs unavailable", http.StatusInternalServerError)
+		return
+	}
+	page.Audit = entries
+
+	render("audit.html", w, r, page)
+}
@@ -3,10 +3,11 @@ package main
 import (
 	"fmt"
 	"html/template"
+	"maps"
 	"net/http"
 	"net/url"
 	"path/filepath"
-	"strconv"
+	"slices"
 	"strings"
 	"time"
 
@@ -16,30 +17,87 @@ import (
 const (
 	TmplDir = "tmpl"
 
-	PageSize = 10
-
-	PageQueryTag   = "page"
 	BeforeQueryTag = "before"
 	AfterQueryTag  = "after"
+	SearchQueryTag = "q"
 )
 
 // TODO: Should be used only when rendering a whole page template
 type Page struct {
-	Title        string
-	BeforeFilter time.Time
-	AfterFilter  time.Time
-	PageFilter   int
-	LastPage     bool
-	InputErrors  map[string]string
-	Dive         *Dive
-	Dives        []*Dive
-	Total        int
-	Renumbered   bool
-	SyncJob      *SyncJob
+	Title            string
+	SearchQuery      string
+	TripFilter       string
+	BuddyFilter      string
+	TagFilter        string
+	FieldFilter      string // ID of a custom field
+	FieldValueFilter string
+	BeforeFilter     time.Time
+	AfterFilter      time.Time
+	Limit            int
+	NextCursor       string
+	HasMore          bool
+	InputErrors      map[string]string
+	InputWarnings    map[string]string
+	Dive             *Dive
+	Deco             *DecoResult
+	Repetitive       *Repetitive
+	Repetitives      map[string]*Repetitive
+	NoFly            *NoFly
+	Plan             *Plan
+	Plans            []*Plan
+	Suggestions      map[string]*Dive // by attachment hash, dives other than the one shown that photos were likely taken on
+	PlanResult       *PlanResult
+	PlanComparison   []*PlanComparison
+	Consumption      *Consumption
+	Oxygen           *OxygenLoad
+	GasUses          []*GasUse
+	ConsumptionStats *ConsumptionStats
+	LogStats         *LogStats
+	ClubStats        *ClubStats
+	Tags             []*TagCount
+	Field            *CustomField
+	Fields           []*CustomField
+	Trash            []*TrashedDive
+	Revisions        []*RevisionDiff
+	Audit            []*AuditEntry
+	Account          *Account // signed in
+	CSRFToken        string   // of the session, sent back on htmx requests
+	Setup            bool     // no accounts yet, the sign-in page creates the first one
+	Username         string
+	Next             string // where to go after signing in
+	Dives            []*Dive
+	Site             *Site
+	Sites            []*Site
+	Summary          *DiveSummary
+	Summaries        map[string]*DiveSummary
+	Duplicates       [][]*Site
+	Map              *Map
+	Trip             *Trip
+	Trips            []*Trip
+	Unassigned       []*Dive
+	TripSuggestions  []*TripSuggestion
+	Person           *Person
+	People           []*Person
+	GearItem         *GearItem
+	Gear             []*GearItem
+	GearUsage        *GearUsage
+	GearUsages       []*GearUsage
+	ServiceDue       []*GearUsage
+	Total            int
+	Renumbered       bool
+	SyncJob          *SyncJob
+}
+
+func (p *Page) GearKinds() []string {
+	return GearKinds
+}
+
+func (p *Page) ReserveRules() []string {
+	return ReserveRules
 }
 
-func (p *Page) NextPage() int {
-	return p.PageFilter + 1
+func (p *Page) FieldTypes() []string {
+	return FieldTypes
 }
 
 func (p *Page) URLBeforeQuery() string {
@@ -56,6 +114,90 @@ func (p *Page) URLAfterQuery() string {
 	return fmt.Sprintf("%s=%s&", AfterQueryTag, dateToStr(p.AfterFilter))
 }
 
+func (p *Page) URLLimitQuery() string {
+	if p.Limit == 0 || p.Limit == DefaultPageSize() {
+		return ""
+	}
+	return fmt.Sprintf("%s=%d&", LimitQueryTag, p.Limit)
+}
+
+func (p *Page) URLTripQuery() string {
+	if p.TripFilter == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s=%s&", TripTag, url.QueryEscape(p.TripFilter))
+}
+
+func (p *Page) URLTagQuery() string {
+	if p.TagFilter == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s=%s&", TagQueryTag, url.QueryEscape(p.TagFilter))
+}
+
+func (p *Page) URLFieldQuery() string {
+	if p.FieldFilter == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s=%s&%s=%s&", FieldQueryTag, url.QueryEscape(p.FieldFilter), FieldValueQueryTag, url.QueryEscape(p.FieldValueFilter))
+}
+
+func (p *Page) URLBuddyQuery() string {
+	if p.BuddyFilter == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s=%s&", BuddyQueryTag, url.QueryEscape(p.BuddyFilter))
+}
+
+// PeopleOf returns comma-separated names of people on the dive in the given role.
+func (p *Page) PeopleOf(dive *Dive, role string) string {
+	names := make([]string, 0, len(dive.Data.People))
+	for _, participant := range dive.Data.People {
+		if participant.Role != role {
+			continue
+		}
+		if participant.Name != "" {
+			names = append(names, participant.Name)
+		}
+		for _, person := range p.People {
+			if person.ID == participant.PersonID {
+				names = append(names, person.Name)
+			}
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+func (p *Page) URLSearchQuery() string {
+	if p.SearchQuery == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s=%s&", SearchQueryTag, url.QueryEscape(p.SearchQuery))
+}
+
+// SiteOf returns the dive site (see `Dive.Site`), with search query matches highlighted.
+func (p *Page) SiteOf(dive *Dive) template.HTML {
+	return Highlight(dive.Site(), p.SearchQuery)
+}
+
+// NoteSnippetOf returns a highlighted fragment of the dive's note matching the search query, if any.
+func (p *Page) NoteSnippetOf(dive *Dive) template.HTML {
+	if p.SearchQuery == "" {
+		return ""
+	}
+	return Snippet(dive.Data.Note, p.SearchQuery)
+}
+
+// FieldValueOf returns the value of the custom field the list is filtered by, e.g. "Camera ISO: 400", if any.
+func (p *Page) FieldValueOf(dive *Dive) string {
+	for _, field := range p.Fields {
+		if field.ID == p.FieldFilter {
+			return fmt.Sprintf("%s: %s", field.Name, dive.Data.FormattedCustomValue(field))
+		}
+	}
+	return ""
+}
+
 func (p *Page) NormalizedDateValue(date time.Time) string {
 	if date.IsZero() {
 		return ""
@@ -76,82 +218,200 @@ func divesHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	page := &Page{Title: "Dive Log"}
+	query, _ := ParseDiveQuery(r.URL.Query()) // an invalid cursor restarts the list from the first page
 
-	MLog.RLock()
-	defer MLog.RUnlock()
-	filtered := MLog.All()
-
-	if beforeValue := r.URL.Query().Get(BeforeQueryTag); beforeValue != "" {
-		if beforeDate, err := time.Parse(DateLayout, beforeValue); err == nil {
-			page.BeforeFilter = beforeDate
-			filtered = filtered.Filter(func(dive *Dive) bool { return dive.DateTimeIn.Before(beforeDate) })
-		}
-	}
-
-	if afterValue := r.URL.Query().Get(AfterQueryTag); afterValue != "" {
-		if afterDate, err := time.Parse(DateLayout, afterValue); err == nil {
-			page.AfterFilter = afterDate
-			filtered = filtered.Filter(func(dive *Dive) bool { return dive.DateTimeIn.After(afterDate) })
-		}
-	}
+	mlog := LogOf(r)
+	mlog.RLock()
+	defer mlog.RUnlock()
+	result := query.Run(mlog)
 
-	if MLog.IsRenumbered() {
+	if mlog.IsRenumbered() {
 		page.Renumbered = true
 	}
 
-	page.Total = len(filtered)
-	pageNum, err := strconv.Atoi(r.URL.Query().Get(PageQueryTag))
-	if err != nil || pageNum < 1 {
-		pageNum = 1
+	page.SearchQuery = query.Search
+	page.TripFilter = query.Trip
+	page.Trips = mlog.Trips()
+	page.BuddyFilter = query.Buddy
+	page.People = mlog.People()
+	page.TagFilter = query.Tag
+	page.Tags = mlog.Tags()
+	page.FieldFilter = query.Field
+	page.FieldValueFilter = query.Value
+	page.Fields = CustomFields.All()
+	page.Sites = mlog.Sites().All() // for batch actions
+	page.ServiceDue = mlog.ServiceDue(time.Now())
+	page.NoFly = mlog.NoFlyStatus(time.Now())
+	page.BeforeFilter = query.Before
+	page.AfterFilter = query.After
+	page.Limit = query.Limit
+	page.Total = result.Total
+	page.Dives = result.Dives
+	page.Repetitives = make(map[string]*Repetitive, len(result.Dives))
+	for _, dive := range result.Dives {
+		page.Repetitives[dive.ID()] = mlog.Repetitive(dive)
+	}
+	if result.Next != nil {
+		page.HasMore = true
+		page.NextCursor = result.Next.Encode()
 	}
-	page.PageFilter = pageNum
-	filtered = Paginate(filtered, pageNum-1, PageSize)
-
-	page.Dives = filtered
-	page.LastPage = len(filtered) < PageSize // there is an acceptable fencepost error here
 	page.SyncJob = syncJob
-	render("dives.html", w, page)
+	render("dives.html", w, r, page)
 }
 
 func diveHandler(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue(IDTag)
-	MLog.RLock()
-	defer MLog.RUnlock()
+	mlog := LogOf(r)
+	mlog.RLock()
+	defer mlog.RUnlock()
 
-	dive := MLog.Find(id)
+	dive := mlog.Find(id)
 	if dive == nil {
 		http.NotFound(w, r)
 		return
 	}
 
+	render("dive.html", w, r, divePage(mlog, dive))
+}
+
+// divePage returns the page of an existing dive. The log must be locked for reading.
+func divePage(mlog *DiveLog, dive *Dive) *Page {
+	repetitive := mlog.Repetitive(dive)
 	page := &Page{
-		Title: fmt.Sprintf("Dive #%d", dive.Num()),
-		Dive:  dive,
+		Title:      fmt.Sprintf("Dive #%d", dive.Num()),
+		Dive:       dive,
+		Repetitive: repetitive,
+		Deco:       dive.Deco(repetitive.Residual),
+		Sites:      mlog.Sites().All(),
+		Trips:      mlog.Trips(),
+		People:     mlog.People(),
+		Gear:       mlog.Gear(),
+		Plans:      mlog.DivePlans(dive.ID()),
+		Oxygen:     mlog.OxygenLoad(dive),
+		GasUses:    GasUses(dive.Data.BreathingGases(), dive.Data.MaxDepth),
+		Fields:     CustomFields.All(),
 	}
-
-	render("dive.html", w, page)
+	if page.Consumption = mlog.Consumption(dive); page.Consumption != nil {
+		page.Consumption.Outlier = mlog.ConsumptionStats().Outlier(dive)
+	}
+	page.Suggestions = make(map[string]*Dive)
+	for _, attachment := range dive.Data.Attachments {
+		if suggested := mlog.SuggestDive(attachment); suggested != nil && suggested != dive {
+			page.Suggestions[attachment.Hash] = suggested
+		}
+	}
+	return page
 }
 
 func diveRemovalHandler(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue(IDTag)
-	MLog.Lock()
-	defer MLog.Unlock()
-	MLog.Delete(id)
+	mlog := LogOf(r)
+	mlog.Lock()
+	defer mlog.Unlock()
+	dive := mlog.Find(id)
+	if dive == nil {
+		http.NotFound(w, r)
+		return
+	}
+	change := mlog.Begin(AccountOf(r), "Moved to trash")
+	mlog.Trash(id, time.Now())
+	change.Commit()
 
 	// TODO: Also check for HX-Request header.
 	if r.Header.Get("HX-Trigger") == "delete-btn" {
 		http.Redirect(w, r, "/dives", http.StatusSeeOther) // 303 because this is a redirect to a DELETE request
 	} else {
-		fmt.Fprint(w, "") // this is an async call, return hypermedia in the response to client
+		partialRender("trashed-dive", w, dive) // this is an async call, return hypermedia in the response to client
 	}
 }
 
+// HTTPS handler that applies a batch action to dives selected in the dive list, as a single change of the log.
+// Errors are shown next to the batch actions; otherwise, the list is reloaded.
+func diveBatchHandler(w http.ResponseWriter, r *http.Request) {
+	mlog := LogOf(r)
+	mlog.Lock()
+	defer mlog.Unlock()
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form data.", http.StatusBadRequest)
+		return
+	}
+	batch := &Batch{
+		Action: r.PostForm.Get(BatchActionTag),
+		Dives:  findDives(mlog, r.PostForm[BatchDiveTag]),
+	}
+	switch batch.Action {
+	case BatchSite:
+		batch.Value = r.PostForm.Get(BatchSiteTag)
+	case BatchTrip:
+		batch.Value = r.PostForm.Get(BatchTripTag)
+	case BatchDeco:
+		batch.Value = r.PostForm.Get(BatchDecoTag)
+	case BatchTag:
+		batch.Value = NormalizeTag(r.PostForm.Get(BatchTagTag))
+	}
+
+	change := mlog.Begin(AccountOf(r), batch.Description())
+	err := mlog.ApplyBatch(batch, time.Now())
+	change.Commit()
+	if err != nil {
+		fmt.Fprintf(w, "Please check the selection, %s.", err)
+		return
+	}
+
+	next := "/dives"
+	if u, err := url.Parse(r.Header.Get("HX-Current-URL")); err == nil && u.Path == "/dives" {
+		next = u.RequestURI() // keep the filter
+	}
+	w.Header().Set("HX-Redirect", next)
+}
+
+// HTTPS handler that downloads dives selected in the dive list, in the JSON format of the API (see `DiveResource`).
+func diveExportHandler(w http.ResponseWriter, r *http.Request) {
+	mlog := LogOf(r)
+	mlog.RLock()
+	defer mlog.RUnlock()
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form data.", http.StatusBadRequest)
+		return
+	}
+	dives := findDives(mlog, r.PostForm[BatchDiveTag])
+	if len(dives) == 0 {
+		http.Error(w, "No dives selected.", http.StatusBadRequest)
+		return
+	}
+	slices.SortFunc(dives, func(a *Dive, b *Dive) int { return a.ix - b.ix })
+	resource := &DiveListResource{Dives: make([]*DiveResource, 0, len(dives)), Total: len(dives), Fields: CustomFields.All()}
+	for _, dive := range dives {
+		resource.Dives = append(resource.Dives, NewDiveResource(dive))
+	}
+
+	w.Header().Set("Content-Disposition", `attachment; filename="dives.json"`)
+	writeJSON(w, http.StatusOK, resource)
+}
+
 func newDiveHandler(w http.ResponseWriter, r *http.Request) {
 	page := &Page{
 		Title: "New Dive",
 		Dive:  EmptyDive(),
 	}
-	render("dive.html", w, page)
+	mlog := LogOf(r)
+	mlog.RLock()
+	page.Sites = mlog.Sites().All()
+	page.Trips = mlog.Trips()
+	page.People = mlog.People()
+	page.Gear = mlog.Gear()
+	page.Fields = CustomFields.All()
+	if all := mlog.All(); len(all) > 0 { // gear usually doesn't change between dives, so offer the last used
+		for _, id := range all[len(all)-1].Data.Gear {
+			if item := mlog.FindGear(id); item != nil && !item.Retired {
+				page.Dive.Data.Gear = append(page.Dive.Data.Gear, id)
+			}
+		}
+	}
+	mlog.RUnlock()
+	render("dive.html", w, r, page)
 }
 
 func diveFormHandler(w http.ResponseWriter, r *http.Request) {
@@ -160,39 +420,68 @@ func diveFormHandler(w http.ResponseWriter, r *http.Request) {
 		existing *Dive
 	)
 
+	mlog := LogOf(r)
+	mlog.Lock()
+	defer mlog.Unlock()
+
 	if id := r.PathValue(IDTag); id != "" { // .../{id}/edit
-		MLog.RLock()
-		if existing = MLog.Find(id); existing == nil {
-			MLog.RUnlock()
+		if existing = mlog.Find(id); existing == nil {
 			http.NotFound(w, r)
 			return
 		}
-		MLog.RUnlock()
 	} // else .../new
 
-	if dive, ok := parseDiveFromRequest(r, page.InputErrors); ok {
+	dive, ok := parseDiveFromRequest(r, page.InputErrors)
+	if ok {
+		// Warnings are shown once; the diver may then save anyway.
+		issues := mlog.ValidateDive(dive, existing, time.Now())
+		maps.Copy(page.InputErrors, issues.Errors)
+		page.InputWarnings = issues.Warnings
+		ok = len(issues.Errors) == 0 && (len(issues.Warnings) == 0 || r.FormValue(ConfirmWarningsTag) == "true")
+	}
+
+	if ok {
 		// TODO: Date and time must match between "new" and existing dive.
-		MLog.Lock()
 		if existing != nil {
-			MLog.Replace(existing, dive)
+			// A location left as it was taken from the site follows the site, which may have been changed too.
+			unlinkGeo(dive, mlog.Sites().Find(existing.Data.SiteID))
+			// The signature is kept, so the dive shows that it was changed after verification.
+			dive.Data.Verification = existing.Data.Verification
+			dive.Data.Attachments = existing.Data.Attachments
+			// Values of removed custom fields are not in the form, but are kept.
+			for id, value := range existing.Data.Custom {
+				if CustomFields.Find(id) == nil {
+					if dive.Data.Custom == nil {
+						dive.Data.Custom = make(map[string]any)
+					}
+					dive.Data.Custom[id] = value
+				}
+			}
+			change := mlog.Begin(AccountOf(r), "Edited")
+			mlog.Replace(existing, dive)
+			change.Commit()
 		} else {
-			MLog.Insert(dive)
+			change := mlog.Begin(AccountOf(r), "Created")
+			mlog.Insert(dive)
+			change.Commit()
 		}
-		MLog.Unlock()
 		http.Redirect(w, r, "/dives", http.StatusFound)
 	} else { // not ok
 		page.Title = "New Dive"
-		if existing != nil {
+		if dive == nil {
+			dive = EmptyDive()
+		}
+		if existing != nil { // keep the input, but edit the existing dive
 			page.Title = fmt.Sprintf("Dive #%d", existing.Num())
-			page.Dive = existing
-		} else {
-			page.Dive = dive
-			if dive == nil {
-				dive = EmptyDive()
-			}
-			page.Dive = dive
+			dive.id, dive.ix = existing.id, existing.ix
 		}
-		render("dive.html", w, page)
+		page.Dive = dive
+		page.Sites = mlog.Sites().All()
+		page.Trips = mlog.Trips()
+		page.People = mlog.People()
+		page.Gear = mlog.Gear()
+		page.Fields = CustomFields.All()
+		render("dive.html", w, r, page)
 	}
 }
 
@@ -237,7 +526,129 @@ func parseDiveFromRequest(r *http.Request, errorMap map[string]string) (dive *Di
 
 	// Optional parameter: accept even an empty value after input is trimmed.
 	diveRecord.Geo, _ = validateNonEmptyString(r.FormValue(GeoTag))
+	for _, field := range []struct {
+		tag   string
+		value **LatLon
+	}{
+		{EntryPositionTag, &diveRecord.Entry},
+		{ExitPositionTag, &diveRecord.Exit},
+	} {
+		if value := strings.TrimSpace(r.FormValue(field.tag)); value != "" {
+			if position, errMsg := validatePositionInput(value); errMsg != "" {
+				ok = false
+				errorMap[field.tag] = errMsg
+			} else {
+				*field.value = position
+			}
+		}
+	}
+	if diveRecord.Exit != nil && diveRecord.Entry == nil {
+		ok = false
+		errorMap[EntryPositionTag] = "Please provide the entry position along with the exit position."
+	}
 	diveRecord.DecoDive = r.FormValue(DecoDiveTag) == "true"
+	diveRecord.Note = strings.TrimSpace(r.FormValue(NoteTag))
+	if tags, errMsg := validateTagsInput(r.FormValue(TagsTag)); errMsg != "" {
+		ok = false
+		errorMap[TagsTag] = errMsg
+	} else {
+		diveRecord.Tags = tags
+	}
+	for _, field := range CustomFields.All() {
+		if value, errMsg := validateCustomFieldInput(field, r.FormValue(field.Tag())); errMsg != "" {
+			ok = false
+			errorMap[field.Tag()] = errMsg
+		} else if value != nil {
+			if diveRecord.Custom == nil {
+				diveRecord.Custom = make(map[string]any)
+			}
+			diveRecord.Custom[field.ID] = value
+		}
+	}
+	diveRecord.TripID = r.FormValue(TripTag)
+	diveRecord.Gear = r.Form[GearTag]
+	if gases, errMsg := validateGasesInput(r.FormValue(GasesTag), 0); errMsg != "" {
+		ok = false
+		errorMap[GasesTag] = errMsg
+	} else {
+		diveRecord.Gases = gases
+	}
+	for _, field := range []struct {
+		tag   string
+		value *float32
+	}{
+		{MaxDepthTag, &diveRecord.MaxDepth},
+		{AvgDepthTag, &diveRecord.AvgDepth},
+	} {
+		if value := strings.TrimSpace(r.FormValue(field.tag)); value != "" {
+			if depth, errMsg := validateDepthInput(value); errMsg != "" {
+				ok = false
+				errorMap[field.tag] = errMsg
+			} else {
+				*field.value = depth
+			}
+		}
+	}
+	// If there is a profile, depths are derived from it.
+	if profile, err := ParseProfile(r.FormValue(ProfileTag)); err != nil {
+		ok = false
+		errorMap[ProfileTag] = "Please fix the profile, " + strings.TrimPrefix(err.Error(), ErrInvalidProfile.Error()+": ") + "."
+	} else if slices.ContainsFunc(profile, func(s ProfileSample) bool { return s.G > len(diveRecord.BreathingGases()) }) {
+		ok = false
+		errorMap[ProfileTag] = "Please fix the profile, it switches to a gas that is not listed."
+	} else if len(profile) > 0 {
+		diveRecord.Profile = profile
+		diveRecord.MaxDepth, diveRecord.AvgDepth = ProfileDepths(profile)
+	}
+	if errorMap[GasesTag] == "" && errorMap[ProfileTag] == "" {
+		if _, errMsg := validateGasesInput(r.FormValue(GasesTag), diveRecord.MaxDepth); errMsg != "" {
+			ok = false
+			errorMap[GasesTag] = errMsg
+		} else if errMsg := validateProfileGasesInput(diveRecord.Profile, diveRecord.Gases); errMsg != "" {
+			ok = false
+			errorMap[GasesTag] = errMsg
+		}
+	}
+
+	if value := strings.TrimSpace(r.FormValue(TankVolumeTag)); value != "" {
+		if volume, errMsg := validateTankVolumeInput(value); errMsg != "" {
+			ok = false
+			errorMap[TankVolumeTag] = errMsg
+		} else {
+			diveRecord.TankVolume = volume
+		}
+	}
+	for _, field := range []struct {
+		tag   string
+		value *int
+	}{
+		{TankPressureStartTag, &diveRecord.TankPressureStart},
+		{TankPressureEndTag, &diveRecord.TankPressureEnd},
+	} {
+		if value := strings.TrimSpace(r.FormValue(field.tag)); value != "" {
+			if pressure, errMsg := validatePressureInput(value); errMsg != "" {
+				ok = false
+				errorMap[field.tag] = errMsg
+			} else {
+				*field.value = pressure
+			}
+		}
+	}
+	if diveRecord.TankPressureEnd > 0 && diveRecord.TankPressureEnd >= diveRecord.TankPressureStart {
+		ok = false
+		errorMap[TankPressureEndTag] = "End pressure must be lower than start pressure."
+	}
+	for _, field := range []struct{ tag, role string }{
+		{BuddiesTag, RoleBuddy},
+		{GuideTag, RoleGuide},
+		{InstructorTag, RoleInstructor},
+	} {
+		for _, name := range strings.Split(r.FormValue(field.tag), ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				diveRecord.People = append(diveRecord.People, &DiveParticipant{Role: field.role, Name: name})
+			}
+		}
+	}
 
 	return
 }
@@ -259,10 +670,35 @@ func inputValidationHandler(w http.ResponseWriter, r *http.Request) {
 		_, errMsg = validateTimeInput(value)
 	case DurationTag:
 		_, errMsg = validateDurationInMinInput(value)
+	case TagsTag:
+		_, errMsg = validateTagsInput(value)
 	case GeoTag:
 		if value != "" { // optional, so empty value is not an error
 			_, errMsg = validateNonEmptyString(value)
 		}
+	case SitePositionTag, EntryPositionTag, ExitPositionTag:
+		if value != "" {
+			_, errMsg = validatePositionInput(value)
+		}
+	case MaxDepthTag:
+		if value != "" {
+			_, errMsg = validateDepthInput(value)
+		}
+	case AvgDepthTag:
+		if value != "" {
+			var avgDepth float32
+			if avgDepth, errMsg = validateDepthInput(value); errMsg == "" {
+				maxDepth, _ := validateDepthInput(r.URL.Query().Get(MaxDepthTag))
+				errMsg = validateAvgDepth(avgDepth, maxDepth)
+			}
+		}
+	case GasesTag:
+		maxDepth, _ := validateDepthInput(r.URL.Query().Get(MaxDepthTag))
+		_, errMsg = validateGasesInput(value, maxDepth)
+	default:
+		if field := CustomFields.Find(strings.TrimPrefix(tag, CustomFieldTagPrefix)); field != nil && field.Tag() == tag {
+			_, errMsg = validateCustomFieldInput(field, value)
+		}
 	}
 
 	fmt.Fprintf(w, "%s", errMsg)
@@ -277,7 +713,13 @@ func syncHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func render(tmplName string, w http.ResponseWriter, data any) {
+// render executes the page template. If data is a `*Page`, it also gets the account signed in for the request, and
+// the CSRF token of the session.
+func render(tmplName string, w http.ResponseWriter, r *http.Request, data any) {
+	if page, ok := data.(*Page); ok {
+		page.Account = AccountOf(r)
+		page.CSRFToken = CSRFTokenOf(r)
+	}
 	tmpl, err := template.ParseFiles(filepath.Join(TmplDir, tmplName), filepath.Join(TmplDir, "partials.html"))
 	if err != nil {
 		trace(logging.SevError, "%v", err)
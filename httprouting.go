@@ -26,6 +26,16 @@ func register(mux HandlerMux) HandlerMux {
 		http.HandlerFunc(divesHandler),
 	)
 
+	mux.Handle(
+		"GET /dives.geojson",
+		http.HandlerFunc(divesGeoJSONHandler),
+	)
+
+	mux.Handle(
+		"GET /dives.kml",
+		http.HandlerFunc(divesKMLHandler),
+	)
+
 	mux.Handle(
 		"GET /dives/{id}",
 		http.HandlerFunc(diveHandler),
@@ -36,11 +46,76 @@ func register(mux HandlerMux) HandlerMux {
 		http.HandlerFunc(diveFormHandler),
 	)
 
+	mux.Handle(
+		"POST /dives/{id}/verify",
+		http.HandlerFunc(diveVerificationHandler),
+	)
+
+	mux.Handle(
+		"GET /dives/{id}/history",
+		http.HandlerFunc(diveHistoryHandler),
+	)
+
+	mux.Handle(
+		"POST /dives/{id}/revert",
+		http.HandlerFunc(diveRevertHandler),
+	)
+
+	mux.Handle(
+		"POST /dives/{id}/attachments",
+		http.HandlerFunc(diveAttachmentUploadHandler),
+	)
+
+	mux.Handle(
+		"GET /dives/{id}/attachments/{hash}",
+		http.HandlerFunc(diveAttachmentHandler),
+	)
+
+	mux.Handle(
+		"GET /dives/{id}/attachments/{hash}/thumbnail",
+		http.HandlerFunc(diveThumbnailHandler),
+	)
+
+	mux.Handle(
+		"POST /dives/{id}/attachments/{hash}/move",
+		http.HandlerFunc(diveAttachmentMoveHandler),
+	)
+
+	mux.Handle(
+		"DELETE /dives/{id}/attachments/{hash}",
+		http.HandlerFunc(diveAttachmentRemovalHandler),
+	)
+
 	mux.Handle(
 		"DELETE /dives/{id}",
 		http.HandlerFunc(diveRemovalHandler),
 	)
 
+	mux.Handle(
+		"POST /dives/batch",
+		http.HandlerFunc(diveBatchHandler),
+	)
+
+	mux.Handle(
+		"POST /dives/export",
+		http.HandlerFunc(diveExportHandler),
+	)
+
+	mux.Handle(
+		"GET /trash",
+		http.HandlerFunc(trashHandler),
+	)
+
+	mux.Handle(
+		"POST /trash/{id}/restore",
+		http.HandlerFunc(diveRestoreHandler),
+	)
+
+	mux.Handle(
+		"DELETE /trash/{id}",
+		http.HandlerFunc(divePurgeHandler),
+	)
+
 	mux.Handle(
 		"GET /dives/new",
 		http.HandlerFunc(newDiveHandler),
@@ -56,6 +131,11 @@ func register(mux HandlerMux) HandlerMux {
 		http.HandlerFunc(inputValidationHandler),
 	)
 
+	mux.Handle(
+		"GET /actions/complete/tags",
+		http.HandlerFunc(tagCompletionHandler),
+	)
+
 	mux.Handle(
 		"POST /actions/sync",
 		http.HandlerFunc(syncHandler),
@@ -66,5 +146,270 @@ func register(mux HandlerMux) HandlerMux {
 		http.HandlerFunc(syncHandler),
 	)
 
+	mux.Handle(
+		"GET /map",
+		http.HandlerFunc(mapHandler),
+	)
+
+	mux.Handle(
+		"GET /sites",
+		http.HandlerFunc(sitesHandler),
+	)
+
+	mux.Handle(
+		"GET /sites/{id}",
+		http.HandlerFunc(siteHandler),
+	)
+
+	mux.Handle(
+		"POST /sites/{id}/edit",
+		http.HandlerFunc(siteFormHandler),
+	)
+
+	mux.Handle(
+		"DELETE /sites/{id}",
+		http.HandlerFunc(siteRemovalHandler),
+	)
+
+	mux.Handle(
+		"GET /sites/new",
+		http.HandlerFunc(newSiteHandler),
+	)
+
+	mux.Handle(
+		"POST /sites/new",
+		http.HandlerFunc(siteFormHandler),
+	)
+
+	mux.Handle(
+		"POST /sites/merge",
+		http.HandlerFunc(siteMergeHandler),
+	)
+
+	mux.Handle(
+		"GET /trips",
+		http.HandlerFunc(tripsHandler),
+	)
+
+	mux.Handle(
+		"GET /trips/{id}",
+		http.HandlerFunc(tripHandler),
+	)
+
+	mux.Handle(
+		"POST /trips/{id}/edit",
+		http.HandlerFunc(tripFormHandler),
+	)
+
+	mux.Handle(
+		"POST /trips/{id}/assign",
+		http.HandlerFunc(tripAssignmentHandler),
+	)
+
+	mux.Handle(
+		"DELETE /trips/{id}",
+		http.HandlerFunc(tripRemovalHandler),
+	)
+
+	mux.Handle(
+		"GET /trips/new",
+		http.HandlerFunc(newTripHandler),
+	)
+
+	mux.Handle(
+		"POST /trips/new",
+		http.HandlerFunc(tripFormHandler),
+	)
+
+	mux.Handle(
+		"GET /people",
+		http.HandlerFunc(peopleHandler),
+	)
+
+	mux.Handle(
+		"GET /people/{id}",
+		http.HandlerFunc(personHandler),
+	)
+
+	mux.Handle(
+		"POST /people/{id}/edit",
+		http.HandlerFunc(personFormHandler),
+	)
+
+	mux.Handle(
+		"DELETE /people/{id}",
+		http.HandlerFunc(personRemovalHandler),
+	)
+
+	mux.Handle(
+		"GET /people/new",
+		http.HandlerFunc(newPersonHandler),
+	)
+
+	mux.Handle(
+		"POST /people/new",
+		http.HandlerFunc(personFormHandler),
+	)
+
+	mux.Handle(
+		"GET /gear",
+		http.HandlerFunc(gearHandler),
+	)
+
+	mux.Handle(
+		"GET /gear/{id}",
+		http.HandlerFunc(gearItemHandler),
+	)
+
+	mux.Handle(
+		"POST /gear/{id}/edit",
+		http.HandlerFunc(gearItemFormHandler),
+	)
+
+	mux.Handle(
+		"POST /gear/{id}/service",
+		http.HandlerFunc(gearServiceHandler),
+	)
+
+	mux.Handle(
+		"DELETE /gear/{id}",
+		http.HandlerFunc(gearItemRemovalHandler),
+	)
+
+	mux.Handle(
+		"GET /gear/new",
+		http.HandlerFunc(newGearItemHandler),
+	)
+
+	mux.Handle(
+		"POST /gear/new",
+		http.HandlerFunc(gearItemFormHandler),
+	)
+
+	mux.Handle(
+		"GET /plan",
+		http.HandlerFunc(newPlanHandler),
+	)
+
+	mux.Handle(
+		"POST /plan",
+		http.HandlerFunc(planFormHandler),
+	)
+
+	mux.Handle(
+		"GET /plans",
+		http.HandlerFunc(plansHandler),
+	)
+
+	mux.Handle(
+		"GET /plans/{id}",
+		http.HandlerFunc(planHandler),
+	)
+
+	mux.Handle(
+		"POST /plans/{id}/edit",
+		http.HandlerFunc(planFormHandler),
+	)
+
+	mux.Handle(
+		"POST /plans/{id}/link",
+		http.HandlerFunc(planLinkHandler),
+	)
+
+	mux.Handle(
+		"DELETE /plans/{id}",
+		http.HandlerFunc(planRemovalHandler),
+	)
+
+	mux.Handle(
+		"GET /plans/new",
+		http.HandlerFunc(newPlanHandler),
+	)
+
+	mux.Handle(
+		"POST /plans/new",
+		http.HandlerFunc(planFormHandler),
+	)
+
+	mux.Handle(
+		"GET /tags",
+		http.HandlerFunc(tagsHandler),
+	)
+
+	mux.Handle(
+		"GET /fields",
+		http.HandlerFunc(customFieldsHandler),
+	)
+
+	mux.Handle(
+		"POST /fields/new",
+		http.HandlerFunc(customFieldFormHandler),
+	)
+
+	mux.Handle(
+		"DELETE /fields/{id}",
+		http.HandlerFunc(customFieldRemovalHandler),
+	)
+
+	mux.Handle(
+		"GET /stats",
+		http.HandlerFunc(statsHandler),
+	)
+
+	mux.Handle(
+		"GET /club",
+		http.HandlerFunc(clubHandler),
+	)
+
+	mux.Handle(
+		"GET /audit",
+		http.HandlerFunc(auditHandler),
+	)
+
+	mux.Handle(
+		"POST /club/{username}/instructor",
+		http.HandlerFunc(instructorRoleHandler),
+	)
+
+	mux.Handle(
+		"GET /stats/consumption",
+		http.HandlerFunc(consumptionHandler),
+	)
+
+	mux.Handle(
+		"GET /login",
+		http.HandlerFunc(loginPageHandler),
+	)
+
+	mux.Handle(
+		"POST /login",
+		http.HandlerFunc(loginHandler),
+	)
+
+	mux.Handle(
+		"POST /logout",
+		http.HandlerFunc(logoutHandler),
+	)
+
+	mux.Handle(
+		"GET /api/dives",
+		http.HandlerFunc(apiDivesHandler),
+	)
+
+	mux.Handle(
+		"GET /api/dives/{id}",
+		http.HandlerFunc(apiDiveHandler),
+	)
+
+	mux.Handle(
+		"POST /api/verify",
+		http.HandlerFunc(apiVerifyHandler),
+	)
+
+	mux.Handle(
+		"GET /api/instructors/{username}/key",
+		http.HandlerFunc(apiInstructorKeyHandler),
+	)
+
 	return mux
 }
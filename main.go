@@ -1,10 +1,12 @@
 package main
 
 import (
+	"bufio"
 	"flag"
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 
 	"github.com/cicovic-andrija/libgo/fs"
 	"github.com/cicovic-andrija/libgo/https"
@@ -16,15 +18,29 @@ var (
 		host        string
 		port        int
 		logRequests bool
+		pageSize    int
+		gfLow       int
+		gfHigh      int
+		maxPPO2     float64
+		addAccount  string
 	}
 )
 
 func main() {
 	// Initialize.
 	parseArgv()
+	if config.addAccount != "" {
+		addAccount(config.addAccount)
+		return
+	}
 	server = newHTTPSS()
-	register(server)
-	go ensureDataLoadAsync()
+	if err := loadAccounts(); err != nil {
+		crashEarly("load accounts operation failed: %v", err)
+	}
+	if err := loadCustomFields(); err != nil {
+		crashEarly("load custom fields operation failed: %v", err)
+	}
+	register(&AuthMux{Mux: server})
 
 	// Ctrl-C handler.
 	interrupts := make(chan os.Signal, 1)
@@ -45,13 +61,29 @@ func main() {
 
 func parseArgv() {
 	var (
-		devFlag = flag.Bool("d", false, "dev (local) execution")
+		devFlag      = flag.Bool("d", false, "dev (local) execution")
+		pageSizeFlag = flag.Int("page-size", PageSize, "number of dives on a single page of the dive list")
+		gfLowFlag    = flag.Int("gf-low", DefaultGFLow, "gradient factor low (%) of the decompression model")
+		gfHighFlag   = flag.Int("gf-high", DefaultGFHigh, "gradient factor high (%) of the decompression model")
+		maxPPO2Flag  = flag.Float64("max-ppo2", DefaultMaxPPO2, "max. partial pressure of oxygen (bar) of the bottom gas")
+		accountFlag  = flag.String("add-account", "", "create an account with the username, reading the password from stdin, and exit")
 	)
 
 	flag.Parse()
 	config.host = "any"
 	config.port = 443
 	config.logRequests = false
+	config.pageSize = *pageSizeFlag
+	config.gfLow = *gfLowFlag
+	config.gfHigh = *gfHighFlag
+	config.maxPPO2 = *maxPPO2Flag
+	config.addAccount = *accountFlag
+	if config.gfLow < 1 || config.gfLow > config.gfHigh || config.gfHigh > 100 {
+		crashEarly("invalid gradient factors: %d/%d", config.gfLow, config.gfHigh)
+	}
+	if config.maxPPO2 < 1 || config.maxPPO2 > MaxDecoPPO2 {
+		crashEarly("invalid max. ppO2: %.2f", config.maxPPO2)
+	}
 	if *devFlag {
 		config.host = "localhost"
 		config.port = 8080
@@ -90,6 +122,36 @@ func newHTTPSS() *https.HTTPSServer {
 	return srv
 }
 
+// addAccount creates an account from the command line, with the password read from the first line of stdin. The server
+// may be running, it picks the account up on sign-in (see `saveAccounts`).
+func addAccount(usernameInput string) {
+	username, errMsg := validateUsernameInput(usernameInput)
+	if errMsg != "" {
+		crashEarly("invalid username: %s", errMsg)
+	}
+	if err := fs.MkdirIfNotExists(DataDirectory); err != nil {
+		crashEarly("mkdir: %v", err)
+	}
+	if err := loadAccounts(); err != nil {
+		crashEarly("load accounts operation failed: %v", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Password for %s: ", username)
+	password, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	password = strings.TrimRight(password, "\r\n")
+	if errMsg = validatePasswordInput(password, password); errMsg != "" {
+		crashEarly("invalid password: %s", errMsg)
+	}
+
+	if _, err := Users.Add(username, username, password); err != nil {
+		crashEarly("add account operation failed: %v", err)
+	}
+	if err := saveAccounts(); err != nil {
+		crashEarly("save accounts operation failed: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "\nAccount %s created.\n", username)
+}
+
 func crashEarly(format string, v ...any) {
 	if crashFile, err := logging.NewFileLog("crash.log"); err == nil {
 		crashFile.Output(logging.SevError, 2, format, v...)
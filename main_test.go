@@ -1,10 +1,50 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"image"
+	"image/jpeg"
+	"maps"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
 
+// TestMain runs tests in a temporary directory, so dive log operations that persist data don't touch the real data
+// directory.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "ddhs-test")
+	if err == nil {
+		err = os.Mkdir(filepath.Join(dir, DataDirectory), 0755)
+	}
+	if err == nil {
+		err = os.Chdir(dir)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "test setup failed: %v\n", err)
+		os.Exit(1)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
 func TestDiveLogOps(t *testing.T) {
 	diveLog := NewDiveLog()
 
@@ -42,6 +82,298 @@ func TestDiveLogOps(t *testing.T) {
 	}
 }
 
+func TestDiveLogSearch(t *testing.T) {
+	diveLog := NewDiveLog()
+	err := diveLog.Reconstruct([]*DiveRecord{
+		{DateTime: "2022-06-11T12:00", Site: "Ada Ciganlija", Geo: "Belgrade, Serbia"},
+		{DateTime: "2023-04-03T10:30", Site: "Manta Point", Geo: "Nusa Penida, Bali"},
+		{DateTime: "2023-04-04T10:00", Site: "Crystal Bay", Geo: "Nusa Penida, Bali", Note: "Mantas at the point."},
+		{DateTime: "2023-08-20T09:15", Site: "Čiovo", Geo: "Trogir, Croatia", Note: "Octopus under a rock."},
+	})
+	if err != nil {
+		t.Fatalf("Reconstruct: %v", err)
+	}
+
+	for _, tc := range []struct {
+		query string
+		want  []string
+	}{
+		{"manta", []string{"2023-04-03T10-30", "2023-04-04T10-00"}},
+		{"penida crystal", []string{"2023-04-04T10-00"}},
+		{"ciov", []string{"2023-08-20T09-15"}},
+		{"ČIGANLIJA", []string{"2022-06-11T12-00"}},
+		{"ada", []string{"2022-06-11T12-00"}},
+		{"oct rock", []string{"2023-08-20T09-15"}},
+		{"", nil},
+	} {
+		hits := diveLog.Search(tc.query)
+		if len(hits) != len(tc.want) {
+			t.Errorf("Search(%q): got %d hits, want %d", tc.query, len(hits), len(tc.want))
+			continue
+		}
+		for i, hit := range hits {
+			if hit.Dive.ID() != tc.want[i] {
+				t.Errorf("Search(%q)[%d]: got %s, want %s", tc.query, i, hit.Dive.ID(), tc.want[i])
+			}
+		}
+	}
+
+	diveLog.Delete("2023-04-03T10-30")
+	if hits := diveLog.Search("manta"); len(hits) != 1 {
+		t.Errorf("Search after Delete: got %d hits, want 1", len(hits))
+	}
+	if terms := diveLog.index.terms; !slices.IsSorted(terms) || len(terms) != len(diveLog.index.postings) {
+		t.Errorf("SearchIndex: got vocabulary of %d terms for %d postings, want it sorted and in sync", len(terms), len(diveLog.index.postings))
+	}
+
+	// Searches run concurrently under the read lock of the log, so they must not modify the index.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			diveLog.Search("penida")
+		}()
+	}
+	wg.Wait()
+
+	if got, want := Highlight("Ada Ciganlija (Belgrade)", "cigan"), "Ada <mark>Ciganlija</mark> (Belgrade)"; string(got) != want {
+		t.Errorf("Highlight: got %q, want %q", got, want)
+	}
+}
+
+func TestCursorPagination(t *testing.T) {
+	records := []*DiveRecord{
+		{DateTime: "2023-04-01T10:00", Site: "A"},
+		{DateTime: "2023-04-02T10:00", Site: "B"},
+		{DateTime: "2023-04-03T10:00", Site: "C"},
+		{DateTime: "2023-04-04T10:00", Site: "D"},
+	}
+	diveLog := NewDiveLog()
+	if err := diveLog.Reconstruct(records); err != nil {
+		t.Fatalf("Reconstruct: %v", err)
+	}
+
+	first, next := Paginate(diveLog.All(), nil, 2, ChronoCursor)
+	if len(first) != 2 || next == nil || next.ID != "2023-04-02T10-00" {
+		t.Fatalf("first page: got %d dives, cursor %v", len(first), next)
+	}
+
+	cursor, err := DecodeCursor(next.Encode())
+	if err != nil || *cursor != *next {
+		t.Fatalf("DecodeCursor: got %v (%v), want %v", cursor, err, next)
+	}
+
+	// Concurrent edit: a dive is inserted before the cursor, and the dive under the cursor is deleted.
+	diveLog = NewDiveLog()
+	if err := diveLog.Reconstruct(append(records, &DiveRecord{DateTime: "2023-03-01T10:00", Site: "Z"})); err != nil {
+		t.Fatalf("Reconstruct: %v", err)
+	}
+	diveLog.Delete("2023-04-02T10-00")
+
+	second, next := Paginate(diveLog.All(), cursor, 2, ChronoCursor)
+	if len(second) != 2 || second[0].Data.Site != "C" || second[1].Data.Site != "D" {
+		t.Errorf("second page: got %v", second)
+	}
+	if next != nil {
+		t.Errorf("second page: got cursor %v, want nil (no more dives)", next)
+	}
+
+	// Search results continue where they left off, even when dives that don't match are inserted in the meantime.
+	diveLog = NewDiveLog()
+	err = diveLog.Reconstruct([]*DiveRecord{
+		{DateTime: "2023-05-01T10:00", Site: "House Reef"},
+		{DateTime: "2023-05-02T10:00", Site: "Outer Reef"},
+		{DateTime: "2023-05-03T10:00", Site: "Blue Reef"},
+		{DateTime: "2023-05-04T10:00", Site: "Wreck", Note: "Reef sharks on the way back."},
+	})
+	if err != nil {
+		t.Fatalf("Reconstruct: %v", err)
+	}
+	query := &DiveQuery{Search: "reef", Limit: 2}
+	result := query.Run(diveLog)
+	if len(result.Dives) != 2 || result.Next == nil {
+		t.Fatalf("first page of search results: got %d dives, cursor %v", len(result.Dives), result.Next)
+	}
+	for _, site := range []string{"Canyon", "Drift", "Pinnacle"} {
+		dive := NewDive(datetime("2023-06-01T10:00").Add(time.Duration(len(site)) * time.Hour))
+		dive.Data.Site = site
+		diveLog.Insert(dive)
+	}
+	query.Cursor, _ = DecodeCursor(result.Next.Encode())
+	result = query.Run(diveLog)
+	if len(result.Dives) != 2 || result.Dives[0].Data.Site != "House Reef" || result.Dives[1].Data.Site != "Wreck" {
+		t.Errorf("second page of search results: got %v, want House Reef and Wreck", result.Dives)
+	}
+
+	if _, err := DecodeCursor("not a cursor"); err != ErrInvalidCursor {
+		t.Errorf("DecodeCursor: got %v, want %v", err, ErrInvalidCursor)
+	}
+}
+
+func TestSiteCatalog(t *testing.T) {
+	diveLog := NewDiveLog()
+	err := diveLog.Reconstruct([]*DiveRecord{
+		{DateTime: "2023-04-03T10:30", Site: "Manta Point", Geo: "Nusa Penida, Bali, Indonesia"},
+		{DateTime: "2023-04-04T10:00", Site: "manta  point "},
+		{DateTime: "2023-04-05T10:00", Site: "Mantta Point"},
+	})
+	if err != nil {
+		t.Fatalf("Reconstruct: %v", err)
+	}
+
+	sites := diveLog.Sites().All()
+	if len(sites) != 2 {
+		t.Fatalf("Sites: got %d sites, want 2", len(sites))
+	}
+	site := diveLog.Sites().Lookup("MANTA POINT")
+	if site == nil || site.ID != "manta-point" || site.Region != "Nusa Penida, Bali" || site.Country != "Indonesia" {
+		t.Fatalf("Lookup: got %+v", site)
+	}
+	if dives := diveLog.SiteDives(site.ID); len(dives) != 2 || dives[1].Data.Site != "Manta Point" {
+		t.Errorf("SiteDives: got %d dives", len(dives))
+	}
+	if dupes := diveLog.Sites().Duplicates(); len(dupes) != 1 || len(dupes[0]) != 2 {
+		t.Errorf("Duplicates: got %v", dupes)
+	}
+
+	// A location entered for a dive is kept, while one taken from the site follows the site.
+	typed := NewDive(datetime("2023-04-06T10:00"))
+	typed.Data.Site, typed.Data.Geo = "Manta Point", "Toyapakeh, Nusa Penida"
+	diveLog.Insert(typed)
+	if got := typed.Data.Geo; got != "Toyapakeh, Nusa Penida" {
+		t.Errorf("Insert: got location %q, want the one entered", got)
+	}
+	relocated := *site
+	relocated.Region = "Nusa Lembongan, Bali"
+	diveLog.UpdateSite(&relocated)
+	if got := diveLog.Find("2023-04-04T10-00").Data.Geo; got != "Nusa Lembongan, Bali, Indonesia" {
+		t.Errorf("UpdateSite: got location %q, want the one of the site", got)
+	}
+	if got := typed.Data.Geo; got != "Toyapakeh, Nusa Penida" {
+		t.Errorf("UpdateSite: got location %q, want the one entered", got)
+	}
+	diveLog.MergeSites(diveLog.Sites().Find(site.ID), []*Site{diveLog.Sites().Lookup("Mantta Point")})
+	if got := diveLog.Find("2023-04-05T10-00").Data; got.SiteID != site.ID || got.Geo != "Nusa Lembongan, Bali, Indonesia" {
+		t.Errorf("MergeSites: got %s at %q, want the merged site", got.SiteID, got.Geo)
+	}
+}
+
+func TestSuggestTrips(t *testing.T) {
+	records := []*DiveRecord{
+		{DateTime: "2023-01-15T10:00", Site: "Ada Ciganlija", Geo: "Belgrade, Serbia"},
+	}
+	for _, dt := range []string{"2023-10-01T09:00", "2023-10-01T14:00", "2023-10-02T09:00", "2023-10-03T09:00"} {
+		records = append(records, &DiveRecord{DateTime: dt, Site: "Manta Point", Geo: "Bali, Indonesia"})
+	}
+	records = append(records, &DiveRecord{DateTime: "2023-10-03T15:00", Site: "Crystal Bay", Geo: "Bali, Indonesia"})
+	records = append(records, &DiveRecord{DateTime: "2023-10-10T09:00", Site: "Manta Point"}) // too late
+
+	diveLog := NewDiveLog()
+	if err := diveLog.Reconstruct(records); err != nil {
+		t.Fatalf("Reconstruct: %v", err)
+	}
+
+	suggestions := diveLog.SuggestTrips()
+	if len(suggestions) != 1 {
+		t.Fatalf("SuggestTrips: got %d suggestions, want 1", len(suggestions))
+	}
+	if got := suggestions[0]; len(got.Dives) != 5 || got.StartDate() != "2023-10-01" || got.EndDate() != "2023-10-03" {
+		t.Errorf("SuggestTrips: got %d dives, %s - %s", len(got.Dives), got.StartDate(), got.EndDate())
+	}
+}
+
+func TestPeople(t *testing.T) {
+	mlog := NewDiveLog()
+	ana := &Person{Name: "Ana Jovic", Certification: "Rescue Diver"}
+	mlog.AddPerson(ana)
+
+	dive := NewDive(datetime("2024-05-01T10:00"))
+	dive.Data.Site = "Manta Point"
+	dive.Data.People = []*DiveParticipant{
+		{Name: "ana  jovic", Role: RoleBuddy},
+		{Name: "Marko", Role: RoleGuide},
+		{Name: "Ana Jovic", Role: RoleInstructor}, // the same person twice
+		{PersonID: ana.ID, Role: "captain"},
+		{PersonID: "nobody", Role: RoleBuddy},
+	}
+	mlog.Insert(dive)
+
+	marko := mlog.LookupPerson("MARKO")
+	if marko == nil || marko.ID == ana.ID {
+		t.Fatalf("LookupPerson: a person wasn't added to the directory for a new name")
+	}
+	if got := mlog.People(); len(got) != 2 || got[0] != ana || got[1] != marko {
+		t.Errorf("People: got %d people, want Ana and Marko, in order", len(got))
+	}
+	if got := dive.Data.People; len(got) != 2 || dive.Data.Role(ana.ID) != RoleBuddy || got[0].Name != "" {
+		t.Errorf("Insert: got %d participants, want names linked to IDs, and duplicates and unknown roles dropped", len(got))
+	}
+	if got := dive.Data.PeopleIn(RoleGuide); !slices.Equal(got, []string{marko.ID}) {
+		t.Errorf("PeopleIn(%s): got %q", RoleGuide, got)
+	}
+
+	mlog.UpdatePerson(marko, &Person{Name: "Aleksa"})
+	if got := mlog.People(); got[0] != marko || mlog.LookupPerson("Marko") != nil || mlog.LookupPerson("aleksa") != marko {
+		t.Errorf("UpdatePerson: a renamed person wasn't found by the new name only")
+	}
+
+	mlog.RemovePerson(ana.ID)
+	if mlog.LookupPerson("Ana Jovic") != nil || len(mlog.PersonDives(ana.ID)) != 0 {
+		t.Errorf("RemovePerson: the person is still in the directory or on dives")
+	}
+	if got := dive.Data.People; len(got) != 1 || got[0].PersonID != marko.ID {
+		t.Errorf("RemovePerson: got %d participants, want other people kept", len(got))
+	}
+}
+
+func TestGear(t *testing.T) {
+	mlog := NewDiveLog()
+	regulator := &GearItem{Kind: GearRegulator, Name: "Apeks XTX50", Purchased: "2023-01-01", LastService: "2024-01-01", ServiceDays: 365, ServiceDives: 3}
+	computer := &GearItem{Kind: GearComputer, Name: "Shearwater Peregrine", Purchased: "2024-03-01", ServiceDives: 2}
+	suit := &GearItem{Kind: GearSuit, Name: "Wetsuit 5mm", ServiceDives: 1, Retired: true}
+	for _, item := range []*GearItem{regulator, computer, suit} {
+		mlog.AddGear(item)
+	}
+	for _, dt := range []string{"2023-06-01T10:00", "2024-02-01T10:00", "2024-04-01T10:00", "2024-05-01T10:00"} {
+		dive := NewDive(datetime(dt))
+		dive.Data.Site = "Manta Point"
+		dive.Data.Duration = Duration{Duration: 45 * time.Minute}
+		dive.Data.Gear = []string{regulator.ID, computer.ID, suit.ID, "unknown"}
+		mlog.Insert(dive)
+	}
+
+	now := datetime("2024-06-01T00:00")
+	if got := mlog.GearUsage(regulator, now); got.Dives != 4 || got.Time != 3*time.Hour || got.DivesSinceService != 3 ||
+		got.ServiceDue != "3 dives since last service" {
+		t.Errorf("GearUsage: got %d dives, %v, %d since service, due: %q", got.Dives, got.Time, got.DivesSinceService, got.ServiceDue)
+	}
+	if got := mlog.GearUsage(computer, now); got.DivesSinceService != 2 || got.ServiceDue == "" {
+		t.Errorf("GearUsage: got %d dives since purchase, want 2 and service due", got.DivesSinceService)
+	}
+	if got := mlog.GearUsage(regulator, datetime("2025-01-01T00:00")).ServiceDue; got != "service was due on December 31, 2024" {
+		t.Errorf("GearUsage after the service interval: got %q", got)
+	}
+	if got := mlog.ServiceDue(now); len(got) != 2 || got[0].Item != regulator || got[1].Item != computer {
+		t.Errorf("ServiceDue: got %d items, want the regulator and the computer, but not the retired suit", len(got))
+	}
+
+	serviced := *regulator
+	serviced.LastService = "2024-04-15"
+	mlog.UpdateGear(regulator, &serviced)
+	if got := mlog.GearUsage(regulator, now); got.DivesSinceService != 1 || got.ServiceDue != "" {
+		t.Errorf("GearUsage after service: got %d dives since service, due: %q", got.DivesSinceService, got.ServiceDue)
+	}
+	if got := regulator.NextServiceDate(); !got.Equal(datetime("2025-04-15T00:00")) {
+		t.Errorf("NextServiceDate: got %v, want a year after the last service", got)
+	}
+
+	mlog.RemoveGear(computer.ID)
+	if got := mlog.ServiceDue(now); len(got) != 0 || len(mlog.GearDives(computer.ID)) != 0 {
+		t.Errorf("RemoveGear: got %d items due, and the item still on dives", len(got))
+	}
+}
+
 func datetime(str string) time.Time {
 	if dt, err := time.Parse(DateTimeLayout, str); err != nil {
 		panic(err)
@@ -49,3 +381,1509 @@ func datetime(str string) time.Time {
 		return dt
 	}
 }
+
+func TestDecoModel(t *testing.T) {
+	// No-decompression limits on air for ZHL-16C without gradient factors (GF 100/100), as published for Bühlmann
+	// based planners (descent at 18 m/min included in bottom time).
+	model := &DecoModel{GFLow: 1, GFHigh: 1}
+	for _, tc := range []struct {
+		depth float64
+		ndl   float64
+	}{
+		{18, 56}, {21, 38}, {24, 27}, {27, 21}, {30, 16}, {33, 13}, {36, 11}, {39, 9}, {42, 8},
+	} {
+		tissues := SurfaceTissues()
+		tissues.Load(0, tc.depth, tc.depth/DescentRate, Air)
+		if got := model.NDL(tissues, tc.depth, Air); math.Abs(got-tc.ndl) > max(2, tc.ndl*0.1) {
+			t.Errorf("NDL at %.0f m: got %.0f min, want %.0f min", tc.depth, got, tc.ndl)
+		}
+	}
+
+	// Gradient factors must make the model more conservative.
+	conservative := &DecoModel{GFLow: 0.3, GFHigh: 0.7}
+	if ndl, conservativeNDL := model.NDL(SurfaceTissues(), 30, Air), conservative.NDL(SurfaceTissues(), 30, Air); conservativeNDL >= ndl {
+		t.Errorf("NDL at 30 m: got %.0f min with GF 30/70, want less than %.0f min", conservativeNDL, ndl)
+	}
+
+	result := conservative.Evaluate(SquareProfile(40, 30), nil, nil)
+	if !result.RequiredDeco || result.MaxCeiling <= 0 || result.MinNDL != 0 || result.MaxTTS <= 40/AscentRate {
+		t.Errorf("40 m / 30 min on air: got %+v, want a deco. dive", result)
+	}
+	if result := conservative.Evaluate(SquareProfile(12, 40), nil, nil); result.RequiredDeco || result.MaxCeiling > 0 {
+		t.Errorf("12 m / 40 min on air: got %+v, want a no-deco. dive", result)
+	}
+
+	// A profile sampled every few seconds must give (almost) the same result as the same dive sampled coarsely.
+	square := SquareProfile(18, 25)
+	var sampled []ProfileSample
+	for i := 1; i < len(square); i++ {
+		from, to := square[i-1], square[i]
+		for t := from.T; t < to.T; t += 10 {
+			sampled = append(sampled, ProfileSample{T: t, D: from.D + (to.D-from.D)*float32(t-from.T)/float32(to.T-from.T)})
+		}
+	}
+	sampled = append(sampled, square[len(square)-1])
+	coarse, fine := conservative.Evaluate(square, nil, nil), conservative.Evaluate(sampled, nil, nil)
+	if math.Abs(fine.MinNDL-coarse.MinNDL) > 1 || math.Abs(fine.MaxTTS-coarse.MaxTTS) > 1 || fine.MinNDL == 0 {
+		t.Errorf("18 m / 25 min sampled every 10 s: got NDL %.0f min, TTS %.0f min, want %.0f min, %.0f min and no deco.",
+			fine.MinNDL, fine.MaxTTS, coarse.MinNDL, coarse.MaxTTS)
+	}
+
+	// A deco gas must shorten decompression.
+	tissues := SurfaceTissues()
+	tissues.Load(0, 40, 40/DescentRate, Air)
+	tissues.Load(40, 40, 25, Air)
+	onAir, withDecoGas := *tissues, *tissues
+	_, ttsAir := conservative.Ascent(&onAir, 40, Air, []GasMix{Air})
+	stops, ttsDecoGas := conservative.Ascent(&withDecoGas, 40, Air, []GasMix{Air, {O2: 50}})
+	if ttsDecoGas >= ttsAir || len(stops) == 0 || stops[len(stops)-1].Gas.O2 != 50 || stops[len(stops)-1].Depth != LastStopDepth {
+		t.Errorf("ascent with EAN50: got TTS %.0f min and stops %v, want less than %.0f min", ttsDecoGas, stops, ttsAir)
+	}
+}
+
+func TestParseGasesAndProfile(t *testing.T) {
+	gases, err := ParseGases("air, EAN32, nx50, 18/45, oxygen")
+	if err != nil || FormatGases(gases) != "air, EAN32, EAN50, 18/45, oxygen" {
+		t.Errorf("ParseGases: got %v, %v", gases, err)
+	}
+	for _, invalid := range []string{"EAN", "EAN101", "30/80", "air, foo"} {
+		if _, err := ParseGases(invalid); err == nil {
+			t.Errorf("ParseGases(%q): got no error", invalid)
+		}
+	}
+
+	profile, err := ParseProfile("0:00 0\n2 18\n\n30:30 18\n35:00 5 2\n40:00 0\n")
+	if err != nil || len(profile) != 5 || profile[3].G != 2 || profile[2].T != 30*60+30 {
+		t.Fatalf("ParseProfile: got %v, %v", profile, err)
+	}
+	if reparsed, err := ParseProfile(FormatProfile(profile)); err != nil || !slices.Equal(reparsed, profile) {
+		t.Errorf("ParseProfile(FormatProfile(...)): got %v, %v", reparsed, err)
+	}
+	if maxDepth, avgDepth := ProfileDepths(profile); maxDepth != 18 || avgDepth < 14 || avgDepth > 16 {
+		t.Errorf("ProfileDepths: got %.1f, %.1f", maxDepth, avgDepth)
+	}
+	for _, invalid := range []string{"1:00", "1:00 -5", "2:00 10\n1:00 12", "1:75 10", "1:00 10 0"} {
+		if _, err := ParseProfile(invalid); err == nil {
+			t.Errorf("ParseProfile(%q): got no error", invalid)
+		}
+	}
+}
+
+func TestRepetitive(t *testing.T) {
+	config.gfLow, config.gfHigh = DefaultGFLow, DefaultGFHigh
+	duration := Duration{Duration: 45 * time.Minute}
+	records := []*DiveRecord{
+		{DateTime: "2023-10-01T09:00", Duration: duration, Site: "Manta Point", MaxDepth: 25},
+		{DateTime: "2023-10-01T11:45", Duration: duration, Site: "Manta Point", MaxDepth: 18},
+		{DateTime: "2023-10-01T15:00", Duration: duration, Site: "Crystal Bay", MaxDepth: 12},
+		{DateTime: "2023-10-05T09:00", Duration: duration, Site: "Crystal Bay", MaxDepth: 12},
+	}
+	diveLog := NewDiveLog()
+	if err := diveLog.Reconstruct(records); err != nil {
+		t.Fatalf("Reconstruct: %v", err)
+	}
+	dives := diveLog.All()
+
+	first, second, third, later := diveLog.Repetitive(dives[0]), diveLog.Repetitive(dives[1]), diveLog.Repetitive(dives[2]), diveLog.Repetitive(dives[3])
+	if first.Previous != nil || first.Residual != nil || first.DayDive != 1 || first.DayDives != 3 {
+		t.Errorf("first dive: got %+v", first)
+	}
+	if second.Previous != dives[0] || second.SurfaceInterval != 2*time.Hour || second.DayDive != 2 || second.Residual == nil {
+		t.Errorf("second dive: got %+v", second)
+	}
+	if later.Previous != nil || later.Residual != nil || later.DayDives != 1 || later.NoFly != NoFlySingleDive {
+		t.Errorf("dive after 4 days: got %+v", later)
+	}
+	if third.NoFly < NoFlyRepetitiveDive {
+		t.Errorf("third dive: got no-fly time %s, want at least %s", third.NoFly, NoFlyRepetitiveDive)
+	}
+
+	// Residual nitrogen must make the repetitive dive more conservative than the same dive done with clean tissues.
+	if clean, repetitive := dives[1].Deco(nil), dives[1].Deco(second.Residual); repetitive.End.MaxLoading() <= clean.End.MaxLoading() {
+		t.Errorf("second dive: got loading %d%% with residual nitrogen, want more than %d%%", repetitive.End.MaxLoading(), clean.End.MaxLoading())
+	}
+
+	if status := diveLog.NoFlyStatus(dives[3].TimeOut().Add(time.Hour)); status == nil || status.LastDive != dives[3] {
+		t.Errorf("NoFlyStatus: got %+v, want no-fly after the last dive", status)
+	}
+	if status := diveLog.NoFlyStatus(dives[3].TimeOut().Add(NoFlySingleDive)); status != nil {
+		t.Errorf("NoFlyStatus: got %+v, want nil", status)
+	}
+
+	// On a liveaboard with dives every 12 hours, only dives that ended within the window load tissues.
+	liveaboard, recent := NewDiveLog(), NewDiveLog()
+	start := datetime("2024-03-01T08:00")
+	for i := 0; i < 20; i++ {
+		for _, mlog := range []*DiveLog{liveaboard, recent} {
+			if dt := start.Add(time.Duration(i) * 12 * time.Hour); mlog == liveaboard || i >= 15 {
+				dive := NewDive(dt)
+				dive.Data.Site, dive.Data.Duration, dive.Data.MaxDepth = "Manta Point", duration, 25
+				mlog.Insert(dive)
+			}
+		}
+	}
+	last := liveaboard.Repetitive(liveaboard.All()[19])
+	if want := recent.Repetitive(recent.All()[4]); *last.Residual != *want.Residual || last.NoFly != want.NoFly {
+		t.Errorf("liveaboard: got residual loading %d%%, want %d%% from the last 48 hours", last.Residual.MaxLoading(), want.Residual.MaxLoading())
+	}
+	if liveaboard.Repetitive(liveaboard.All()[19]) != last {
+		t.Errorf("Repetitive: got a new result, want the cached one")
+	}
+	liveaboard.Insert(NewDive(start.Add(-time.Hour)))
+	if liveaboard.Repetitive(liveaboard.All()[20]) == last {
+		t.Errorf("Repetitive after Insert: got the cached result, want a new one")
+	}
+}
+
+func TestDivePlan(t *testing.T) {
+	if got := CNSPerMinute(1.6); math.Abs(got-100.0/45) > 1e-9 {
+		t.Errorf("CNSPerMinute(1.6): got %f", got)
+	}
+	if got := OTUPerMinute(1.0); math.Abs(got-1) > 1e-9 {
+		t.Errorf("OTUPerMinute(1.0): got %f", got)
+	}
+	if CNSPerMinute(0.4) != 0 || OTUPerMinute(0.4) != 0 {
+		t.Errorf("CNS and OTU must not accumulate below ppO2 0.5")
+	}
+
+	gases, err := ParsePlanGases("air 15 200\nEAN50 7 200")
+	if err != nil {
+		t.Fatalf("ParsePlanGases: %v", err)
+	}
+	segments, err := ParsePlanSegments("40 25", len(gases))
+	if err != nil {
+		t.Fatalf("ParsePlanSegments: %v", err)
+	}
+	plan := &Plan{Segments: segments, Gases: gases, GFLow: 30, GFHigh: 70, SAC: 20, Reserve: ReserveThirds}
+	result := plan.Compute()
+
+	if !result.Decompression || result.MaxDepth != 40 || result.Runtime <= 25+40/DescentRate+40/AscentRate {
+		t.Fatalf("Compute: got decompression %t, max. depth %.0f m, runtime %.0f min", result.Decompression, result.MaxDepth, result.Runtime)
+	}
+	var switchDepth float64
+	for _, row := range result.Rows {
+		if row.GasSwitch && row.Gas.O2 == 50 {
+			switchDepth = row.Depth
+		}
+	}
+	if switchDepth != 21 {
+		t.Errorf("Compute: got switch to EAN50 at %.0f m, want 21 m (max. ppO2 1.6)", switchDepth)
+	}
+	if last := result.Rows[len(result.Rows)-1]; last.Depth != 0 || last.Runtime != result.Runtime {
+		t.Errorf("Compute: got last row %+v, want the ascent to the surface", last)
+	}
+	if air := result.Gases[0]; air.Consumed <= 0 || air.Required != air.Consumed*3/2 {
+		t.Errorf("Compute: got air consumed %.0f L, required %.0f L", air.Consumed, air.Required)
+	}
+	if result.Oxygen.CNS <= 0 || result.Oxygen.OTU <= 0 || result.Oxygen.MaxPPO2 > MaxDecoPPO2 {
+		t.Errorf("Compute: got oxygen exposure %+v", result.Oxygen)
+	}
+	if maxDepth, _ := ProfileDepths(result.Profile); maxDepth != 40 || result.Profile[len(result.Profile)-1].D != 0 {
+		t.Errorf("Compute: got profile %v", result.Profile)
+	}
+
+	for _, invalid := range []string{"", "40", "40 25 3", "-5 10", "40 25 1 1"} {
+		if _, err := ParsePlanSegments(invalid, len(gases)); err == nil {
+			t.Errorf("ParsePlanSegments(%q): got no error", invalid)
+		}
+	}
+
+	// The dive made according to the plan is evaluated with the gradient factors of the plan, not the default ones.
+	config.gfLow, config.gfHigh = DefaultGFLow, DefaultGFHigh
+	plan = &Plan{Segments: []*PlanSegment{{Depth: 30, Minutes: 13, Gas: 1}}, Gases: gases[:1], GFLow: 100, GFHigh: 100, SAC: 20, Reserve: ReserveThirds}
+	result = plan.Compute()
+	dive := NewDive(datetime("2024-05-01T10:00"))
+	dive.Data.Profile = result.Profile
+	if deco := dive.Deco(nil); result.Decompression || !deco.RequiredDeco {
+		t.Fatalf("30 m / 13 min: got decompression %t with GF 100/100 and %t with GF 30/70", result.Decompression, deco.RequiredDeco)
+	}
+	if got := result.Compare(dive)[4]; got.Label != "Decompression" || got.Actual != got.Planned {
+		t.Errorf("Compare: got %+v, want the dive evaluated like the plan", got)
+	}
+
+	// Gases must not be breathed below their MOD.
+	config.maxPPO2 = DefaultMaxPPO2
+	for segments, wantError := range map[string]bool{"40 25": false, "40 25 2": true, "20 10 2\n40 10 1": true} {
+		form := url.Values{
+			PlanGasesTag: {"air 15 200\nEAN50 7 200"}, PlanSegmentsTag: {segments}, PlanGFLowTag: {"30"}, PlanGFHighTag: {"70"},
+			PlanSACTag: {"20"}, PlanReserveTag: {ReserveThirds},
+		}
+		r := httptest.NewRequest(http.MethodPost, "/plan", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		errorMap := make(map[string]string)
+		if _, ok := parsePlanFromRequest(r, errorMap); ok == wantError || (errorMap[PlanSegmentsTag] != "") != wantError {
+			t.Errorf("parsePlanFromRequest with segments %q: got %v", segments, errorMap)
+		}
+	}
+}
+
+func TestConsumption(t *testing.T) {
+	duration := Duration{Duration: 50 * time.Minute}
+	records := []*DiveRecord{
+		{DateTime: "2023-10-01T09:00", Duration: duration, Site: "Manta Point", AvgDepth: 15}, // consumption unknown
+	}
+	for i, used := range []int{150, 140, 145, 150, 155, 60} {
+		records = append(records, &DiveRecord{
+			DateTime:          fmt.Sprintf("2023-10-%02dT09:00", i+2),
+			Duration:          duration,
+			Site:              "Manta Point",
+			AvgDepth:          15,
+			TankVolume:        12,
+			TankPressureStart: 200,
+			TankPressureEnd:   200 - used,
+		})
+	}
+	diveLog := NewDiveLog()
+	if err := diveLog.Reconstruct(records); err != nil {
+		t.Fatalf("Reconstruct: %v", err)
+	}
+	dives := diveLog.All()
+
+	if consumption := diveLog.Consumption(dives[0]); consumption != nil {
+		t.Errorf("Consumption: got %+v, want nil", consumption)
+	}
+	// 150 bar in 50 minutes at 2.5 bar of ambient pressure.
+	consumption := diveLog.Consumption(dives[1])
+	if wantSAC := 150.0 / 50 / (AmbientPressure(15) / SurfacePressure); consumption == nil || math.Abs(consumption.SAC-wantSAC) > 1e-6 || math.Abs(consumption.RMV-wantSAC*12) > 1e-6 {
+		t.Fatalf("Consumption: got %+v, want SAC %.2f bar/min", consumption, wantSAC)
+	}
+
+	stats := diveLog.ConsumptionStats()
+	if len(stats.Dives) != 6 || len(stats.BySite) != 1 || stats.BySite[0].Dives != 6 || len(stats.ByMonth) != 1 {
+		t.Errorf("ConsumptionStats: got %d dives, %d sites, %d months", len(stats.Dives), len(stats.BySite), len(stats.ByMonth))
+	}
+	if len(stats.Outliers) != 1 || stats.Outliers[0].Dive != dives[6] || !stats.Outlier(dives[6]) || stats.Outlier(dives[1]) {
+		t.Errorf("ConsumptionStats: got %d outliers, want the last dive", len(stats.Outliers))
+	}
+	if diveLog.ConsumptionStats() != stats {
+		t.Errorf("ConsumptionStats: got new statistics, want the cached ones")
+	}
+	dive := NewDive(datetime("2023-10-20T09:00"))
+	dive.Data.Site, dive.Data.Duration, dive.Data.AvgDepth = "Manta Point", duration, 15
+	dive.Data.TankVolume, dive.Data.TankPressureStart, dive.Data.TankPressureEnd = 12, 200, 50
+	diveLog.Insert(dive)
+	if got := diveLog.ConsumptionStats(); len(got.Dives) != 7 {
+		t.Errorf("ConsumptionStats after Insert: got %d dives, want 7", len(got.Dives))
+	}
+}
+
+func TestOxygenExposure(t *testing.T) {
+	config.maxPPO2 = DefaultMaxPPO2
+	ean32 := GasMix{O2: 32}
+	if mod := ean32.MOD(1.4); math.Abs(mod-33.6) > 0.1 {
+		t.Errorf("MOD of EAN32 at 1.4 bar: got %.1f m, want 33.6 m", mod)
+	}
+	if end := (GasMix{O2: 18, He: 45}).END(60); math.Abs(end-28.4) > 0.1 {
+		t.Errorf("END of 18/45 at 60 m: got %.1f m, want 28.4 m", end)
+	}
+	if _, errMsg := validateGasesInput("EAN32, EAN50", 35); errMsg == "" {
+		t.Errorf("validateGasesInput: got no error for EAN32 at 35 m")
+	}
+	if _, errMsg := validateGasesInput("EAN32, EAN50", 30); errMsg != "" {
+		t.Errorf("validateGasesInput: got %q for EAN32 at 30 m", errMsg)
+	}
+
+	duration := Duration{Duration: 45 * time.Minute}
+	records := []*DiveRecord{
+		{DateTime: "2023-10-01T09:00", Duration: duration, Site: "Manta Point", MaxDepth: 30, Gases: []GasMix{ean32}},
+		{DateTime: "2023-10-01T12:00", Duration: duration, Site: "Manta Point", MaxDepth: 30, Gases: []GasMix{ean32}},
+		{DateTime: "2023-10-04T09:00", Duration: duration, Site: "Manta Point", MaxDepth: 30, Gases: []GasMix{ean32}},
+	}
+	diveLog := NewDiveLog()
+	if err := diveLog.Reconstruct(records); err != nil {
+		t.Fatalf("Reconstruct: %v", err)
+	}
+	dives := diveLog.All()
+
+	first, second, later := diveLog.OxygenLoad(dives[0]), diveLog.OxygenLoad(dives[1]), diveLog.OxygenLoad(dives[2])
+	if first == nil || first.CNSStart != 0 || first.Dive.CNS <= 0 || first.OTU24h != first.Dive.OTU {
+		t.Fatalf("first dive: got %+v", first)
+	}
+	// 2h 15m at the surface eliminates CNS with a half-time of 90 minutes.
+	if want := first.CNSEnd * math.Pow(0.5, 135.0/90); math.Abs(second.CNSStart-want) > 1e-6 || second.OTU24h <= second.Dive.OTU {
+		t.Errorf("second dive: got CNS start %.1f%% (want %.1f%%) and OTU in 24h %.0f", second.CNSStart, want, second.OTU24h)
+	}
+	if later.CNSStart != 0 || later.OTU24h != later.Dive.OTU {
+		t.Errorf("dive after 3 days: got %+v", later)
+	}
+
+	// CNS decays from the end of the last dive with known exposure, across dives with unknown exposure.
+	records = slices.Insert(records, 1, &DiveRecord{DateTime: "2023-10-01T10:30", Site: "Manta Point"})
+	diveLog = NewDiveLog()
+	if err := diveLog.Reconstruct(records); err != nil {
+		t.Fatalf("Reconstruct: %v", err)
+	}
+	dives = diveLog.All()
+	if unknown := diveLog.OxygenLoad(dives[1]); unknown != nil {
+		t.Errorf("dive of unknown exposure: got %+v, want nil", unknown)
+	}
+	if got := diveLog.OxygenLoad(dives[2]); math.Abs(got.CNSStart-second.CNSStart) > 1e-6 {
+		t.Errorf("second dive after a dive of unknown exposure: got CNS start %.1f%%, want %.1f%%", got.CNSStart, second.CNSStart)
+	}
+}
+
+func TestValidateDive(t *testing.T) {
+	duration := Duration{Duration: 50 * time.Minute}
+	diveLog := NewDiveLog()
+	if err := diveLog.Reconstruct([]*DiveRecord{
+		{DateTime: "2023-10-01T09:00", Duration: duration, Site: "Manta Point", MaxDepth: 18},
+		{DateTime: "2023-10-01T14:00", Duration: duration, Site: "Manta Point", MaxDepth: 18},
+	}); err != nil {
+		t.Fatalf("Reconstruct: %v", err)
+	}
+	dives := diveLog.All()
+	now := time.Date(2023, 10, 2, 12, 0, 0, 0, time.UTC)
+
+	newDive := func(dt string, minutes int, maxDepth, avgDepth float32, deco bool) *Dive {
+		in, _ := time.Parse(DateTimeLayout, dt)
+		dive := NewDive(in)
+		dive.Data.Duration = Duration{Duration: time.Duration(minutes) * time.Minute}
+		dive.Data.MaxDepth, dive.Data.AvgDepth, dive.Data.DecoDive = maxDepth, avgDepth, deco
+		return dive
+	}
+
+	tripDive := newDive("2023-10-01T11:00", 50, 18, 12, false)
+	tripDive.Data.TripID = "bali-2023"
+
+	for _, tc := range []struct {
+		name             string
+		dive             *Dive
+		existing         *Dive
+		errors, warnings []string
+	}{
+		{"valid", newDive("2023-10-01T11:00", 50, 18, 12, false), nil, nil, nil},
+		{"future", newDive("2023-10-03T11:00", 50, 18, 12, false), nil, []string{DateTag}, nil},
+		{"depths", newDive("2023-10-01T11:00", 50, 12, 18, false), nil, []string{AvgDepthTag}, nil},
+		{"no max. depth", newDive("2023-10-01T11:00", 50, 0, 12, false), nil, []string{MaxDepthTag}, nil},
+		{"overlaps previous", newDive("2023-10-01T09:30", 50, 18, 12, false), nil, []string{TimeInTag}, nil},
+		{"same time", newDive("2023-10-01T09:00", 50, 18, 12, false), nil, []string{TimeInTag}, nil},
+		{"overlaps next", newDive("2023-10-01T13:30", 50, 18, 12, false), nil, []string{DurationTag}, nil},
+		{"replaces existing", newDive("2023-10-01T09:00", 60, 18, 12, false), dives[0], nil, nil},
+		{"short interval", newDive("2023-10-01T09:55", 30, 12, 8, false), nil, nil, []string{TimeInTag}},
+		{"shallow deco", newDive("2023-10-01T11:00", 30, 6, 4, true), nil, nil, []string{DecoDiveTag}},
+		{"square deco", newDive("2023-10-01T11:00", 50, 30, 20, false), nil, nil, nil},
+		{"unknown trip", tripDive, nil, []string{TripTag}, nil},
+	} {
+		issues := diveLog.ValidateDive(tc.dive, tc.existing, now)
+		for _, want := range []struct {
+			kind string
+			got  map[string]string
+			tags []string
+		}{
+			{"errors", issues.Errors, tc.errors},
+			{"warnings", issues.Warnings, tc.warnings},
+		} {
+			if len(want.got) != len(want.tags) {
+				t.Errorf("%s: got %s %v, want %v", tc.name, want.kind, want.got, want.tags)
+			}
+			for _, tag := range want.tags {
+				if want.got[tag] == "" {
+					t.Errorf("%s: got %s %v, want %q", tc.name, want.kind, want.got, tag)
+				}
+			}
+		}
+	}
+
+	profiled := newDive("2023-10-01T11:00", 50, 0, 0, false)
+	profiled.Data.Profile = []ProfileSample{{T: 0, D: 0}, {T: 120, D: 40}, {T: 40 * 60, D: 40}, {T: 50 * 60, D: 0}}
+	if issues := diveLog.ValidateDive(profiled, nil, now); issues.Warnings[DecoDiveTag] == "" {
+		t.Errorf("ValidateDive: got warnings %v, want deco. flag mismatch", issues.Warnings)
+	}
+}
+
+func TestLogStats(t *testing.T) {
+	var records []*DiveRecord
+	for i, depth := range []float32{12, 18, 31, 7, 22} {
+		records = append(records, &DiveRecord{
+			DateTime: fmt.Sprintf("2023-%02d-01T09:00", 2*i+1),
+			Duration: Duration{Duration: time.Duration(40+5*i) * time.Minute},
+			Site:     "Manta Point",
+			Geo:      "Nusa Penida, Indonesia",
+			MaxDepth: depth,
+			DecoDive: depth > 30,
+		})
+	}
+	records = append(records, &DiveRecord{DateTime: "2024-02-01T09:00", Duration: Duration{Duration: 30 * time.Minute}, Site: "Blue Hole"})
+	diveLog := NewDiveLog()
+	if err := diveLog.Reconstruct(records); err != nil {
+		t.Fatalf("Reconstruct: %v", err)
+	}
+
+	stats := diveLog.Stats(func(*Dive) bool { return true })
+	if stats.Summary.Dives != 6 || stats.DecoDives != 1 || stats.DecoPercent() != 17 {
+		t.Errorf("Stats: got %d dives, %d deco", stats.Summary.Dives, stats.DecoDives)
+	}
+	if len(stats.PerYear.Bars) != 2 || stats.PerYear.Bars[0].Value != 5 || len(stats.PerMonth.Bars) != 14 {
+		t.Errorf("Stats: got %d years, %d months", len(stats.PerYear.Bars), len(stats.PerMonth.Bars))
+	}
+	if len(stats.Depths.Bars) != 7 || stats.Depths.Bars[2].Value != 1 || stats.Depths.Bars[3].Value != 1 {
+		t.Errorf("Stats: got depth histogram %d bars", len(stats.Depths.Bars))
+	}
+	if stats.Deepest[0].Data.MaxDepth != 31 || len(stats.Deepest) != 5 || stats.Longest[0].Num() != 5 {
+		t.Errorf("Stats: got deepest %v m, longest #%d", stats.Deepest[0].Data.MaxDepth, stats.Longest[0].Num())
+	}
+	if len(stats.TopSites) != 2 || stats.TopSites[0].Name != "Manta Point" || stats.TopSites[0].Dives != 5 {
+		t.Errorf("Stats: got top sites %v", stats.TopSites)
+	}
+	if len(stats.MilestoneDives) != 1 || stats.MilestoneDives[0].Num() != 1 || !IsMilestone(100) || !IsMilestone(1500) || IsMilestone(600) {
+		t.Errorf("Stats: got %d milestone dives", len(stats.MilestoneDives))
+	}
+	if svg := string(stats.PerYear.SVG()); strings.Count(svg, "<rect") != 2 || !strings.Contains(svg, ">2023<") {
+		t.Errorf("SVG: got %s", svg)
+	}
+
+	query := &DiveQuery{After: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	if stats := diveLog.Stats(query.InDateRange); stats.Summary.Dives != 1 || len(stats.MilestoneDives) != 0 || !stats.Depths.Empty() {
+		t.Errorf("Stats: got %d dives in range", stats.Summary.Dives)
+	}
+
+	w := httptest.NewRecorder()
+	statsHandler(w, httptest.NewRequest(http.MethodGet, "/stats?cursor=forged", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("statsHandler with an invalid query: got %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestAccounts(t *testing.T) {
+	users := NewAccounts()
+	if !users.Empty() {
+		t.Fatalf("Empty: got false for new accounts")
+	}
+	account, err := users.Add("andrija", "Andrija", "correct horse")
+	if err != nil || account.PasswordHash == "correct horse" || !account.Admin {
+		t.Fatalf("Add: got %+v, %v, want the first account to be an admin", account, err)
+	}
+	if _, err = users.Add("andrija", "Other", "battery staple"); err != ErrAccountExists {
+		t.Errorf("Add: got %v, want ErrAccountExists", err)
+	}
+	if buddy, err := users.Add("buddy", "Buddy", "battery staple"); err != nil || buddy.Admin {
+		t.Errorf("Add: got %+v, %v, want a regular account", buddy, err)
+	}
+	if got, err := users.Authenticate("andrija", "correct horse"); got != account || err != nil {
+		t.Errorf("Authenticate: got %v, %v", got, err)
+	}
+	for _, credentials := range [][2]string{{"andrija", "wrong horse"}, {"nobody", "correct horse"}} {
+		if got, err := users.Authenticate(credentials[0], credentials[1]); got != nil || err != ErrInvalidCredentials {
+			t.Errorf("Authenticate(%q): got %v, %v", credentials[0], got, err)
+		}
+	}
+
+	now := time.Now()
+	token, expires := users.NewSession(account, now)
+	if got := users.Session(token, now.Add(time.Hour)); got != account {
+		t.Errorf("Session: got %v", got)
+	}
+	if got := users.Session(token, expires); got != nil {
+		t.Errorf("Session: got %v after expiry", got)
+	}
+	token, _ = users.NewSession(account, now)
+	if users.EndSession(token); users.Session(token, now) != nil {
+		t.Errorf("Session: got an account after the session ended")
+	}
+
+	defer func(users *Accounts) { Users = users }(Users)
+	Users = users
+	token, _ = users.NewSession(account, now)
+	protected := requireAccount(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, AccountOf(r).Name)
+	}))
+	for _, tc := range []struct {
+		path, cookie, hx string
+		status           int
+		location         string
+	}{
+		{"/dives?q=reef", "", "", http.StatusSeeOther, "/login?next=%2Fdives%3Fq%3Dreef"},
+		{"/dives", "forged", "true", http.StatusUnauthorized, ""},
+		{"/api/dives", "", "", http.StatusUnauthorized, ""},
+		{"/dives", token, "", http.StatusOK, ""},
+	} {
+		r := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		if tc.cookie != "" {
+			r.AddCookie(&http.Cookie{Name: SessionCookieName, Value: tc.cookie})
+		}
+		if tc.hx != "" {
+			r.Header.Set("HX-Request", tc.hx)
+		}
+		w := httptest.NewRecorder()
+		protected.ServeHTTP(w, r)
+		if w.Code != tc.status || w.Header().Get("Location") != tc.location {
+			t.Errorf("%s: got %d %q, want %d %q", tc.path, w.Code, w.Header().Get("Location"), tc.status, tc.location)
+		}
+		if tc.hx != "" && !strings.HasPrefix(w.Header().Get("HX-Redirect"), "/login?next=") {
+			t.Errorf("%s: got HX-Redirect %q", tc.path, w.Header().Get("HX-Redirect"))
+		}
+		if tc.status == http.StatusOK && w.Body.String() != "Andrija" {
+			t.Errorf("%s: got %q, want the signed-in account", tc.path, w.Body.String())
+		}
+	}
+
+	for next, want := range map[string]string{"/dives/1": "/dives/1", "//evil.com": "/", "https://evil.com": "/", "": "/"} {
+		if got := safeNext(next); got != want {
+			t.Errorf("safeNext(%q): got %q, want %q", next, got, want)
+		}
+	}
+
+	// An account added from the command line while the server runs is merged when the server saves accounts, and a
+	// change the server saved meanwhile is kept by the command line.
+	defer os.Remove(filepath.Join(DataDirectory, AccountsFileName))
+	if err := saveAccounts(); err != nil {
+		t.Fatalf("saveAccounts: %v", err)
+	}
+	server, cli := users, NewAccounts()
+	Users = cli
+	if err := loadAccounts(); err != nil || cli.Find("buddy") == nil {
+		t.Fatalf("loadAccounts: got %v, want the saved accounts", err)
+	}
+	Users = server
+	server.SetInstructor("buddy", true)
+	if err := saveAccounts(); err != nil {
+		t.Fatalf("saveAccounts: %v", err)
+	}
+	Users = cli
+	cli.Add("diver", "Diver", "battery staple")
+	if err := saveAccounts(); err != nil {
+		t.Fatalf("saveAccounts: %v", err)
+	}
+	Users = server
+	// A lock left behind by a crashed process is taken over.
+	lock := filepath.Join(DataDirectory, AccountsLockFileName)
+	os.WriteFile(lock, nil, 0600)
+	os.Chtimes(lock, now.Add(-time.Minute), now.Add(-time.Minute))
+	server.SetInstructor("andrija", true)
+	if err := saveAccounts(); err != nil {
+		t.Fatalf("saveAccounts with a stale lock: %v", err)
+	}
+	persisted, err := readAccounts()
+	if err != nil || len(persisted) != 3 || !persisted[0].Instructor || !persisted[1].Instructor || persisted[2].Username != "diver" {
+		t.Errorf("saveAccounts: got %d accounts, %v, want all 3 with both instructors", len(persisted), err)
+	}
+	if server.Find("diver") == nil || fileExists(lock) {
+		t.Errorf("saveAccounts: want the account added from the command line known, and the lock released")
+	}
+}
+
+func TestLogRegistry(t *testing.T) {
+	legacy, err := os.Create(filepath.Join(DataDirectory, DiveLogFileName))
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	NewEncoder(legacy).Encode(&PersistedDiveLog{
+		Version:  fmt.Sprintf("%d:%d", LogMajor, 7),
+		Modified: time.Now().UTC().Format(time.RFC3339),
+		Dives:    []*DiveRecord{{DateTime: "2023-10-01T09:00", Duration: Duration{Duration: 50 * time.Minute}, Site: "Manta Point", MaxDepth: 18}},
+	})
+	legacy.Close()
+
+	var (
+		admin    = &Account{Username: "admin", Name: "Admin", Admin: true}
+		member   = &Account{Username: "member", Name: "Member"}
+		registry = &LogRegistry{logs: make(map[string]*DiveLog)}
+	)
+	memberLog, err := registry.Open(member)
+	if err != nil || len(memberLog.All()) != 0 {
+		t.Fatalf("Open(member): got %v, %v, want an empty log", memberLog, err)
+	}
+	adminLog, err := registry.Open(admin)
+	if err != nil || len(adminLog.All()) != 1 || adminLog.sequence != 8 || fileExists(filepath.Join(DataDirectory, DiveLogFileName)) {
+		t.Fatalf("Open(admin): got %v, want the adopted legacy log", err)
+	}
+	if again, _ := registry.Open(member); again != memberLog || again == adminLog {
+		t.Errorf("Open(member): got a different log")
+	}
+
+	memberLog.Lock()
+	err = memberLog.Reconstruct([]*DiveRecord{{DateTime: "2023-10-02T10:00", Duration: Duration{Duration: 40 * time.Minute}, Site: "Blue Hole", MaxDepth: 30}})
+	if err == nil {
+		err = memberLog.save()
+	}
+	memberLog.Unlock()
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	reloaded, err := (&LogRegistry{logs: make(map[string]*DiveLog)}).Open(member)
+	if err != nil || len(reloaded.All()) != 1 || reloaded.All()[0].Data.Site != "Blue Hole" {
+		t.Errorf("Open(member): got %v after reload", err)
+	}
+
+	stats, err := registry.ClubStats([]*Account{admin, member})
+	if err != nil || stats.Dives != 2 || stats.MaxDepth != 30 || len(stats.Members) != 2 || stats.Members[1].Dives != 1 {
+		t.Fatalf("ClubStats: got %+v, %v", stats, err)
+	}
+	if len(stats.PerYear.Bars) != 1 || len(stats.TopSites) != 2 {
+		t.Errorf("ClubStats: got %d years, %d sites", len(stats.PerYear.Bars), len(stats.TopSites))
+	}
+}
+
+func TestCSRF(t *testing.T) {
+	defer func(users *Accounts) { Users = users }(Users)
+	Users = NewAccounts()
+	account := &Account{Username: "andrija", Name: "Andrija"}
+	token, _ := Users.NewSession(account, time.Now())
+	csrf := Users.CSRFToken(token)
+	if csrf == "" || csrf == token {
+		t.Fatalf("CSRFToken: got %q", csrf)
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { fmt.Fprint(w, "ok") })
+	protected := requireAccount(verifyCSRF(handler))
+	public := verifyCSRF(handler)
+	for _, tc := range []struct {
+		name           string
+		handler        http.Handler
+		method, body   string
+		header, origin string
+		status         int
+	}{
+		{"read", protected, http.MethodGet, "", "", "https://evil.example", http.StatusOK},
+		{"no token", protected, http.MethodDelete, "", "", "", http.StatusForbidden},
+		{"wrong token", protected, http.MethodDelete, "", "forged", "", http.StatusForbidden},
+		{"header token", protected, http.MethodDelete, "", csrf, "https://example.com", http.StatusOK},
+		{"form token", protected, http.MethodPost, CSRFTag + "=" + csrf, "", "", http.StatusOK},
+		{"cross-origin", protected, http.MethodPost, "", csrf, "https://evil.example", http.StatusForbidden},
+		{"plain http origin", protected, http.MethodPost, "", csrf, "http://example.com", http.StatusForbidden},
+		{"public same origin", public, http.MethodPost, "", "", "https://example.com", http.StatusOK},
+		{"public cross-origin", public, http.MethodPost, "", "", "https://evil.example", http.StatusForbidden},
+	} {
+		r := httptest.NewRequest(tc.method, "https://example.com/dives/x", strings.NewReader(tc.body))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.AddCookie(&http.Cookie{Name: SessionCookieName, Value: token})
+		if tc.header != "" {
+			r.Header.Set(CSRFHeader, tc.header)
+		}
+		if tc.origin != "" {
+			r.Header.Set("Origin", tc.origin)
+		}
+		w := httptest.NewRecorder()
+		tc.handler.ServeHTTP(w, r)
+		if w.Code != tc.status {
+			t.Errorf("%s: got %d, want %d", tc.name, w.Code, tc.status)
+		}
+	}
+
+	// The token isn't read from multipart forms, as it would be parsed before the handler limits the size of the body.
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField(CSRFTag, csrf)
+	form.Close()
+	r := httptest.NewRequest(http.MethodPost, "https://example.com/dives/x/attachments", &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	r.AddCookie(&http.Cookie{Name: SessionCookieName, Value: token})
+	w := httptest.NewRecorder()
+	protected.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden || r.MultipartForm != nil {
+		t.Errorf("multipart form token: got %d, want %d without parsing the body", w.Code, http.StatusForbidden)
+	}
+}
+
+func TestVerification(t *testing.T) {
+	defer func(users *Accounts) { Users = users }(Users)
+	Users = NewAccounts()
+	instructor, _ := Users.Add("marko", "Marko", "secret123")
+	diver, _ := Users.Add("andrija", "Andrija", "secret123")
+	token, _ := Users.NewSession(instructor, time.Now())
+	if err := Users.SetInstructor(instructor.Username, true); err != nil {
+		t.Fatalf("SetInstructor: %v", err)
+	}
+	if instructor.Instructor {
+		t.Errorf("SetInstructor: the account was modified, want it replaced")
+	}
+	instructor = Users.Find(instructor.Username)
+	if !instructor.Instructor || Users.Session(token, time.Now()) != instructor {
+		t.Fatalf("SetInstructor: got %+v, want an instructor, also in their session", instructor)
+	}
+	if _, err := Sign(&DiveRecord{}, CatalogNames{}, diver, time.Now()); err == nil {
+		t.Errorf("Sign: expected an error for a diver who is not an instructor")
+	}
+
+	record := &DiveRecord{
+		DateTime: "2024-05-01T10:00:00+08:00",
+		Duration: Duration{Duration: 45 * time.Minute},
+		Site:     "Manta Point",
+		SiteID:   "s1",
+		TripID:   "t1",
+		People:   []*DiveParticipant{{PersonID: "p1", Role: RoleInstructor}, {PersonID: "p2", Role: RoleBuddy}},
+		Gear:     []string{"g1"},
+		MaxDepth: 18.5,
+		Gases:    []GasMix{{O2: 32}},
+		Profile:  []ProfileSample{{T: 0, D: 0}, {T: 600, D: 18.5}, {T: 2700, D: 0}},
+	}
+	if err := record.CheckVerification(); err != ErrNotVerified {
+		t.Errorf("CheckVerification: got %v, want %v", err, ErrNotVerified)
+	}
+	names := CatalogNames{TripID: "t1", Trip: "Bali", People: map[string]string{"p1": "Marko", "p2": "Ana"}, Gear: map[string]string{"g1": "Wing"}}
+	v, err := Sign(record, names, instructor, time.Now())
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	record.Verification = v
+	if !record.Verified() {
+		t.Fatalf("Verified: expected a freshly signed record to be verified")
+	}
+	if v.PublicKey != base64.StdEncoding.EncodeToString(instructor.PublicKey()) {
+		t.Errorf("Sign: public key does not match the instructor's key")
+	}
+
+	// The signature survives an export, and catalog references are not part of it.
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).Encode(NewDiveResource(&Dive{Data: record})); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	exported := &DiveRecord{}
+	if err := json.Unmarshal(buf.Bytes(), exported); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	exported.SiteID = "s2"
+	if err := exported.CheckVerification(); err != nil {
+		t.Errorf("CheckVerification of the export: %v", err)
+	}
+
+	// Any change to the dive invalidates the signature, and so does a forged signer.
+	exported.MaxDepth = 30
+	if err := exported.CheckVerification(); err != ErrInvalidSignature {
+		t.Errorf("CheckVerification of a changed dive: got %v, want %v", err, ErrInvalidSignature)
+	}
+	exported.MaxDepth = record.MaxDepth
+	exported.Verification.Instructor = diver.Username
+	if err := exported.CheckVerification(); err != ErrInvalidSignature {
+		t.Errorf("CheckVerification with a forged instructor: got %v, want %v", err, ErrInvalidSignature)
+	}
+
+	mlog := NewDiveLog()
+	dive := NewDive(datetime("2024-05-01T10:00"))
+	dive.Data.People = []*DiveParticipant{{Name: "Marko", Role: RoleInstructor}}
+	mlog.Insert(dive)
+	if got := mlog.CatalogNames(dive.Data); len(got.People) != 1 || got.People[dive.Data.People[0].PersonID] != "Marko" {
+		t.Errorf("CatalogNames: got %+v, want the instructor's name", got)
+	}
+
+	// Swapping people, their roles, gear or the trip invalidates the signature.
+	for change, edit := range map[string]func(dr *DiveRecord){
+		"instructor swapped": func(dr *DiveRecord) { dr.People[0].PersonID = "p3" },
+		"roles swapped":      func(dr *DiveRecord) { dr.People[0].Role, dr.People[1].Role = RoleBuddy, RoleInstructor },
+		"buddy added":        func(dr *DiveRecord) { dr.People = append(dr.People, &DiveParticipant{PersonID: "p3", Role: RoleBuddy}) },
+		"gear removed":       func(dr *DiveRecord) { dr.Gear = nil },
+		"trip changed":       func(dr *DiveRecord) { dr.TripID = "t2" },
+	} {
+		changed := record.Clone()
+		edit(changed)
+		if err := changed.CheckVerification(); err != ErrInvalidSignature {
+			t.Errorf("CheckVerification, %s: got %v, want %v", change, err, ErrInvalidSignature)
+		}
+	}
+
+	// Changes of the site in the catalog don't invalidate the signature, as the site is frozen in the verification.
+	record.Site, record.Geo = "Manta Point North", "Nusa Penida, Indonesia"
+	if !record.Verified() || record.Verification.Site != "Manta Point" {
+		t.Errorf("Verified: a renamed or relocated site invalidated the signature")
+	}
+
+	// Failed password checks are throttled by instructor and by session, until the earliest one is out of the window.
+	attempts, now := NewAttempts(), time.Now()
+	for i := 0; i < MaxFailedAttempts; i++ {
+		if !attempts.Allowed(now, "account:marko", "session:1") {
+			t.Fatalf("Allowed: refused after %d failed checks, want %d allowed", i, MaxFailedAttempts)
+		}
+		attempts.Fail(now.Add(time.Duration(i)*time.Minute), "account:marko", "session:1")
+	}
+	later := now.Add(MaxFailedAttempts * time.Minute)
+	if attempts.Allowed(later, "account:marko", "session:2") || attempts.Allowed(later, "account:ana", "session:1") {
+		t.Error("Allowed: got a check allowed for a throttled instructor or session")
+	}
+	if !attempts.Allowed(later, "account:ana", "session:2") || !attempts.Allowed(now.Add(FailedAttemptWindow), "account:marko") {
+		t.Error("Allowed: got a check refused for other keys, or after the window")
+	}
+
+	// Revoking the role keeps the key, so earlier verifications can still be checked.
+	Users.SetInstructor(instructor.Username, false)
+	if instructor = Users.Find(instructor.Username); instructor.PublicKey() == nil || !record.Verified() {
+		t.Errorf("SetInstructor: the signing key must be kept when the role is revoked")
+	}
+}
+
+func TestHistory(t *testing.T) {
+	var (
+		mlog    = NewDiveLog()
+		account = &Account{Username: "andrija"}
+	)
+	dive := NewDive(datetime("2024-05-01T10:00"))
+	dive.Data.Site = "Manta Point"
+	dive.Data.Duration = Duration{Duration: 45 * time.Minute}
+	change := mlog.Begin(account, "Created")
+	mlog.Insert(dive)
+	if got := change.Commit(); got != 1 {
+		t.Fatalf("Commit after Insert: got %d revisions, want 1", got)
+	}
+
+	edited := NewDive(datetime("2024-05-01T10:00"))
+	edited.Data.Site = "Manta Point"
+	edited.Data.Duration = Duration{Duration: 50 * time.Minute}
+	edited.Data.Note = "Mantas at the cleaning station."
+	change = mlog.Begin(account, "Edited")
+	mlog.Replace(dive, edited)
+	change.Commit()
+
+	// Unchanged dives are not recorded, changes made through catalogs are.
+	change = mlog.Begin(account, "Nothing")
+	if got := change.Commit(); got != 0 {
+		t.Errorf("Commit without changes: got %d revisions, want 0", got)
+	}
+	other := NewDive(datetime("2024-05-02T10:00"))
+	other.Data.Site = "Crystal Bay"
+	mlog.Insert(other)
+	site := mlog.Sites().Find(edited.Data.SiteID)
+	site.Name = "Manta Bay"
+	change = mlog.Begin(account, "Site update")
+	if len(change.before) != 0 {
+		t.Errorf("Begin: got %d records cloned, want none until dives are touched", len(change.before))
+	}
+	mlog.UpdateSite(site)
+	if got := change.Commit(); got != 1 || len(change.before) != 1 {
+		t.Errorf("Commit after UpdateSite: got %d revisions of %d touched dives, want only the dive at the site", got, len(change.before))
+	}
+	change = mlog.Begin(account, "Created and deleted")
+	mlog.Insert(NewDive(datetime("2024-05-03T10:00")))
+	mlog.Delete("2024-05-03T10-00")
+	if got := change.Commit(); got != 0 {
+		t.Errorf("Commit after Insert and Delete: got %d revisions, want 0", got)
+	}
+
+	history := mlog.History(edited.ID())
+	if len(history) != 3 {
+		t.Fatalf("History: got %d revisions, want 3", len(history))
+	}
+	if history[0].Action != "Site update" || history[0].Author != "andrija" || history[2].Before != nil {
+		t.Errorf("History: got %q by %q, want the latest revision first", history[0].Action, history[0].Author)
+	}
+	diff := mlog.Diff(history[1])
+	fields := make([]string, 0)
+	for _, c := range diff.Changes {
+		fields = append(fields, c.Field)
+	}
+	if !slices.Equal(fields, []string{"Duration", "Note"}) {
+		t.Errorf("Diff: got changes of %v, want [Duration Note]", fields)
+	}
+
+	// Revisions are persisted, and a deleted dive can be restored from its history.
+	change = mlog.Begin(account, "Deleted")
+	mlog.Delete(edited.ID())
+	change.Commit()
+	mlog.dir = t.TempDir()
+	if err := mlog.saveHistory(); err != nil {
+		t.Fatalf("saveHistory: %v", err)
+	}
+	loaded := NewDiveLog()
+	loaded.dir = mlog.dir
+	if err := loaded.loadHistory(); err != nil {
+		t.Fatalf("loadHistory: %v", err)
+	}
+	loaded.dir = "" // in memory only from here on
+
+	// The audit log shows who changed which fields of a dive, but not the records.
+	entries, err := (&LogRegistry{logs: map[string]*DiveLog{account.Username: loaded}}).Audit([]*Account{account})
+	if err != nil || len(entries) != 4 || entries[0].Action != "Deleted" || entries[0].DiveID != edited.ID() {
+		t.Fatalf("Audit: got %d entries, %v, want 4 with the deletion first", len(entries), err)
+	}
+	if got := entries[2].FormattedFields(); got != "Duration, Note" {
+		t.Errorf("Audit: got changes of %q, want Duration, Note", got)
+	}
+	if len(loaded.history) != 4 || !loaded.history[3].Deleted() {
+		t.Fatalf("loadHistory: got %d revisions, want 4 with the last one deleting the dive", len(loaded.history))
+	}
+	if _, err := loaded.Revert(loaded.history[3]); err == nil {
+		t.Errorf("Revert to a deletion: expected an error")
+	}
+	overlapping := NewDive(datetime("2024-05-01T10:30"))
+	overlapping.Data.Site = "Crystal Bay"
+	loaded.Insert(overlapping)
+	if _, err := loaded.Revert(loaded.history[1]); err == nil || len(loaded.All()) != 1 {
+		t.Errorf("Revert overlapping a dive logged since: got %v, want an error", err)
+	}
+	loaded.Delete(overlapping.ID())
+	restored, err := loaded.Revert(loaded.history[1])
+	if err != nil {
+		t.Fatalf("Revert: %v", err)
+	}
+	if restored != loaded.Find(edited.ID()) || restored.Data.Note != edited.Data.Note || restored.Data.Site != "Manta Point" {
+		t.Errorf("Revert: got %+v, want the dive as edited", restored)
+	}
+
+	// Revisions stay with the dive when it's moved in time, even once it's loaded under the ID of the new time.
+	moved := NewDive(datetime("2024-05-01T11:00"))
+	moved.Data.Site = "Manta Point"
+	change = loaded.Begin(account, "Edited")
+	loaded.Replace(restored, moved)
+	change.Commit()
+	reloaded := NewDiveLog()
+	if err := reloaded.Reconstruct([]*DiveRecord{moved.Data.Clone()}); err != nil {
+		t.Fatalf("Reconstruct: %v", err)
+	}
+	reloaded.history = loaded.history
+	history = reloaded.History("2024-05-01T11-00")
+	if len(history) != 5 {
+		t.Fatalf("History after reload: got %d revisions, want 5", len(history))
+	}
+	if reverted, err := reloaded.Revert(history[2]); err != nil || len(reloaded.All()) != 1 || reverted.Data.Note != edited.Data.Note {
+		t.Errorf("Revert after reload: got %d dives, %v, want the dive reverted in place", len(reloaded.All()), err)
+	}
+}
+
+func TestTrash(t *testing.T) {
+	mlog := NewDiveLog()
+	for _, dt := range []string{"2024-05-01T10:00", "2024-05-02T10:00", "2024-05-03T10:00"} {
+		dive := NewDive(datetime(dt))
+		dive.Data.Site = "Manta Point"
+		mlog.Insert(dive)
+	}
+	mlog.IsRenumbered()
+
+	now := time.Now() // trash is purged on load, by the current time
+	if !mlog.Trash("2024-05-02T10-00", now) || mlog.Trash("2024-05-02T10-00", now) {
+		t.Fatalf("Trash: expected the dive to be moved to the trash once")
+	}
+	if got := mlog.Find("2024-05-03T10-00").Num(); got != 2 || !mlog.IsRenumbered() {
+		t.Errorf("Trash: got #%d for the last dive, want #2 and a renumbered log", got)
+	}
+	if len(mlog.Trashed()) != 1 || mlog.Find("2024-05-02T10-00") != nil {
+		t.Errorf("Trashed: got %d dives, want the deleted dive only in the trash", len(mlog.Trashed()))
+	}
+
+	// Trash is persisted with the log.
+	mlog.dir = t.TempDir()
+	if err := mlog.save(); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	loaded := NewDiveLog()
+	loaded.dir = mlog.dir
+	if err := loaded.load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	mlog.dir, loaded.dir = "", ""
+	if td := loaded.FindTrashed("2024-05-02T10-00"); td == nil || td.Dive().Site() != "Manta Point" {
+		t.Fatalf("load: expected the trashed dive to be reconstructed")
+	}
+
+	// A restored dive is numbered again by its date and time.
+	dive, err := loaded.Restore("2024-05-02T10-00")
+	if err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if dive.Num() != 2 || loaded.Find("2024-05-03T10-00").Num() != 3 || !loaded.IsRenumbered() {
+		t.Errorf("Restore: got #%d, want #2 with the last dive renumbered to #3", dive.Num())
+	}
+	if _, err := loaded.Restore("2024-05-02T10-00"); err == nil {
+		t.Errorf("Restore: expected an error for a dive that is not in the trash")
+	}
+
+	// A dive in the trash can't be reverted to a revision, as it would then be both in the log and in the trash.
+	change := loaded.Begin(nil, "Tagged")
+	loaded.AddTag("wreck", DiveList{dive})
+	change.Commit()
+	change = loaded.Begin(nil, "Moved to trash")
+	loaded.Trash(dive.ID(), now)
+	change.Commit()
+	if _, err := loaded.Revert(loaded.History(dive.ID())[1]); err == nil || loaded.Find(dive.ID()) != nil {
+		t.Errorf("Revert of a dive in the trash: got %v, want an error and the dive only in the trash", err)
+	}
+
+	// Dives are purged after the retention period.
+	mlog.Trash("2024-05-01T10-00", now.Add(TrashRetention/2))
+	mlog.Trash("2024-05-03T10-00", now.Add(TrashRetention+time.Hour))
+	if got := mlog.Trashed(); len(got) != 2 || got[0].ID != "2024-05-03T10-00" || mlog.FindTrashed("2024-05-02T10-00") != nil {
+		t.Errorf("Trash: got %d dives in the trash, want 2 after the first one was purged", len(got))
+	}
+	if !mlog.Purge("2024-05-01T10-00") || len(mlog.Trashed()) != 1 {
+		t.Errorf("Purge: expected the dive to be deleted from the trash")
+	}
+}
+
+func TestBatch(t *testing.T) {
+	var (
+		mlog    = NewDiveLog()
+		account = &Account{Username: "andrija"}
+		now     = time.Now()
+	)
+	for _, dt := range []string{"2024-05-01T10:00", "2024-05-02T10:00", "2024-05-03T10:00", "2024-05-04T10:00"} {
+		dive := NewDive(datetime(dt))
+		dive.Data.Site = "Manta Point"
+		mlog.Insert(dive)
+	}
+	target := mlog.Sites().Add(NewSite("Crystal Bay", "Nusa Penida"))
+	selected := findDives(mlog, []string{"2024-05-01T10-00", "2024-05-03T10-00"})
+
+	// An invalid batch changes nothing.
+	change := mlog.Begin(account, "Site set")
+	if err := mlog.ApplyBatch(&Batch{Action: BatchSite, Value: "unknown", Dives: selected}, now); err == nil {
+		t.Errorf("ApplyBatch: expected an error for an unknown site")
+	}
+	if got := change.Commit(); got != 0 || change.modified {
+		t.Errorf("Commit of an invalid batch: got %d revisions, want none", got)
+	}
+
+	// A batch is a single change: the log is persisted once, when it is committed.
+	change = mlog.Begin(account, "Site set")
+	if err := mlog.ApplyBatch(&Batch{Action: BatchSite, Value: target.ID, Dives: selected}, now); err != nil {
+		t.Fatalf("ApplyBatch: %v", err)
+	}
+	if mlog.pending != change || !change.modified {
+		t.Errorf("ApplyBatch: expected the log to be persisted by the change")
+	}
+	if got := change.Commit(); got != 2 || mlog.pending != nil {
+		t.Errorf("Commit: got %d revisions, want 2", got)
+	}
+	if got := mlog.Find("2024-05-03T10-00").Site(); got != "Crystal Bay (Nusa Penida)" {
+		t.Errorf("ApplyBatch: got site %q, want Crystal Bay (Nusa Penida)", got)
+	}
+
+	mlog.IsRenumbered()
+	change = mlog.Begin(account, "Moved to trash")
+	if err := mlog.ApplyBatch(&Batch{Action: BatchDelete, Dives: selected}, now); err != nil {
+		t.Fatalf("ApplyBatch: %v", err)
+	}
+	change.Commit()
+	if len(mlog.All()) != 2 || len(mlog.Trashed()) != 2 || mlog.Find("2024-05-04T10-00").Num() != 2 {
+		t.Errorf("ApplyBatch: got %d dives and %d in the trash, want 2 and 2", len(mlog.All()), len(mlog.Trashed()))
+	}
+	if !mlog.IsRenumbered() || mlog.IsRenumbered() {
+		t.Errorf("IsRenumbered: expected a single notification after the batch")
+	}
+
+	remaining := mlog.All()
+	for _, b := range []*Batch{
+		{Action: BatchDeco, Value: "true", Dives: remaining},
+		{Action: BatchDeco, Value: "maybe", Dives: remaining},
+		{Action: BatchTrip, Value: "unknown", Dives: remaining},
+		{Action: "rename", Dives: remaining},
+		{Action: BatchDeco, Value: "false"},
+	} {
+		err := mlog.ApplyBatch(b, now)
+		if wantErr := !(b.Action == BatchDeco && b.Value == "true"); (err != nil) != wantErr {
+			t.Errorf("ApplyBatch(%s %q): got error %v", b.Action, b.Value, err)
+		}
+	}
+	if !remaining[0].Data.DecoDive || !remaining[1].Data.DecoDive {
+		t.Errorf("ApplyBatch: expected the deco. flag to be set on all dives")
+	}
+}
+
+func TestTags(t *testing.T) {
+	tags, err := ParseTags(" Wreck, night  dive,wreck,, photo ")
+	if err != nil || !slices.Equal(tags, []string{"wreck", "night dive", "photo"}) {
+		t.Errorf("ParseTags: got %q, %v", tags, err)
+	}
+	for _, input := range []string{"wreck, <b>", "-night", strings.Repeat("x", MaxTagLength+1)} {
+		if _, err := ParseTags(input); !errors.Is(err, ErrInvalidTag) {
+			t.Errorf("ParseTags(%q): got %v, want %v", input, err, ErrInvalidTag)
+		}
+	}
+
+	mlog := NewDiveLog()
+	for i, tags := range [][]string{{"wreck", "night"}, {"wreck"}, {"wreck", "training"}, nil} {
+		dive := NewDive(datetime(fmt.Sprintf("2024-05-0%dT10:00", i+1)))
+		dive.Data.Site = "Manta Point"
+		dive.Data.Tags = tags
+		mlog.Insert(dive)
+	}
+	counts := mlog.Tags()
+	if len(counts) != 3 || counts[2].Name != "wreck" || counts[2].Dives != 3 || counts[2].Size != TagCloudSizes || counts[0].Size != 1 {
+		t.Errorf("Tags: got %d tags, want night, training and wreck, sized by the number of dives", len(counts))
+	}
+	if got := mlog.CompleteTags("Wreck, t"); !slices.Equal(got, []string{"Wreck, training"}) {
+		t.Errorf("CompleteTags: got %q", got)
+	}
+	if got := mlog.CompleteTags("wreck, "); !slices.Equal(got, []string{"wreck, night", "wreck, training"}) {
+		t.Errorf("CompleteTags: got %q, want tags other than the ones entered", got)
+	}
+
+	query, _ := ParseDiveQuery(url.Values{TagQueryTag: {"Wreck"}})
+	if got := query.Run(mlog).Total; got != 3 {
+		t.Errorf("Run with tag filter: got %d dives, want 3", got)
+	}
+	if got := len(mlog.Search("training")); got != 1 {
+		t.Errorf("Search for a tag: got %d hits, want 1", got)
+	}
+
+	if err := mlog.ApplyBatch(&Batch{Action: BatchTag, Value: " Night ", Dives: mlog.All()}, time.Now()); err != nil {
+		t.Fatalf("ApplyBatch: %v", err)
+	}
+	if got := mlog.Find("2024-05-01T10-00").Data.Tags; !slices.Equal(got, []string{"wreck", "night"}) {
+		t.Errorf("ApplyBatch: got tags %q, want a tag added once", got)
+	}
+	if got := mlog.Tags()[0]; got.Name != "night" || got.Dives != 4 {
+		t.Errorf("Tags after ApplyBatch: got %s on %d dives, want night on 4", got.Name, got.Dives)
+	}
+}
+
+func TestCustomFields(t *testing.T) {
+	t.Cleanup(func() { CustomFields = NewCustomFieldSet() })
+
+	for _, tc := range []struct {
+		form   string
+		errTag string
+	}{
+		{"field_name=Camera+ISO&field_type=number&field_min=50&field_max=25600", ""},
+		{"field_name=Scooter&field_type=bool&field_unit=ignored", ""},
+		{"field_name=Transect&field_type=enum&field_options=A,+B,+A", ""},
+		{"field_name=Battery&field_type=number&field_min=100&field_max=0", FieldMaxTag},
+		{"field_name=Transect&field_type=enum&field_options=A", FieldOptionsTag},
+		{"field_name=+&field_type=text", FieldNameTag},
+		{"field_name=Camera&field_type=date", FieldTypeTag},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/fields/new", strings.NewReader(tc.form))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		errorMap := make(map[string]string)
+		field, ok := parseCustomFieldFromRequest(r, errorMap)
+		if tc.errTag != "" {
+			if ok || errorMap[tc.errTag] == "" {
+				t.Errorf("parseCustomFieldFromRequest(%q): got %v, want an error for %s", tc.form, errorMap, tc.errTag)
+			}
+			continue
+		}
+		if !ok {
+			t.Fatalf("parseCustomFieldFromRequest(%q): %v", tc.form, errorMap)
+		}
+		if err := CustomFields.Add(field); err != nil {
+			t.Fatalf("Add(%s): %v", field.Name, err)
+		}
+	}
+	if err := CustomFields.Add(&CustomField{Name: "camera iso", Type: FieldText}); !errors.Is(err, ErrFieldExists) {
+		t.Errorf("Add with a taken name: got %v, want %v", err, ErrFieldExists)
+	}
+	iso, scooter, transect := CustomFields.Find("camera-iso"), CustomFields.Find("scooter"), CustomFields.Find("transect")
+	if iso == nil || scooter == nil || transect == nil || scooter.Unit != "" || !slices.Equal(transect.Options, []string{"A", "B"}) {
+		t.Fatalf("Add: got fields %v", CustomFields.All())
+	}
+
+	for _, tc := range []struct {
+		field *CustomField
+		input string
+		want  any
+		ok    bool
+	}{
+		{iso, " 400 ", 400.0, true},
+		{iso, "25", nil, false},
+		{iso, "fast", nil, false},
+		{iso, "", nil, true},
+		{scooter, "true", true, true},
+		{scooter, "maybe", nil, false},
+		{transect, "B", "B", true},
+		{transect, "C", nil, false},
+	} {
+		value, errMsg := validateCustomFieldInput(tc.field, tc.input)
+		if value != tc.want || (errMsg == "") != tc.ok {
+			t.Errorf("validateCustomFieldInput(%s, %q): got %v, %q", tc.field.ID, tc.input, value, errMsg)
+		}
+	}
+
+	if err := saveCustomFields(); err != nil {
+		t.Fatalf("saveCustomFields: %v", err)
+	}
+	CustomFields = NewCustomFieldSet()
+	if err := loadCustomFields(); err != nil || len(CustomFields.All()) != 3 || *CustomFields.Find("camera-iso").Max != 25600 {
+		t.Fatalf("loadCustomFields: got %d fields, %v", len(CustomFields.All()), err)
+	}
+	iso = CustomFields.Find("camera-iso")
+
+	form := url.Values{
+		DateTag: {"2024-05-01"}, TimeInTag: {"10:00"}, SiteTag: {"Manta Point"}, DurationTag: {"45"},
+		iso.Tag(): {"400"}, "custom_scooter": {"false"}, "custom_transect": {""},
+	}
+	r := httptest.NewRequest(http.MethodPost, "/dives/new", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	dive, ok := parseDiveFromRequest(r, make(map[string]string))
+	if !ok || !maps.Equal(dive.Data.Custom, map[string]any{"camera-iso": 400.0, "scooter": false}) {
+		t.Fatalf("parseDiveFromRequest: got %v", dive.Data.Custom)
+	}
+	if got := dive.Data.FormattedCustomValues(); got != "Camera ISO: 400, Scooter: No" {
+		t.Errorf("FormattedCustomValues: got %q", got)
+	}
+	clone := dive.Data.Clone()
+	clone.Custom["scooter"] = true
+	if dive.Data.Custom["scooter"] != false {
+		t.Error("Clone: custom values are shared with the original")
+	}
+
+	mlog := NewDiveLog()
+	mlog.Insert(dive)
+	other := NewDive(datetime("2024-05-02T10:00"))
+	other.Data.Site = "Manta Point"
+	other.Data.Custom = map[string]any{"camera-iso": 800.0}
+	mlog.Insert(other)
+	for _, tc := range []struct {
+		field, value string
+		want         int
+	}{
+		{"camera-iso", "", 2},
+		{"camera-iso", "800", 1},
+		{"scooter", "no", 0}, // not a valid flag
+		{"scooter", "false", 1},
+		{"transect", "", 0},
+		{"removed", "", 2}, // unknown fields don't filter
+	} {
+		query, _ := ParseDiveQuery(url.Values{FieldQueryTag: {tc.field}, FieldValueQueryTag: {tc.value}})
+		if got := query.Run(mlog).Total; got != tc.want {
+			t.Errorf("Run with field filter %s=%q: got %d dives, want %d", tc.field, tc.value, got, tc.want)
+		}
+	}
+}
+
+// exifJPEG returns a JPEG image with EXIF metadata holding the time it was taken (DateTimeOriginal).
+func exifJPEG(t *testing.T, width int, height int, taken string) []byte {
+	var img bytes.Buffer
+	if err := jpeg.Encode(&img, image.NewRGBA(image.Rect(0, 0, width, height)), nil); err != nil {
+		t.Fatal(err)
+	}
+	tiff := []byte("II*\x00\x08\x00\x00\x00")
+	tiff = binary.LittleEndian.AppendUint16(tiff, 1) // IFD0 at 8, with a pointer to the EXIF IFD at 26
+	tiff = append(tiff, 0x69, 0x87, exifTypeLong, 0, 1, 0, 0, 0, 26, 0, 0, 0, 0, 0, 0, 0)
+	tiff = binary.LittleEndian.AppendUint16(tiff, 1) // EXIF IFD, with the time at 44
+	tiff = append(tiff, 0x03, 0x90, exifTypeASCII, 0, 20, 0, 0, 0, 44, 0, 0, 0, 0, 0, 0, 0)
+	tiff = append(tiff, taken+"\x00"...)
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := binary.BigEndian.AppendUint16([]byte{0xFF, 0xE1}, uint16(len(segment)+2))
+	return slices.Concat(img.Bytes()[:2], app1, segment, img.Bytes()[2:])
+}
+
+func TestAttachments(t *testing.T) {
+	photo := exifJPEG(t, 640, 480, "2024:05:01 10:20:00")
+	if taken, err := ExifTime(bytes.NewReader(photo)); err != nil || !taken.Equal(datetime("2024-05-01T10:20")) {
+		t.Errorf("ExifTime: got %v, %v", taken, err)
+	}
+	if _, err := ExifTime(bytes.NewReader(exifJPEG(t, 8, 8, "not a time")[:40])); !errors.Is(err, ErrNoExifTime) {
+		t.Errorf("ExifTime of a truncated image: got %v, want %v", err, ErrNoExifTime)
+	}
+
+	mlog := NewDiveLog()
+	mlog.dir = t.TempDir()
+	// The log is saved in the background once unlocked, into the directory removed after the test.
+	t.Cleanup(func() { mlog.Lock(); mlog.dir = ""; mlog.Unlock() })
+
+	// Files uploaded to a dive that doesn't exist are not kept.
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, _ := form.CreateFormFile(AttachmentTag, "manta.jpg")
+	part.Write(photo)
+	form.Close()
+	r := httptest.NewRequest(http.MethodPost, "/dives/2024-05-01T09-00/attachments", &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	r.SetPathValue(IDTag, "2024-05-01T09-00")
+	w := httptest.NewRecorder()
+	diveAttachmentUploadHandler(w, r.WithContext(context.WithValue(r.Context(), logKey{}, mlog)))
+	if entries, _ := os.ReadDir(mlog.AttachmentPath("")); w.Code != http.StatusNotFound || len(entries) != 0 {
+		t.Errorf("upload to a missing dive: got %d, %d files stored, want %d and none", w.Code, len(entries), http.StatusNotFound)
+	}
+
+	mlog.Lock()
+	defer mlog.Unlock()
+	first, second := NewDive(datetime("2024-05-01T10:00")), NewDive(datetime("2024-05-01T12:00"))
+	for _, dive := range []*Dive{first, second} {
+		dive.Data.Site = "Manta Point"
+		dive.Data.Duration = Duration{Duration: 45 * time.Minute}
+		mlog.Insert(dive)
+	}
+
+	attachment, err := mlog.StoreAttachment(`C:\Photos\manta.jpg`, bytes.NewReader(photo))
+	if err != nil {
+		t.Fatalf("StoreAttachment: %v", err)
+	}
+	if attachment.Name != "manta.jpg" || attachment.Type != "image/jpeg" || attachment.Size != int64(len(photo)) ||
+		attachment.TakenAt != "2024-05-01T10:20" || !attachment.Thumbnail || len(attachment.Hash) != 64 {
+		t.Errorf("StoreAttachment: got %+v", attachment)
+	}
+	if stored, err := os.ReadFile(mlog.AttachmentPath(attachment.Hash)); err != nil || !bytes.Equal(stored, photo) {
+		t.Errorf("StoreAttachment: stored file differs, %v", err)
+	}
+	if !mlog.AttachmentStored(attachment) {
+		t.Error("AttachmentStored: got false for a stored photo")
+	}
+	if file, err := os.Open(mlog.ThumbnailPath(attachment.Hash)); err != nil {
+		t.Errorf("StoreAttachment: no thumbnail, %v", err)
+	} else {
+		config, err := jpeg.DecodeConfig(file)
+		file.Close()
+		if err != nil || config.Width != ThumbnailSize || config.Height != ThumbnailSize*3/4 {
+			t.Errorf("thumbnail: got %dx%d, %v", config.Width, config.Height, err)
+		}
+	}
+	if again, err := mlog.StoreAttachment("copy.jpg", bytes.NewReader(photo)); err != nil || again.Hash != attachment.Hash {
+		t.Errorf("StoreAttachment of the same content: got %v, %v", again, err)
+	}
+	note, err := mlog.StoreAttachment("briefing.txt", strings.NewReader("Current from the north."))
+	if err != nil || note.Type != "text/plain" || note.Thumbnail || note.Inline() {
+		t.Errorf("StoreAttachment of a document: got %+v, %v", note, err)
+	}
+	if _, err := mlog.StoreAttachment("empty.txt", strings.NewReader("")); err == nil {
+		t.Error("StoreAttachment of an empty file: got no error")
+	}
+
+	mlog.Attach(second, attachment)
+	mlog.Attach(second, attachment)
+	mlog.Attach(second, note)
+	if len(second.Data.Attachments) != 2 {
+		t.Fatalf("Attach: got %d attachments, want 2", len(second.Data.Attachments))
+	}
+	if clone := second.Data.Clone(); clone.Attachments[0] == second.Data.Attachments[0] {
+		t.Error("Clone: attachments are shared with the original")
+	}
+	if got := mlog.SuggestDive(attachment); got != first {
+		t.Errorf("SuggestDive: got %v, want the dive in progress when the photo was taken", got)
+	}
+	if got := mlog.SuggestDive(note); got != nil {
+		t.Errorf("SuggestDive of a document: got %v, want nil", got)
+	}
+	if !mlog.MoveAttachment(second, first, attachment.Hash) || first.Data.Attachment(attachment.Hash) == nil || second.Data.Attachment(attachment.Hash) != nil {
+		t.Error("MoveAttachment: the photo was not moved")
+	}
+
+	// Files are removed when no dive, including those in the trash, has them attached.
+	mlog.Attach(second, attachment)
+	mlog.Detach(first, attachment.Hash)
+	mlog.Trash(second.ID(), time.Now())
+	if !fileExists(mlog.AttachmentPath(attachment.Hash)) {
+		t.Error("Detach: removed a file attached to a dive in the trash")
+	}
+	mlog.Purge(second.ID())
+	for _, path := range []string{mlog.AttachmentPath(attachment.Hash), mlog.ThumbnailPath(attachment.Hash), mlog.AttachmentPath(note.Hash)} {
+		if fileExists(path) {
+			t.Errorf("Purge: %s was not removed", filepath.Base(path))
+		}
+	}
+	if mlog.AttachmentStored(attachment) {
+		t.Error("AttachmentStored: got true for a removed file")
+	}
+}
+
+func TestGeo(t *testing.T) {
+	for input, want := range map[string]LatLon{
+		"-8.7953, 115.527":             {-8.7953, 115.527},
+		"-8.7953 115.527":              {-8.7953, 115.527},
+		"8.7953 S, 115.527 E":          {-8.7953, 115.527},
+		"E 115.527 S 8.7953":           {-8.7953, 115.527},
+		"8° 47.718' S, 115° 31.62' E":  {-8.7953, 115.527},
+		"8°47'43.08\"S 115°31'37.2\"E": {-8.7953, 115.527},
+		"geo:-8.7953,115.527,-12;u=10": {-8.7953, 115.527},
+		"44°47′N 20°25′E":              {44 + 47.0/60, 20 + 25.0/60},
+	} {
+		got, err := ParseLatLon(input)
+		if err != nil || math.Abs(got.Lat-want.Lat) > 1e-9 || math.Abs(got.Lon-want.Lon) > 1e-9 {
+			t.Errorf("ParseLatLon(%q): got %v, %v, want %v", input, got, err, want)
+		}
+	}
+	for _, input := range []string{"", "-8.7953", "8.7953 N, 115.527 S", "8° 60' S, 115° E", "8.5° 30' S, 115° E", "a, b", "1, 2, 3"} {
+		if _, err := ParseLatLon(input); !errors.Is(err, ErrInvalidPosition) {
+			t.Errorf("ParseLatLon(%q): got %v, want %v", input, err, ErrInvalidPosition)
+		}
+	}
+	if _, errMsg := validatePositionInput("91, 0"); errMsg == "" {
+		t.Error("validatePositionInput: latitude out of range accepted")
+	}
+
+	mlog := NewDiveLog()
+	for i, site := range []string{"Manta Point", "Crystal Bay", "Somewhere"} {
+		dive := NewDive(datetime(fmt.Sprintf("2024-05-0%dT10:00", i+1)))
+		dive.Data.Site = site
+		dive.Data.Tags = []string{"bali"}
+		mlog.Insert(dive)
+	}
+	mlog.Find("2024-05-01T10-00").Data.Entry = &LatLon{-8.795, 115.527}
+	mlog.Find("2024-05-01T10-00").Data.Exit = &LatLon{-8.796, 115.528}
+	mlog.Sites().Find(mlog.Find("2024-05-02T10-00").Data.SiteID).Position = &LatLon{-8.715, 115.46}
+	mlog.Find("2024-05-03T10-00").Data.Tags = nil
+	if got := mlog.Position(mlog.Find("2024-05-02T10-00")); got == nil || got.Lat != -8.715 {
+		t.Errorf("Position: got %v, want the position of the site", got)
+	}
+
+	var collection GeoJSONFeatureCollection
+	var buf bytes.Buffer
+	if err := mlog.WriteGeoJSON(&buf, mlog.All()); err != nil {
+		t.Fatalf("WriteGeoJSON: %v", err)
+	}
+	if err := json.Unmarshal(buf.Bytes(), &collection); err != nil || len(collection.Features) != 2 {
+		t.Fatalf("WriteGeoJSON: got %d features, %v, want 2 (dives without a position left out)", len(collection.Features), err)
+	}
+	if f := collection.Features[0]; f.Geometry.Type != "LineString" || f.Properties.Source != "dive" {
+		t.Errorf("WriteGeoJSON: got %s from %s, want a line from the entry to the exit", f.Geometry.Type, f.Properties.Source)
+	}
+	if f := collection.Features[1]; f.Geometry.Type != "Point" || f.Properties.Source != "site" {
+		t.Errorf("WriteGeoJSON: got %s from %s, want the point of the site", f.Geometry.Type, f.Properties.Source)
+	}
+
+	buf.Reset()
+	query, _ := ParseDiveQuery(url.Values{TagQueryTag: {"bali"}, LimitQueryTag: {"1"}})
+	if err := mlog.WriteKML(&buf, query.All(mlog)); err != nil {
+		t.Fatalf("WriteKML: %v", err)
+	}
+	kml := buf.String()
+	if strings.Count(kml, "<Placemark") != 2 || !strings.Contains(kml, "<coordinates>115.527,-8.795 115.528,-8.796</coordinates>") {
+		t.Errorf("WriteKML with a filter: got %s", kml)
+	}
+
+	w := httptest.NewRecorder()
+	divesGeoJSONHandler(w, httptest.NewRequest(http.MethodGet, "/dives.geojson?cursor=forged", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("divesGeoJSONHandler with an invalid query: got %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestMap(t *testing.T) {
+	if i := slices.IndexFunc(Coastline, func(shape *MapShape) bool { return shape.Name == "Bali" }); i < 0 {
+		t.Error("Coastline: Bali is missing")
+	}
+	for _, data := range []string{"sea Adriatic:\n    13,45 14,45 14,44\n", "land Bali:\n    115.2,-8.1 x\n", "    1,2 3,4 5,6\n", "land Rock:\n    1,2 3,4\n"} {
+		if _, err := ParseShapes(data); err == nil {
+			t.Errorf("ParseShapes(%q): invalid data accepted", data)
+		}
+	}
+
+	m := NewMap(LatLon{Lat: 44.8, Lon: 20.45}, 6)
+	if x, y := m.Project(LatLon{Lat: 44.8, Lon: 20.45}); math.Abs(x-MapWidth/2) > 1e-6 || math.Abs(y-MapHeight/2) > 1e-6 {
+		t.Errorf("Project: got %.2f, %.2f, want the center of the map", x, y)
+	}
+	if p := m.Unproject(100, 100); math.Abs(p.Lat-m.Pan(0, 0).Unproject(100, 100).Lat) > 1e-9 {
+		t.Errorf("Pan: got a different view at no distance")
+	}
+	if got := NewMap(LatLon{Lat: 0, Lon: 170}, 0).Center.Lon; math.Abs(got) > 1e-9 {
+		t.Errorf("NewMap: got longitude %v at zoom 0, want the whole world in view", got)
+	}
+	if got := m.ZoomIn().ZoomOut().URL(); got != m.URL() {
+		t.Errorf("ZoomIn, ZoomOut: got %s, want %s", got, m.URL())
+	}
+	if got := ParseMap(url.Values{MapLatTag: {"44.8"}, MapLonTag: {"20.45"}, MapZoomTag: {"99"}}); got == nil || got.Zoom != MaxMapZoom {
+		t.Errorf("ParseMap: got %v, want the zoom level limited", got)
+	}
+	if got := ParseMap(url.Values{MapLatTag: {"NaN"}, MapLonTag: {"20.45"}, MapZoomTag: {"1"}}); got != nil {
+		t.Errorf("ParseMap: got %v for an invalid latitude, want nil", got)
+	}
+
+	var (
+		sites = []*Site{
+			{ID: "crystal-bay", Name: "Crystal Bay", Position: &LatLon{Lat: -8.715, Lon: 115.46}},
+			{ID: "manta-point", Name: "Manta Point", Position: &LatLon{Lat: -8.795, Lon: 115.527}},
+			{ID: "ada-ciganlija", Name: "Ada Ciganlija", Position: &LatLon{Lat: 44.78, Lon: 20.41}},
+			{ID: "somewhere", Name: "Somewhere"},
+		}
+		dives = map[string]int{"crystal-bay": 1, "manta-point": 3, "ada-ciganlija": 2}
+	)
+	world := FitMap([]*LatLon{sites[0].Position, sites[1].Position, sites[2].Position})
+	world.Pin(sites, func(site *Site) int { return dives[site.ID] })
+	if len(world.Pins) != 2 || !world.Pins[0].Cluster() || world.Pins[0].Sites[0].ID != "manta-point" || world.Pins[0].Dives != 4 {
+		t.Fatalf("Pin: got %d pins, want Bali sites clustered around the one with most dives", len(world.Pins))
+	}
+	if len(world.Unplaced) != 1 || world.Unplaced[0].ID != "somewhere" {
+		t.Errorf("Pin: got %d unplaced sites, want 1", len(world.Unplaced))
+	}
+	bali := world.zoomTo(world.Pins[0])
+	bali.Pin(sites, func(site *Site) int { return dives[site.ID] })
+	if len(bali.Pins) != 2 || bali.Pins[0].Cluster() || bali.Pins[1].Cluster() {
+		t.Errorf("zoomTo: got %d pins at zoom %d, want the Bali sites apart", len(bali.Pins), bali.Zoom)
+	}
+
+	svg := string(world.SVG())
+	for _, want := range []string{`<title>Africa</title>`, `hx-get="` + html.EscapeString(string(bali.URL())) + `"`, `<a href="/sites/ada-ciganlija">`} {
+		if !strings.Contains(svg, want) {
+			t.Errorf("SVG: missing %s", want)
+		}
+	}
+	if strings.Contains(string(bali.SVG()), "<title>Africa</title>") {
+		t.Error("SVG: got shapes out of view")
+	}
+}
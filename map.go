@@ -0,0 +1,330 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"html"
+	"html/template"
+	"math"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// The map of dive sites is rendered on the server as SVG, from an embedded dataset of coastlines, so it works without
+// internet access, unlike maps made of tiles. It uses the Web Mercator projection: at zoom level 0 the whole world
+// fits the width of the map, and each level doubles the scale.
+
+const (
+	// Dimensions of the map in SVG user units; the map scales to the width of the page.
+	MapWidth  = 960
+	MapHeight = 540
+
+	MaxMapZoom = 10
+
+	// MapClusterRadius is how close pins must be, in SVG user units, to be clustered. Pins are not clustered at
+	// MaxMapZoom, as the map can't be zoomed in to tell them apart.
+	MapClusterRadius = 16
+
+	MapLatTag  = "lat"
+	MapLonTag  = "lon"
+	MapZoomTag = "zoom"
+
+	maxMercatorLat = 85.05112878 // latitude at which the projected world is a square
+)
+
+//go:embed mapdata/coastline.txt
+var coastlineData string
+
+// Coastline holds the shapes of the embedded coastline dataset.
+var Coastline = mustParseShapes(coastlineData)
+
+// MapShape is the outline of land, or of a lake, on the map.
+type MapShape struct {
+	Name     string
+	Lake     bool
+	Outline  []LatLon
+	min, max LatLon // bounding box
+}
+
+// ParseShapes parses shapes in the format of the coastline dataset (see mapdata/coastline.txt).
+func ParseShapes(data string) ([]*MapShape, error) {
+	var (
+		shapes []*MapShape
+		shape  *MapShape
+	)
+	for i, line := range strings.Split(data, "\n") {
+		switch {
+		case strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#"):
+			continue
+		case line[0] == ' ' || line[0] == '\t':
+			if shape == nil {
+				return nil, fmt.Errorf("line %d: outline without a shape", i+1)
+			}
+			for _, pair := range strings.Fields(line) {
+				lonStr, latStr, found := strings.Cut(pair, ",")
+				lon, lonErr := strconv.ParseFloat(lonStr, 64)
+				lat, latErr := strconv.ParseFloat(latStr, 64)
+				if !found || lonErr != nil || latErr != nil || lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+					return nil, fmt.Errorf("line %d: invalid position %q", i+1, pair)
+				}
+				shape.Outline = append(shape.Outline, LatLon{Lat: lat, Lon: lon})
+			}
+		default:
+			header, found := strings.CutSuffix(strings.TrimSpace(line), ":")
+			kind, name, _ := strings.Cut(header, " ")
+			if !found || name == "" || (kind != "land" && kind != "lake") {
+				return nil, fmt.Errorf("line %d: invalid shape %q", i+1, line)
+			}
+			shape = &MapShape{Name: name, Lake: kind == "lake"}
+			shapes = append(shapes, shape)
+		}
+	}
+
+	for _, shape := range shapes {
+		if len(shape.Outline) < 3 {
+			return nil, fmt.Errorf("shape %q has an outline of less than 3 positions", shape.Name)
+		}
+		shape.min, shape.max = shape.Outline[0], shape.Outline[0]
+		for _, p := range shape.Outline {
+			shape.min = LatLon{Lat: min(shape.min.Lat, p.Lat), Lon: min(shape.min.Lon, p.Lon)}
+			shape.max = LatLon{Lat: max(shape.max.Lat, p.Lat), Lon: max(shape.max.Lon, p.Lon)}
+		}
+	}
+	return shapes, nil
+}
+
+func mustParseShapes(data string) []*MapShape {
+	shapes, err := ParseShapes(data)
+	if err != nil {
+		panic(fmt.Errorf("coastline dataset: %v", err))
+	}
+	return shapes
+}
+
+// Map is a view of the map of dive sites, with pins of the sites in view.
+type Map struct {
+	Center   LatLon
+	Zoom     int
+	Pins     []*MapPin
+	Unplaced []*Site // sites without a position
+
+	x0, y0 float64 // projected coordinates of the top left corner of the view
+}
+
+// MapPin marks a dive site on the map, or a cluster of sites close to each other at the zoom level of the map.
+type MapPin struct {
+	X, Y  float64 // in SVG user units
+	Sites []*Site // the first one has the most dives, and is where the pin is
+	Dives int
+}
+
+func (p *MapPin) Cluster() bool {
+	return len(p.Sites) > 1
+}
+
+// NewMap returns a view of the map centered at the position, as far as the edges of the world allow.
+func NewMap(center LatLon, zoom int) *Map {
+	m := &Map{Zoom: min(max(zoom, 0), MaxMapZoom)}
+	x, y := m.project(center)
+	size := m.worldSize()
+	m.x0 = min(max(x-MapWidth/2, 0), size-MapWidth)
+	m.y0 = min(max(y-MapHeight/2, 0), size-MapHeight)
+	m.Center = m.Unproject(MapWidth/2, MapHeight/2)
+	return m
+}
+
+// FitMap returns the view of the highest zoom level that shows all the positions, or of the whole world if there are
+// none.
+func FitMap(positions []*LatLon) *Map {
+	if len(positions) == 0 {
+		return NewMap(LatLon{Lat: 20}, 0)
+	}
+	nw, se := *positions[0], *positions[0]
+	for _, p := range positions {
+		nw = LatLon{Lat: max(nw.Lat, p.Lat), Lon: min(nw.Lon, p.Lon)}
+		se = LatLon{Lat: min(se.Lat, p.Lat), Lon: max(se.Lon, p.Lon)}
+	}
+	for zoom := MaxMapZoom; zoom > 0; zoom-- {
+		m := &Map{Zoom: zoom}
+		x1, y1 := m.project(nw)
+		x2, y2 := m.project(se)
+		if x2-x1 <= MapWidth-4*MapClusterRadius && y2-y1 <= MapHeight-4*MapClusterRadius {
+			return NewMap(m.unproject((x1+x2)/2, (y1+y2)/2), zoom)
+		}
+	}
+	return NewMap(LatLon{Lat: 20}, 0)
+}
+
+// ParseMap returns the view of the map given by the URL query, or nil if the query doesn't give a valid view.
+func ParseMap(values url.Values) *Map {
+	lat, latErr := strconv.ParseFloat(values.Get(MapLatTag), 64)
+	lon, lonErr := strconv.ParseFloat(values.Get(MapLonTag), 64)
+	zoom, zoomErr := strconv.Atoi(values.Get(MapZoomTag))
+	if latErr != nil || lonErr != nil || zoomErr != nil || !(lat >= -90 && lat <= 90) || !(lon >= -180 && lon <= 180) {
+		return nil
+	}
+	return NewMap(LatLon{Lat: lat, Lon: lon}, zoom)
+}
+
+// URL returns the URL of the map page with this view.
+func (m *Map) URL() template.URL {
+	return template.URL(fmt.Sprintf("/map?%s=%s&%s=%s&%s=%d",
+		MapLatTag, strconv.FormatFloat(m.Center.Lat, 'f', 5, 64),
+		MapLonTag, strconv.FormatFloat(m.Center.Lon, 'f', 5, 64),
+		MapZoomTag, m.Zoom))
+}
+
+// Pan returns the view moved by the given fractions of the width and the height of the map.
+func (m *Map) Pan(dx float64, dy float64) *Map {
+	return NewMap(m.Unproject(MapWidth/2+dx*MapWidth, MapHeight/2+dy*MapHeight), m.Zoom)
+}
+
+func (m *Map) ZoomIn() *Map {
+	return NewMap(m.Center, m.Zoom+1)
+}
+
+func (m *Map) ZoomOut() *Map {
+	return NewMap(m.Center, m.Zoom-1)
+}
+
+func (m *Map) CanZoomIn() bool {
+	return m.Zoom < MaxMapZoom
+}
+
+func (m *Map) worldSize() float64 {
+	return MapWidth * math.Exp2(float64(m.Zoom))
+}
+
+// project returns the coordinates of the position in the projected world at the zoom level of the map.
+func (m *Map) project(p LatLon) (x float64, y float64) {
+	lat := min(max(p.Lat, -maxMercatorLat), maxMercatorLat) * math.Pi / 180
+	x = (p.Lon + 180) / 360 * m.worldSize()
+	y = (1 - math.Log(math.Tan(lat)+1/math.Cos(lat))/math.Pi) / 2 * m.worldSize()
+	return
+}
+
+func (m *Map) unproject(x float64, y float64) LatLon {
+	return LatLon{
+		Lat: math.Atan(math.Sinh(math.Pi*(1-2*y/m.worldSize()))) * 180 / math.Pi,
+		Lon: x/m.worldSize()*360 - 180,
+	}
+}
+
+// Project returns the coordinates of the position on the map, in SVG user units.
+func (m *Map) Project(p LatLon) (x float64, y float64) {
+	x, y = m.project(p)
+	return x - m.x0, y - m.y0
+}
+
+// Unproject returns the position at the coordinates on the map, in SVG user units.
+func (m *Map) Unproject(x float64, y float64) LatLon {
+	return m.unproject(x+m.x0, y+m.y0)
+}
+
+// Pin adds pins of the sites in view, clustering sites close to each other around the ones with the most dives.
+// Sites without a position are kept aside, so they can be listed.
+func (m *Map) Pin(sites []*Site, dives func(*Site) int) {
+	counts := make(map[string]int, len(sites))
+	for _, site := range sites {
+		counts[site.ID] = dives(site)
+	}
+	sites = slices.Clone(sites)
+	slices.SortStableFunc(sites, func(a, b *Site) int { return counts[b.ID] - counts[a.ID] })
+
+	for _, site := range sites {
+		if site.Position == nil {
+			m.Unplaced = append(m.Unplaced, site)
+			continue
+		}
+		x, y := m.Project(*site.Position)
+		if x < -MapClusterRadius || x > MapWidth+MapClusterRadius || y < -MapClusterRadius || y > MapHeight+MapClusterRadius {
+			continue
+		}
+		i := -1
+		if m.Zoom < MaxMapZoom {
+			i = slices.IndexFunc(m.Pins, func(pin *MapPin) bool { return math.Hypot(pin.X-x, pin.Y-y) <= MapClusterRadius })
+		}
+		if i < 0 {
+			m.Pins = append(m.Pins, &MapPin{X: x, Y: y})
+			i = len(m.Pins) - 1
+		}
+		m.Pins[i].Sites = append(m.Pins[i].Sites, site)
+		m.Pins[i].Dives += counts[site.ID]
+	}
+}
+
+// zoomTo returns the view that shows sites of the cluster apart, or at least closer.
+func (m *Map) zoomTo(pin *MapPin) *Map {
+	positions := make([]*LatLon, 0, len(pin.Sites))
+	for _, site := range pin.Sites {
+		positions = append(positions, site.Position)
+	}
+	if view := FitMap(positions); view.Zoom > m.Zoom {
+		return view
+	}
+	return NewMap(m.Unproject(pin.X, pin.Y), m.Zoom+1)
+}
+
+// SVG renders the map as an inline SVG element. A pin links to the page of its site, which lists dives at the site,
+// while a cluster zooms the map in.
+func (m *Map) SVG() template.HTML {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, `<svg class="map" viewBox="0 0 %d %d" role="img" aria-label="Map of dive sites">`, MapWidth, MapHeight)
+	fmt.Fprintf(&sb, `<rect width="%d" height="%d" fill="var(--accent-bg)"/>`, MapWidth, MapHeight)
+
+	nw, se := m.Unproject(0, 0), m.Unproject(MapWidth, MapHeight)
+	for _, shape := range Coastline {
+		if shape.max.Lon < nw.Lon || shape.min.Lon > se.Lon || shape.max.Lat < se.Lat || shape.min.Lat > nw.Lat {
+			continue
+		}
+		fill := "var(--bg)"
+		if shape.Lake {
+			fill = "var(--accent-bg)"
+		}
+		fmt.Fprintf(&sb, `<path d="%s" fill="%s" stroke="var(--border)" stroke-linejoin="round"><title>%s</title></path>`,
+			m.path(shape.Outline), fill, html.EscapeString(shape.Name))
+	}
+
+	for _, pin := range m.Pins {
+		if pin.Cluster() {
+			fmt.Fprintf(&sb, `<g class="map-cluster" hx-get="%s" hx-target="#map" hx-swap="outerHTML" hx-push-url="true">`,
+				html.EscapeString(string(m.zoomTo(pin).URL())))
+			fmt.Fprintf(&sb, `<circle cx="%.1f" cy="%.1f" r="%d" fill="var(--accent)" stroke="var(--bg)" stroke-width="2"/>`,
+				pin.X, pin.Y, min(9+len(pin.Sites), MapClusterRadius))
+			fmt.Fprintf(&sb, `<text x="%.1f" y="%.1f" font-size="11" text-anchor="middle" dominant-baseline="central" fill="var(--bg)">%d</text>`,
+				pin.X, pin.Y, len(pin.Sites))
+			fmt.Fprintf(&sb, `<title>%d sites, %d dives</title></g>`, len(pin.Sites), pin.Dives)
+			continue
+		}
+		site := pin.Sites[0]
+		fmt.Fprintf(&sb, `<a href="/sites/%s">`, html.EscapeString(url.PathEscape(site.ID)))
+		fmt.Fprintf(&sb, `<circle cx="%.1f" cy="%.1f" r="6" fill="var(--accent)" stroke="var(--bg)" stroke-width="2"/>`, pin.X, pin.Y)
+		fmt.Fprintf(&sb, `<title>%s: %d dives</title></a>`, html.EscapeString(site.Name), pin.Dives)
+	}
+	sb.WriteString(`</svg>`)
+	return template.HTML(sb.String())
+}
+
+// path returns SVG path data of the outline, leaving out positions less than a unit away from the previous one.
+func (m *Map) path(outline []LatLon) string {
+	var (
+		sb           strings.Builder
+		lastX, lastY float64
+	)
+	for i, p := range outline {
+		x, y := m.Project(p)
+		if i > 0 && math.Abs(x-lastX) < 1 && math.Abs(y-lastY) < 1 {
+			continue
+		}
+		command := "L"
+		if i == 0 {
+			command = "M"
+		}
+		fmt.Fprintf(&sb, "%s%.1f %.1f", command, x, y)
+		lastX, lastY = x, y
+	}
+	sb.WriteString("Z")
+	return sb.String()
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+)
+
+// HTTPS handler for the map of dive sites. Zooming and panning only re-render the map (see the "map" template).
+func mapHandler(w http.ResponseWriter, r *http.Request) {
+	page := &Page{Title: "Map"}
+
+	mlog := LogOf(r)
+	mlog.RLock()
+	defer mlog.RUnlock()
+	sites := mlog.Sites().All()
+	page.Map = ParseMap(r.URL.Query())
+	if page.Map == nil {
+		var positions []*LatLon
+		for _, site := range sites {
+			if site.Position != nil {
+				positions = append(positions, site.Position)
+			}
+		}
+		page.Map = FitMap(positions)
+	}
+	page.Map.Pin(sites, func(site *Site) int { return len(mlog.SiteDives(site.ID)) })
+
+	if r.Header.Get("HX-Target") == "map" {
+		partialRender("map", w, page)
+		return
+	}
+	render("map.html", w, r, page)
+}
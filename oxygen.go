@@ -0,0 +1,199 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	DefaultMaxPPO2 = 1.4 // bar, max. ppO2 of the bottom gas
+
+	// CNS oxygen toxicity is eliminated at the surface with a half-time of 90 minutes.
+	CNSHalfTime = 90 * time.Minute
+
+	// Exposure limits: CNSLimit is the NOAA single exposure limit; OTUDailyLimit is the REPEX limit for a single day.
+	CNSLimit      = 100.0 // %
+	OTUDailyLimit = 850.0
+)
+
+// NOAA single exposure limits: the longest time (in minutes) oxygen can be breathed at a partial pressure (in bar).
+// CNS oxygen toxicity is accumulated as the fraction of the limit used, and interpolated between table entries.
+var cnsLimits = []struct{ PPO2, Minutes float64 }{
+	{0.5, math.Inf(1)},
+	{0.6, 720},
+	{0.7, 570},
+	{0.8, 450},
+	{0.9, 360},
+	{1.0, 300},
+	{1.1, 240},
+	{1.2, 210},
+	{1.3, 180},
+	{1.4, 150},
+	{1.5, 120},
+	{1.6, 45},
+}
+
+// OxygenExposure is the accumulated oxygen toxicity: CNS in % of the single exposure limit, and pulmonary toxicity in
+// oxygen toxicity units.
+type OxygenExposure struct {
+	CNS     float64
+	OTU     float64
+	MaxPPO2 float64
+}
+
+// PartialPressure returns the partial pressure of oxygen in gas at the given depth.
+func (g GasMix) PartialPressure(depth float64) float64 {
+	return g.FO2() * AmbientPressure(depth)
+}
+
+// MOD returns the maximum operating depth of the gas, at which the partial pressure of oxygen reaches ppO2.
+func (g GasMix) MOD(ppO2 float64) float64 {
+	if g.O2 <= 0 {
+		return 0
+	}
+	return max(0, (ppO2/g.FO2()-SurfacePressure)/BarPerMeter)
+}
+
+// END returns the equivalent narcotic depth of the gas at the given depth, treating oxygen as narcotic as nitrogen.
+func (g GasMix) END(depth float64) float64 {
+	return max(0, (AmbientPressure(depth)*(1-g.FHe())-SurfacePressure)/BarPerMeter)
+}
+
+// CNSPerMinute returns CNS oxygen toxicity accumulated in a minute at a partial pressure of oxygen, in %.
+func CNSPerMinute(ppO2 float64) float64 {
+	if ppO2 <= cnsLimits[0].PPO2 {
+		return 0
+	}
+	for i := 1; i < len(cnsLimits); i++ {
+		if ppO2 <= cnsLimits[i].PPO2 {
+			lo, hi := cnsLimits[i-1], cnsLimits[i]
+			rateLo, rateHi := 100/lo.Minutes, 100/hi.Minutes
+			return rateLo + (rateHi-rateLo)*(ppO2-lo.PPO2)/(hi.PPO2-lo.PPO2)
+		}
+	}
+	return 100 / cnsLimits[len(cnsLimits)-1].Minutes // beyond the table, the limit is exceeded anyway
+}
+
+// OTUPerMinute returns oxygen toxicity units accumulated in a minute at a partial pressure of oxygen.
+func OTUPerMinute(ppO2 float64) float64 {
+	if ppO2 <= 0.5 {
+		return 0
+	}
+	return math.Pow((ppO2-0.5)/0.5, 0.83)
+}
+
+// Add accumulates exposure for a segment of the dive: depth changing linearly from startDepth to endDepth, over
+// minutes, while breathing gas.
+func (e *OxygenExposure) Add(startDepth float64, endDepth float64, minutes float64, gas GasMix) {
+	if minutes <= 0 {
+		return
+	}
+	steps := math.Ceil(minutes)
+	for i := 0.0; i < steps; i++ {
+		depth := startDepth + (endDepth-startDepth)*(i+0.5)/steps
+		ppO2 := gas.PartialPressure(depth)
+		e.CNS += CNSPerMinute(ppO2) * minutes / steps
+		e.OTU += OTUPerMinute(ppO2) * minutes / steps
+	}
+	e.MaxPPO2 = max(e.MaxPPO2, gas.PartialPressure(startDepth), gas.PartialPressure(endDepth))
+}
+
+// ProfileOxygenExposure returns oxygen exposure accumulated over a dive profile (see `WalkProfile`).
+func ProfileOxygenExposure(profile []ProfileSample, gases []GasMix) *OxygenExposure {
+	exposure := &OxygenExposure{}
+	WalkProfile(profile, gases, func(startDepth, endDepth, minutes float64, gas, _ GasMix) {
+		exposure.Add(startDepth, endDepth, minutes, gas)
+	})
+	return exposure
+}
+
+// GasUse holds limits of a gas used on a dive: its MOD at the ppO2 limit of the gas, and its END at the maximum
+// depth of the dive.
+type GasUse struct {
+	Gas   GasMix
+	Limit float64 // bar, ppO2
+	MOD   float64 // m
+	END   float64 // m
+}
+
+// GasUses returns limits of the gases used on a dive with the given maximum depth. The limit of the bottom gas (the
+// first one) is configured, and other gases are limited to `MaxDecoPPO2`.
+func GasUses(gases []GasMix, maxDepth float32) []*GasUse {
+	uses := make([]*GasUse, 0, len(gases))
+	for i, gas := range gases {
+		use := &GasUse{Gas: gas, Limit: MaxDecoPPO2, END: gas.END(float64(maxDepth))}
+		if i == 0 {
+			use.Limit = config.maxPPO2
+		}
+		use.MOD = gas.MOD(use.Limit)
+		uses = append(uses, use)
+	}
+	return uses
+}
+
+// OxygenLoad is oxygen exposure on a dive, and cumulative exposure over consecutive dives.
+type OxygenLoad struct {
+	Dive     *OxygenExposure
+	CNSStart float64 // %, CNS residual from previous dives
+	CNSEnd   float64 // %, at the end of the dive
+	OTU24h   float64 // OTU accumulated over the dive and the 24 hours before it
+}
+
+// Exposure returns oxygen exposure on the dive, from its profile or, if there isn't one, from its maximum depth and
+// duration (see `Dive.Profile`). If neither is known, nil is returned.
+func (d *Dive) Exposure() *OxygenExposure {
+	profile := d.Profile()
+	if profile == nil {
+		return nil
+	}
+	return ProfileOxygenExposure(profile, d.Data.BreathingGases())
+}
+
+// OxygenLoad returns oxygen exposure on the dive, with CNS carried over from previous dives (eliminated at the
+// surface, see `CNSHalfTime`) and OTU accumulated over 24 hours. It returns nil if exposure on the dive is unknown.
+func (dl *DiveLog) OxygenLoad(dive *Dive) *OxygenLoad {
+	exposure := dive.Exposure()
+	if exposure == nil {
+		return nil
+	}
+	load := &OxygenLoad{Dive: exposure, OTU24h: exposure.OTU}
+
+	first := dive.ix
+	for first > 0 && SurfaceIntervalBetween(dl.sorted[first-1], dive) < RepetitiveWindow {
+		first--
+	}
+	var (
+		cns  = 0.0
+		last *Dive // last dive with known exposure, CNS decays from the end of it
+	)
+	for i := first; i < dive.ix; i++ {
+		prev := dl.sorted[i]
+		prevExposure := prev.Exposure()
+		if prevExposure == nil {
+			continue
+		}
+		if last != nil {
+			cns = decayCNS(cns, SurfaceIntervalBetween(last, prev))
+		}
+		cns += prevExposure.CNS
+		last = prev
+		if dive.DateTimeIn.Sub(prev.TimeOut()) < 24*time.Hour {
+			load.OTU24h += prevExposure.OTU
+		}
+	}
+	if last != nil {
+		cns = decayCNS(cns, SurfaceIntervalBetween(last, dive))
+	}
+	load.CNSStart = cns
+	load.CNSEnd = cns + exposure.CNS
+	return load
+}
+
+func decayCNS(cns float64, interval time.Duration) float64 {
+	return cns * math.Pow(0.5, interval.Minutes()/CNSHalfTime.Minutes())
+}
+
+// Exceeded reports whether CNS or daily OTU limits were exceeded.
+func (l *OxygenLoad) Exceeded() bool {
+	return l.CNSEnd > CNSLimit || l.OTU24h > OTUDailyLimit
+}
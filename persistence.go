@@ -9,6 +9,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/cicovic-andrija/libgo/logging"
@@ -20,30 +21,76 @@ import (
 
 const (
 	DataDirectory       = "data"
+	UsersDirectory      = "users" // in DataDirectory, holds a directory with the dive log of each account
 	DiveLogFileName     = "divelog.json"
 	TempDiveLogFileName = "divelog.tmp.json"
 
-	LogMajor = 1
+	LogMajor = 8 // 2: site catalog, 3: trips, 4: people, 5: gear, 6: dive plans, 7: trash, 8: tags
 )
 
 var ErrCorruptedLog = errors.New("corrupted log file")
 
-var MLog *DiveLog = NewDiveLog()
-
 type PersistedDiveLog struct {
-	Version  string        `json:"version"`
-	Modified string        `json:"modified"`
-	Dives    []*DiveRecord `json:"dives"`
+	Version  string         `json:"version"`
+	Modified string         `json:"modified"`
+	Dives    []*DiveRecord  `json:"dives"`
+	Sites    []*Site        `json:"sites,omitempty"`
+	Trips    []*Trip        `json:"trips,omitempty"`
+	People   []*Person      `json:"people,omitempty"`
+	Gear     []*GearItem    `json:"gear,omitempty"`
+	Plans    []*Plan        `json:"plans,omitempty"`
+	Trash    []*TrashedDive `json:"trash,omitempty"`
 }
 
-func ensureDataLoadAsync() {
-	MLog.Lock()
-	if err := MLog.load(); err != nil {
-		crash("load dive data operation failed: %v", err)
-	} else {
-		trace(logging.SevInfo, "successfully loaded dive data (sequence %d)", MLog.sequence)
+// LogRegistry holds dive logs of all accounts, loaded on first use. Unlike `DiveLog`, its functions are thread-safe.
+type LogRegistry struct {
+	sync.Mutex
+	logs map[string]*DiveLog // by username
+}
+
+var Logs = &LogRegistry{logs: make(map[string]*DiveLog)}
+
+// Open returns the dive log of the account, loading it from persistent storage if needed. An account without a log
+// file gets an empty log, except that the first admin adopts the dive log from before there were accounts.
+func (lr *LogRegistry) Open(account *Account) (*DiveLog, error) {
+	lr.Lock()
+	defer lr.Unlock()
+	if mlog := lr.logs[account.Username]; mlog != nil {
+		return mlog, nil
+	}
+
+	mlog := NewDiveLog()
+	mlog.dir = filepath.Join(DataDirectory, UsersDirectory, account.Username)
+	if err := os.MkdirAll(mlog.dir, 0700); err != nil {
+		return nil, fmt.Errorf("log directory creation failed: %v", err)
 	}
-	MLog.Unlock()
+	plogPath := filepath.Join(mlog.dir, DiveLogFileName)
+	if _, err := os.Stat(plogPath); errors.Is(err, os.ErrNotExist) {
+		if legacyPath := filepath.Join(DataDirectory, DiveLogFileName); account.Admin && fileExists(legacyPath) {
+			if err = os.Rename(legacyPath, plogPath); err != nil {
+				return nil, fmt.Errorf("legacy log adoption failed: %v", err)
+			}
+			trace(logging.SevInfo, "dive log %s adopted by %s", legacyPath, account.Username)
+		} else {
+			mlog.sequence = 1
+			lr.logs[account.Username] = mlog
+			return mlog, nil
+		}
+	}
+
+	mlog.Lock()
+	defer mlog.Unlock()
+	if err := mlog.load(); err != nil {
+		return nil, err
+	}
+	trace(logging.SevInfo, "successfully loaded dive data of %s (sequence %d)", account.Username, mlog.sequence)
+	lr.logs[account.Username] = mlog
+	return mlog, nil
+}
+
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
 }
 
 func (mlog *DiveLog) load() error {
@@ -52,10 +99,11 @@ func (mlog *DiveLog) load() error {
 		sequence uint64
 	)
 
-	plogFile, err := os.Open(filepath.Join(DataDirectory, DiveLogFileName))
+	plogFile, err := os.Open(filepath.Join(mlog.dir, DiveLogFileName))
 	if err != nil {
 		return fmt.Errorf("read log operation failed: %v", err)
 	}
+	defer plogFile.Close()
 
 	plog := &PersistedDiveLog{}
 	if err = json.NewDecoder(plogFile).Decode(plog); err != nil {
@@ -66,7 +114,8 @@ func (mlog *DiveLog) load() error {
 	if parts := strings.Split(plog.Version, ":"); len(parts) != 2 {
 		return ErrCorruptedLog
 	} else {
-		if maj, err := strconv.Atoi(parts[0]); err != nil || maj != LogMajor {
+		// Older major versions are still readable, missing data is reconstructed.
+		if maj, err := strconv.Atoi(parts[0]); err != nil || maj < 1 || maj > LogMajor {
 			return ErrCorruptedLog
 		}
 		if seq, err := strconv.Atoi(parts[1]); err != nil {
@@ -82,30 +131,69 @@ func (mlog *DiveLog) load() error {
 	}
 
 	// Second, extract and reconstruct dive data.
-	if err = mlog.Reconstruct(plog.Dives); err == nil {
-		mlog.sequence = sequence + 1
-		mlog.lastPersisted = modified
+	if err = mlog.ReconstructSites(plog.Sites); err != nil {
+		return err
 	}
-	return err
+	if err = mlog.ReconstructTrips(plog.Trips); err != nil {
+		return err
+	}
+	if err = mlog.ReconstructPeople(plog.People); err != nil {
+		return err
+	}
+	if err = mlog.ReconstructGear(plog.Gear); err != nil {
+		return err
+	}
+	if err = mlog.ReconstructPlans(plog.Plans); err != nil {
+		return err
+	}
+	if err = mlog.Reconstruct(plog.Dives); err != nil {
+		return err
+	}
+	if err = mlog.ReconstructTrash(plog.Trash); err != nil {
+		return err
+	}
+	mlog.purgeExpired(time.Now())
+	if err = mlog.loadHistory(); err != nil {
+		return err
+	}
+	mlog.sequence = sequence + 1
+	mlog.lastPersisted = modified
+	return nil
 }
 
-func saveAsync() {
-	MLog.Lock()
-	if err := MLog.save(); err != nil {
-		trace(logging.SevError, "persistence of dive log sequence %d failed: %v", MLog.sequence, err)
+// persist saves the log after a mutation, or, while a change is in progress (see `Change`), once it is committed.
+func (dl *DiveLog) persist() {
+	dl.derived.reset()
+	if dl.pending != nil {
+		dl.pending.modified = true
+		return
+	}
+	if dl.dir != "" {
+		go dl.saveAsync()
+	}
+}
+
+// saveAsync persists the log, unless it was made in memory only since the save was started.
+func (dl *DiveLog) saveAsync() {
+	dl.Lock()
+	defer dl.Unlock()
+	if dl.dir == "" {
+		return
+	}
+	if err := dl.save(); err != nil {
+		trace(logging.SevError, "persistence of dive log %s sequence %d failed: %v", dl.dir, dl.sequence, err)
 	} else {
-		trace(logging.SevInfo, "successfully persisted dive log sequence %d", MLog.sequence-1)
+		trace(logging.SevInfo, "successfully persisted dive log %s sequence %d", dl.dir, dl.sequence-1)
 	}
-	MLog.Unlock()
 }
 
 func (dl *DiveLog) save() error {
-	tmpFile, err := os.OpenFile(
-		filepath.Join(DataDirectory, TempDiveLogFileName), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	tmpPath := filepath.Join(dl.dir, TempDiveLogFileName)
+	tmpFile, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
 		return fmt.Errorf("temp log file creation failed: %v", err)
 	}
-	defer os.Remove(TempDiveLogFileName)
+	defer os.Remove(tmpPath)
 	defer tmpFile.Close()
 
 	diveRecords := make([]*DiveRecord, 0, len(dl.sorted))
@@ -117,16 +205,22 @@ func (dl *DiveLog) save() error {
 		Version:  fmt.Sprintf("%d:%d", LogMajor, dl.sequence),
 		Modified: modifiedTime.Format(time.RFC3339),
 		Dives:    diveRecords,
+		Sites:    dl.sites.All(),
+		Trips:    dl.Trips(),
+		People:   dl.People(),
+		Gear:     dl.Gear(),
+		Plans:    dl.Plans(),
+		Trash:    dl.Trashed(),
 	}
 	if err = NewEncoder(tmpFile).Encode(plog); err != nil {
 		return fmt.Errorf("encode log operation failed: %v", err)
 	}
-	if err = os.Rename(
-		filepath.Join(DataDirectory, TempDiveLogFileName),
-		filepath.Join(DataDirectory, DiveLogFileName),
-	); err != nil {
+	if err = os.Rename(tmpPath, filepath.Join(dl.dir, DiveLogFileName)); err != nil {
 		return fmt.Errorf("write log operation failed: %v", err)
 	}
+	if err = dl.saveHistory(); err != nil {
+		return err
+	}
 
 	dl.sequence++
 	dl.lastPersisted = modifiedTime
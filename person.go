@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+)
+
+// Roles of people on a dive.
+const (
+	RoleBuddy      = "buddy"
+	RoleGuide      = "guide"
+	RoleInstructor = "instructor"
+)
+
+var Roles = []string{RoleBuddy, RoleGuide, RoleInstructor}
+
+// Person is an entry in the people directory: someone who was on a dive as a buddy, guide or instructor. Dives
+// reference people by ID (see `DiveRecord.People`).
+type Person struct {
+	ID            string `json:"id"`
+	Name          string `json:"name"`
+	Certification string `json:"certification,omitempty"` // e.g. "Advanced Open Water"
+	Agency        string `json:"agency,omitempty"`        // e.g. "PADI"
+	AgencyNumber  string `json:"agency_number,omitempty"`
+	Contact       string `json:"contact,omitempty"`
+}
+
+// DiveParticipant is a person who was on a dive, in a certain role.
+type DiveParticipant struct {
+	PersonID string `json:"person_id"`
+	Role     string `json:"role"`
+	Name     string `json:"-"` // name as entered in the dive form, until linked to a person (see `DiveLog.linkPeople`)
+}
+
+// People returns all people from the directory, ordered by name.
+func (dl *DiveLog) People() []*Person {
+	return slices.Clone(dl.directory)
+}
+
+func (dl *DiveLog) FindPerson(id string) *Person {
+	return dl.people[id]
+}
+
+// LookupPerson returns the person with the given name, or nil. Names are compared like site names (see `siteKey`).
+func (dl *DiveLog) LookupPerson(name string) *Person {
+	key := siteKey(name)
+	for _, person := range dl.directory {
+		if siteKey(person.Name) == key {
+			return person
+		}
+	}
+	return nil
+}
+
+// PersonDives returns dives the person with the given ID was on (in any role), in chronological order.
+func (dl *DiveLog) PersonDives(id string) DiveList {
+	return dl.sorted.Filter(func(dive *Dive) bool { return dive.Data.Role(id) != "" })
+}
+
+// ReconstructPeople initializes the people directory from a list of people. It must be called before `Reconstruct`,
+// so dives can be linked to people.
+func (dl *DiveLog) ReconstructPeople(people []*Person) error {
+	byID := make(map[string]*Person, len(people))
+	for i, person := range people {
+		if person.ID == "" || byID[person.ID] != nil {
+			return fmt.Errorf("reconstruction failed: invalid or duplicate person ID @ %s", fmt.Sprintf("/people/%d", i))
+		}
+		byID[person.ID] = person
+	}
+	dl.people = byID
+	dl.sortPeople()
+	return nil
+}
+
+// AddPerson inserts a new person into the directory, assigning them a unique ID.
+func (dl *DiveLog) AddPerson(person *Person) {
+	dl.addPerson(person)
+
+	dl.persist()
+}
+
+// UpdatePerson replaces the person's data, keeping their ID.
+func (dl *DiveLog) UpdatePerson(existing *Person, person *Person) {
+	person.ID = existing.ID
+	*existing = *person
+	dl.sortPeople()
+
+	dl.persist()
+}
+
+// RemovePerson deletes the person from the directory, and from all dives they were on.
+func (dl *DiveLog) RemovePerson(id string) {
+	for _, dive := range dl.PersonDives(id) {
+		dl.touch(dive.id)
+		dive.Data.People = slices.DeleteFunc(dive.Data.People, func(p *DiveParticipant) bool { return p.PersonID == id })
+	}
+	delete(dl.people, id)
+	dl.sortPeople()
+
+	dl.persist()
+}
+
+func (dl *DiveLog) addPerson(person *Person) {
+	person.ID = UniqueSlug(person.Name, "person", func(id string) bool { return dl.people[id] != nil })
+	dl.people[person.ID] = person
+	dl.sortPeople()
+}
+
+// sortPeople rebuilds the directory ordered by name, after people were added, renamed or removed.
+func (dl *DiveLog) sortPeople() {
+	people := make([]*Person, 0, len(dl.people))
+	for _, person := range dl.people {
+		people = append(people, person)
+	}
+	sort.Slice(people, func(i int, j int) bool {
+		if ki, kj := siteKey(people[i].Name), siteKey(people[j].Name); ki != kj {
+			return ki < kj
+		}
+		return people[i].ID < people[j].ID
+	})
+	dl.directory = people
+}
+
+// linkPeople makes every participant of the dive reference a person from the directory. Participants entered by name
+// are looked up by name, and added to the directory if needed. References to unknown people are dropped.
+func (dl *DiveLog) linkPeople(dive *Dive) {
+	linked := make([]*DiveParticipant, 0, len(dive.Data.People))
+	for _, participant := range dive.Data.People {
+		if participant.PersonID == "" && participant.Name != "" {
+			person := dl.LookupPerson(participant.Name)
+			if person == nil {
+				person = &Person{Name: participant.Name}
+				dl.addPerson(person)
+			}
+			participant.PersonID = person.ID
+			participant.Name = ""
+		}
+		if dl.people[participant.PersonID] != nil && slices.Contains(Roles, participant.Role) &&
+			!slices.ContainsFunc(linked, func(p *DiveParticipant) bool { return p.PersonID == participant.PersonID }) {
+			linked = append(linked, participant)
+		}
+	}
+	dive.Data.People = linked
+}
+
+// Role returns the role of the person with the given ID on the dive, or an empty string if they weren't on it.
+func (dr *DiveRecord) Role(personID string) string {
+	for _, participant := range dr.People {
+		if participant.PersonID == personID {
+			return participant.Role
+		}
+	}
+	return ""
+}
+
+// PeopleIn returns IDs of people on the dive in the given role.
+func (dr *DiveRecord) PeopleIn(role string) []string {
+	var ids []string
+	for _, participant := range dr.People {
+		if participant.Role == role {
+			ids = append(ids, participant.PersonID)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+const (
+	PersonNameTag          = "name"
+	PersonCertificationTag = "certification"
+	PersonAgencyTag        = "agency"
+	PersonAgencyNumberTag  = "agency_number"
+	PersonContactTag       = "contact"
+)
+
+func peopleHandler(w http.ResponseWriter, r *http.Request) {
+	page := &Page{
+		Title:     "People",
+		Summaries: make(map[string]*DiveSummary),
+	}
+
+	mlog := LogOf(r)
+	mlog.RLock()
+	defer mlog.RUnlock()
+	page.People = mlog.People()
+	for _, person := range page.People {
+		page.Summaries[person.ID] = mlog.PersonDives(person.ID).Summary()
+	}
+
+	render("people.html", w, r, page)
+}
+
+func personHandler(w http.ResponseWriter, r *http.Request) {
+	mlog := LogOf(r)
+	mlog.RLock()
+	defer mlog.RUnlock()
+
+	person := mlog.FindPerson(r.PathValue(IDTag))
+	if person == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	page := &Page{
+		Title:  person.Name,
+		Person: person,
+		Dives:  mlog.PersonDives(person.ID),
+	}
+	page.Summary = DiveList(page.Dives).Summary()
+
+	render("person.html", w, r, page)
+}
+
+func newPersonHandler(w http.ResponseWriter, r *http.Request) {
+	page := &Page{
+		Title:  "New Person",
+		Person: &Person{},
+	}
+	render("person.html", w, r, page)
+}
+
+func personFormHandler(w http.ResponseWriter, r *http.Request) {
+	var (
+		page     = &Page{InputErrors: make(map[string]string)}
+		existing *Person
+	)
+
+	mlog := LogOf(r)
+	mlog.Lock()
+	defer mlog.Unlock()
+
+	if id := r.PathValue(IDTag); id != "" { // .../{id}/edit
+		if existing = mlog.FindPerson(id); existing == nil {
+			http.NotFound(w, r)
+			return
+		}
+	} // else .../new
+
+	person, ok := parsePersonFromRequest(r, page.InputErrors)
+	if ok {
+		if other := mlog.LookupPerson(person.Name); other != nil && other != existing {
+			ok = false
+			page.InputErrors[PersonNameTag] = fmt.Sprintf("%s is already in the directory.", other.Name)
+		}
+	}
+
+	if ok {
+		if existing != nil {
+			mlog.UpdatePerson(existing, person)
+		} else {
+			mlog.AddPerson(person)
+		}
+		http.Redirect(w, r, "/people/"+person.ID, http.StatusFound)
+	} else { // not ok
+		page.Title = "New Person"
+		if existing != nil {
+			page.Title = existing.Name
+			person.ID = existing.ID
+			page.Dives = mlog.PersonDives(existing.ID)
+			page.Summary = DiveList(page.Dives).Summary()
+		}
+		page.Person = person
+		render("person.html", w, r, page)
+	}
+}
+
+func personRemovalHandler(w http.ResponseWriter, r *http.Request) {
+	mlog := LogOf(r)
+	mlog.Lock()
+	defer mlog.Unlock()
+
+	if mlog.FindPerson(r.PathValue(IDTag)) == nil {
+		http.NotFound(w, r)
+		return
+	}
+	change := mlog.Begin(AccountOf(r), "Person removal")
+	mlog.RemovePerson(r.PathValue(IDTag))
+	change.Commit()
+
+	if r.Header.Get("HX-Trigger") == "delete-btn" {
+		http.Redirect(w, r, "/people", http.StatusSeeOther) // 303 because this is a redirect to a DELETE request
+	} else {
+		fmt.Fprint(w, "") // this is an async call, return hypermedia in the response to client
+	}
+}
+
+func parsePersonFromRequest(r *http.Request, errorMap map[string]string) (person *Person, ok bool) {
+	ok = true
+	person = &Person{}
+
+	if name, errMsg := validateNonEmptyString(r.FormValue(PersonNameTag)); errMsg != "" {
+		ok = false
+		errorMap[PersonNameTag] = "Please provide the name of the person."
+	} else {
+		person.Name = name
+	}
+
+	// Optional parameters: accept even an empty value after input is trimmed.
+	person.Certification, _ = validateNonEmptyString(r.FormValue(PersonCertificationTag))
+	person.Agency, _ = validateNonEmptyString(r.FormValue(PersonAgencyTag))
+	person.AgencyNumber, _ = validateNonEmptyString(r.FormValue(PersonAgencyNumberTag))
+	person.Contact, _ = validateNonEmptyString(r.FormValue(PersonContactTag))
+
+	return
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Gas reserve rules of a dive plan.
+const (
+	ReserveThirds = "thirds" // a third of each tank is kept in reserve
+	ReserveHalves = "halves" // half of each tank is kept in reserve
+	ReserveFixed  = "fixed"  // ReserveFixedBar is kept in reserve in each tank
+
+	ReserveFixedBar = 50
+	DefaultSAC      = 20.0 // L/min
+)
+
+var ReserveRules = []string{ReserveThirds, ReserveHalves, ReserveFixed}
+
+// Kinds of rows in a runtime table.
+const (
+	PlanDescent = "descent"
+	PlanLevel   = "level"
+	PlanAscent  = "ascent"
+	PlanStop    = "stop"
+)
+
+var (
+	ErrInvalidPlanSegments = errors.New("invalid plan segments")
+	ErrInvalidPlanGases    = errors.New("invalid plan gases")
+)
+
+// Plan is a planned dive: depth/time segments, gases with their tanks, and parameters of the decompression model.
+// A plan can be linked to the dive that was made according to it (see `Plan.DiveID`).
+type Plan struct {
+	ID       string         `json:"id"`
+	Name     string         `json:"name"`
+	Segments []*PlanSegment `json:"segments"`
+	Gases    []*PlanGas     `json:"gases"`
+	GFLow    int            `json:"gf_low"`  // %
+	GFHigh   int            `json:"gf_high"` // %
+	SAC      float64        `json:"sac"`     // surface air consumption in L/min
+	Reserve  string         `json:"reserve"` // reserve rule
+	DiveID   string         `json:"dive_id,omitempty"`
+}
+
+// PlanSegment is a part of the plan spent at a constant depth. Travel to the depth is not included in its time.
+type PlanSegment struct {
+	Depth   float64 `json:"depth"`   // m
+	Minutes float64 `json:"minutes"` // at the depth
+	Gas     int     `json:"gas"`     // 1-based index into `Plan.Gases`
+}
+
+// PlanGas is a gas of the plan, and the tank it's carried in.
+type PlanGas struct {
+	Mix      GasMix  `json:"mix"`
+	Volume   float64 `json:"volume"`   // water capacity of the tank in liters
+	Pressure int     `json:"pressure"` // fill pressure in bar
+}
+
+// PlanRow is a row of the runtime table.
+type PlanRow struct {
+	Kind      string
+	Depth     float64 // at the end of the row
+	Minutes   float64
+	Runtime   float64 // at the end of the row
+	Gas       GasMix
+	GasSwitch bool
+}
+
+// GasRequirement is the amount of a gas needed for the plan, compared with the amount available in its tank.
+type GasRequirement struct {
+	Gas       *PlanGas
+	Consumed  float64 // L, at the surface
+	Required  float64 // L, consumed plus reserve
+	Available float64 // L
+}
+
+// PlanResult is the computed plan.
+type PlanResult struct {
+	Rows          []*PlanRow
+	Runtime       float64
+	TTS           float64 // from the end of the last segment
+	MaxDepth      float64
+	Decompression bool
+	Oxygen        *OxygenExposure
+	Gases         []*GasRequirement
+	Profile       []ProfileSample
+	Model         *DecoModel // decompression model the plan was computed with
+}
+
+// PlanComparison lines up figures of a plan and of the dive made according to it.
+type PlanComparison struct {
+	Label   string
+	Planned string
+	Actual  string
+}
+
+// NewPlan returns a plan with default parameters: a single segment on air and configured gradient factors.
+func NewPlan() *Plan {
+	return &Plan{
+		Segments: []*PlanSegment{{Depth: 18, Minutes: 40, Gas: 1}},
+		Gases:    []*PlanGas{{Mix: Air, Volume: 12, Pressure: 200}},
+		GFLow:    config.gfLow,
+		GFHigh:   config.gfHigh,
+		SAC:      DefaultSAC,
+		Reserve:  ReserveThirds,
+	}
+}
+
+// Mixes returns gas mixes of the plan, in order.
+func (p *Plan) Mixes() []GasMix {
+	mixes := make([]GasMix, 0, len(p.Gases))
+	for _, gas := range p.Gases {
+		mixes = append(mixes, gas.Mix)
+	}
+	return mixes
+}
+
+// Description returns a short description of the plan, e.g. "30 m, 25 min, air, EAN50".
+func (p *Plan) Description() string {
+	var maxDepth, minutes float64
+	for _, segment := range p.Segments {
+		maxDepth = max(maxDepth, segment.Depth)
+		minutes += segment.Minutes
+	}
+	return fmt.Sprintf("%s m, %s min, %s",
+		strconv.FormatFloat(maxDepth, 'f', -1, 64), strconv.FormatFloat(minutes, 'f', -1, 64), FormatGases(p.Mixes()))
+}
+
+// Compute returns the runtime table of the plan: descent and time at the planned depths, then the ascent with
+// decompression stops and gas switches, together with oxygen exposure and gas requirements.
+func (p *Plan) Compute() *PlanResult {
+	var (
+		model    = &DecoModel{GFLow: float64(p.GFLow) / 100, GFHigh: float64(p.GFHigh) / 100}
+		tissues  = SurfaceTissues()
+		result   = &PlanResult{Oxygen: &OxygenExposure{}, Profile: []ProfileSample{{T: 0, D: 0}}, Model: model}
+		consumed = make([]float64, len(p.Gases))
+		mixes    = p.Mixes()
+		gas      = 0 // index of the gas currently breathed
+		depth    = 0.0
+	)
+
+	// add appends a row, updating tissues, oxygen exposure and consumption.
+	add := func(kind string, to float64, minutes float64, next int) {
+		if minutes <= 0 && next == gas {
+			return
+		}
+		row := &PlanRow{Kind: kind, Depth: to, Minutes: minutes, Gas: mixes[next], GasSwitch: next != gas}
+		gas = next
+		tissues.Load(depth, to, minutes, mixes[gas])
+		result.Oxygen.Add(depth, to, minutes, mixes[gas])
+		consumed[gas] += p.SAC * (AmbientPressure(depth) + AmbientPressure(to)) / 2 / SurfacePressure * minutes
+		result.Runtime += minutes
+		result.MaxDepth = max(result.MaxDepth, to)
+		row.Runtime = result.Runtime
+		result.Rows = append(result.Rows, row)
+
+		sample := ProfileSample{T: int(math.Round(result.Runtime * 60)), D: float32(to)}
+		if row.GasSwitch {
+			sample.G = gas + 1
+		}
+		if last := &result.Profile[len(result.Profile)-1]; last.T == sample.T {
+			*last = sample
+		} else {
+			result.Profile = append(result.Profile, sample)
+		}
+		depth = to
+	}
+
+	for i, segment := range p.Segments {
+		next := segment.Gas - 1
+		if i == 0 && next != gas {
+			gas = next // the first gas is breathed from the surface
+			result.Profile[0].G = gas + 1
+		}
+		switch {
+		case segment.Depth > depth:
+			add(PlanDescent, segment.Depth, (segment.Depth-depth)/DescentRate, next)
+		case segment.Depth < depth:
+			add(PlanAscent, segment.Depth, (depth-segment.Depth)/AscentRate, next)
+		}
+		add(PlanLevel, segment.Depth, segment.Minutes, next)
+	}
+
+	bottom := result.Runtime
+	ascent := *tissues
+	stops, _ := model.Ascent(&ascent, depth, mixes[gas], mixes)
+	result.Decompression = slices.ContainsFunc(stops, func(stop DecoStop) bool { return stop.Minutes > 0 })
+	for _, stop := range stops {
+		add(PlanAscent, stop.Depth, (depth-stop.Depth)/AscentRate, gas)
+		add(PlanStop, stop.Depth, stop.Minutes, p.indexOf(stop.Gas, gas))
+	}
+	add(PlanAscent, 0, depth/AscentRate, gas)
+	result.TTS = result.Runtime - bottom
+
+	for i, gas := range p.Gases {
+		requirement := &GasRequirement{Gas: gas, Consumed: consumed[i], Available: gas.Volume * float64(gas.Pressure)}
+		switch p.Reserve {
+		case ReserveHalves:
+			requirement.Required = consumed[i] * 2
+		case ReserveFixed:
+			requirement.Required = consumed[i] + gas.Volume*ReserveFixedBar
+		default:
+			requirement.Required = consumed[i] * 3 / 2
+		}
+		result.Gases = append(result.Gases, requirement)
+	}
+	return result
+}
+
+// indexOf returns the index of the gas mix in the plan, or fallback if the plan doesn't have it.
+func (p *Plan) indexOf(mix GasMix, fallback int) int {
+	for i, gas := range p.Gases {
+		if gas.Mix == mix {
+			return i
+		}
+	}
+	return fallback
+}
+
+// Sufficient reports whether the tank holds enough gas for the plan, including the reserve.
+func (r *GasRequirement) Sufficient() bool {
+	return r.Required <= r.Available
+}
+
+// RequiredPressure returns the pressure of the gas required in the tank, in bar.
+func (r *GasRequirement) RequiredPressure() int {
+	if r.Gas.Volume <= 0 {
+		return 0
+	}
+	return int(math.Ceil(r.Required / r.Gas.Volume))
+}
+
+// Compare returns figures of the plan lined up with figures of the dive. The dive is evaluated with the decompression
+// model of the plan.
+func (r *PlanResult) Compare(dive *Dive) []*PlanComparison {
+	var (
+		_, plannedAvg = ProfileDepths(r.Profile)
+		actualDeco    = "unknown"
+		actualCNS     = "unknown"
+	)
+	if profile := dive.Profile(); profile != nil {
+		actualDeco = yesNo(r.Model.Evaluate(profile, dive.Data.BreathingGases(), nil).RequiredDeco)
+		actualCNS = fmt.Sprintf("%.0f%%", ProfileOxygenExposure(profile, dive.Data.BreathingGases()).CNS)
+	}
+	return []*PlanComparison{
+		{"Max. Depth", fmt.Sprintf("%.1f m", r.MaxDepth), formatKnownDepth(dive.Data.MaxDepth)},
+		{"Avg. Depth", fmt.Sprintf("%.1f m", plannedAvg), formatKnownDepth(dive.Data.AvgDepth)},
+		{"Runtime", fmt.Sprintf("%.0f min", r.Runtime), fmt.Sprintf("%.0f min", dive.Data.Duration.Minutes())},
+		{"Gases", FormatGases(gasMixes(r.Gases)), FormatGases(dive.Data.BreathingGases())},
+		{"Decompression", yesNo(r.Decompression), actualDeco},
+		{"CNS", fmt.Sprintf("%.0f%%", r.Oxygen.CNS), actualCNS},
+	}
+}
+
+func formatKnownDepth(depth float32) string {
+	if depth <= 0 {
+		return "unknown"
+	}
+	return fmt.Sprintf("%.1f m", depth)
+}
+
+func gasMixes(requirements []*GasRequirement) []GasMix {
+	mixes := make([]GasMix, 0, len(requirements))
+	for _, requirement := range requirements {
+		mixes = append(mixes, requirement.Gas.Mix)
+	}
+	return mixes
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// ParsePlanSegments parses plan segments, one per line: depth in meters, minutes at the depth and, optionally, the
+// number of the gas breathed (e.g. "30 20 1"). The first gas is breathed by default.
+func ParsePlanSegments(str string, gases int) ([]*PlanSegment, error) {
+	var segments []*PlanSegment
+	for n, line := range strings.Split(str, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("%w: line %d must have depth, time and an optional gas number", ErrInvalidPlanSegments, n+1)
+		}
+		segment := &PlanSegment{Gas: 1}
+		var err error
+		if segment.Depth, err = strconv.ParseFloat(fields[0], 64); err != nil || segment.Depth <= 0 || segment.Depth > 150 {
+			return nil, fmt.Errorf("%w: line %d: invalid depth %q", ErrInvalidPlanSegments, n+1, fields[0])
+		}
+		if segment.Minutes, err = strconv.ParseFloat(fields[1], 64); err != nil || segment.Minutes < 0 || segment.Minutes > 300 {
+			return nil, fmt.Errorf("%w: line %d: invalid time %q", ErrInvalidPlanSegments, n+1, fields[1])
+		}
+		if len(fields) == 3 {
+			if segment.Gas, err = strconv.Atoi(fields[2]); err != nil || segment.Gas < 1 || segment.Gas > gases {
+				return nil, fmt.Errorf("%w: line %d: invalid gas number %q", ErrInvalidPlanSegments, n+1, fields[2])
+			}
+		}
+		segments = append(segments, segment)
+	}
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("%w: at least one segment is required", ErrInvalidPlanSegments)
+	}
+	return segments, nil
+}
+
+// FormatPlanSegments returns segments in the format accepted by `ParsePlanSegments`.
+func FormatPlanSegments(segments []*PlanSegment) string {
+	var builder strings.Builder
+	for _, segment := range segments {
+		fmt.Fprintf(&builder, "%s %s %d\n",
+			strconv.FormatFloat(segment.Depth, 'f', -1, 64), strconv.FormatFloat(segment.Minutes, 'f', -1, 64), segment.Gas)
+	}
+	return builder.String()
+}
+
+// ParsePlanGases parses plan gases, one per line: the gas mix (see `ParseGasMix`), the water capacity of the tank in
+// liters and its fill pressure in bar (e.g. "EAN50 7 200").
+func ParsePlanGases(str string) ([]*PlanGas, error) {
+	var gases []*PlanGas
+	for n, line := range strings.Split(str, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%w: line %d must have a gas, tank volume and pressure", ErrInvalidPlanGases, n+1)
+		}
+		gas := &PlanGas{}
+		var err error
+		if gas.Mix, err = ParseGasMix(fields[0]); err != nil {
+			return nil, fmt.Errorf("%w: line %d: invalid gas %q", ErrInvalidPlanGases, n+1, fields[0])
+		}
+		if gas.Volume, err = strconv.ParseFloat(fields[1], 64); err != nil || gas.Volume <= 0 || gas.Volume > 50 {
+			return nil, fmt.Errorf("%w: line %d: invalid tank volume %q", ErrInvalidPlanGases, n+1, fields[1])
+		}
+		if gas.Pressure, err = strconv.Atoi(fields[2]); err != nil || gas.Pressure <= 0 || gas.Pressure > 350 {
+			return nil, fmt.Errorf("%w: line %d: invalid pressure %q", ErrInvalidPlanGases, n+1, fields[2])
+		}
+		gases = append(gases, gas)
+	}
+	if len(gases) == 0 {
+		return nil, fmt.Errorf("%w: at least one gas is required", ErrInvalidPlanGases)
+	}
+	return gases, nil
+}
+
+// FormatPlanGases returns gases in the format accepted by `ParsePlanGases`.
+func FormatPlanGases(gases []*PlanGas) string {
+	var builder strings.Builder
+	for _, gas := range gases {
+		fmt.Fprintf(&builder, "%s %s %d\n", gas.Mix, strconv.FormatFloat(gas.Volume, 'f', -1, 64), gas.Pressure)
+	}
+	return builder.String()
+}
+
+// Plans returns all saved plans, ordered by name.
+func (dl *DiveLog) Plans() []*Plan {
+	plans := make([]*Plan, 0, len(dl.plans))
+	for _, plan := range dl.plans {
+		plans = append(plans, plan)
+	}
+	sort.Slice(plans, func(i int, j int) bool {
+		if plans[i].Name != plans[j].Name {
+			return plans[i].Name < plans[j].Name
+		}
+		return plans[i].ID < plans[j].ID
+	})
+	return plans
+}
+
+func (dl *DiveLog) FindPlan(id string) *Plan {
+	return dl.plans[id]
+}
+
+// DivePlans returns plans linked to the dive with the given ID.
+func (dl *DiveLog) DivePlans(diveID string) []*Plan {
+	return Filter(dl.Plans(), func(plan *Plan) bool { return plan.DiveID == diveID })
+}
+
+// ReconstructPlans initializes saved plans from a list. Links to dives that no longer exist are kept, in case the
+// dive is re-created.
+func (dl *DiveLog) ReconstructPlans(plans []*Plan) error {
+	byID := make(map[string]*Plan, len(plans))
+	for i, plan := range plans {
+		if plan.ID == "" || byID[plan.ID] != nil {
+			return fmt.Errorf("reconstruction failed: invalid or duplicate plan ID @ %s", fmt.Sprintf("/plans/%d", i))
+		}
+		byID[plan.ID] = plan
+	}
+	dl.plans = byID
+	return nil
+}
+
+// AddPlan saves a new plan, assigning it a unique ID.
+func (dl *DiveLog) AddPlan(plan *Plan) {
+	plan.ID = UniqueSlug(plan.Name, "plan", func(id string) bool { return dl.plans[id] != nil })
+	dl.plans[plan.ID] = plan
+
+	dl.persist()
+}
+
+// UpdatePlan replaces the plan data, keeping its ID and the linked dive.
+func (dl *DiveLog) UpdatePlan(existing *Plan, plan *Plan) {
+	plan.ID = existing.ID
+	plan.DiveID = existing.DiveID
+	*existing = *plan
+
+	dl.persist()
+}
+
+// LinkPlan links the plan to the dive with the given ID, or unlinks it if the ID is empty.
+func (dl *DiveLog) LinkPlan(plan *Plan, diveID string) {
+	plan.DiveID = diveID
+
+	dl.persist()
+}
+
+func (dl *DiveLog) RemovePlan(id string) {
+	delete(dl.plans, id)
+
+	dl.persist()
+}
+
+// FormattedSegments returns segments of the plan in the format accepted by `ParsePlanSegments`.
+func (p *Plan) FormattedSegments() string {
+	return FormatPlanSegments(p.Segments)
+}
+
+// FormattedGases returns gases of the plan in the format accepted by `ParsePlanGases`.
+func (p *Plan) FormattedGases() string {
+	return FormatPlanGases(p.Gases)
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+const (
+	PlanNameTag     = "name"
+	PlanSegmentsTag = "segments"
+	PlanGasesTag    = "gases"
+	PlanGFLowTag    = "gf_low"
+	PlanGFHighTag   = "gf_high"
+	PlanSACTag      = "sac"
+	PlanReserveTag  = "reserve"
+	PlanActionTag   = "action"
+	PlanDiveTag     = "dive"
+
+	PlanActionSave = "save"
+)
+
+func plansHandler(w http.ResponseWriter, r *http.Request) {
+	page := &Page{Title: "Dive Plans"}
+
+	mlog := LogOf(r)
+	mlog.RLock()
+	defer mlog.RUnlock()
+	page.Plans = mlog.Plans()
+
+	render("plans.html", w, r, page)
+}
+
+// HTTPS handler for the dive planner, with the default plan.
+func newPlanHandler(w http.ResponseWriter, r *http.Request) {
+	plan := NewPlan()
+	page := &Page{
+		Title:      "Dive Planner",
+		Plan:       plan,
+		PlanResult: plan.Compute(),
+	}
+	render("plan.html", w, r, page)
+}
+
+func planHandler(w http.ResponseWriter, r *http.Request) {
+	mlog := LogOf(r)
+	mlog.RLock()
+	defer mlog.RUnlock()
+
+	plan := mlog.FindPlan(r.PathValue(IDTag))
+	if plan == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	page := &Page{
+		Title:      plan.Name,
+		Plan:       plan,
+		PlanResult: plan.Compute(),
+		Dives:      mlog.All(),
+	}
+	if dive := mlog.Find(plan.DiveID); dive != nil {
+		page.Dive = dive
+		page.PlanComparison = page.PlanResult.Compare(dive)
+	}
+
+	render("plan.html", w, r, page)
+}
+
+// HTTPS handler that computes the plan from the form and, if requested, saves it.
+func planFormHandler(w http.ResponseWriter, r *http.Request) {
+	var (
+		page     = &Page{Title: "Dive Planner", InputErrors: make(map[string]string)}
+		existing *Plan
+		save     = r.FormValue(PlanActionTag) == PlanActionSave
+	)
+
+	mlog := LogOf(r)
+	mlog.Lock()
+	defer mlog.Unlock()
+
+	if id := r.PathValue(IDTag); id != "" { // .../{id}/edit
+		if existing = mlog.FindPlan(id); existing == nil {
+			http.NotFound(w, r)
+			return
+		}
+		page.Title = existing.Name
+	} // else .../new or /plan
+
+	plan, ok := parsePlanFromRequest(r, page.InputErrors)
+	if ok && save && plan.Name == "" {
+		ok = false
+		page.InputErrors[PlanNameTag] = "Please name the plan to save it."
+	}
+
+	if ok && save {
+		if existing != nil {
+			mlog.UpdatePlan(existing, plan)
+		} else {
+			mlog.AddPlan(plan)
+		}
+		http.Redirect(w, r, "/plans/"+plan.ID, http.StatusFound)
+		return
+	}
+
+	if existing != nil {
+		plan.ID = existing.ID
+		plan.DiveID = existing.DiveID
+		page.Dives = mlog.All()
+	}
+	page.Plan = plan
+	if ok {
+		page.PlanResult = plan.Compute()
+		if dive := mlog.Find(plan.DiveID); dive != nil {
+			page.Dive = dive
+			page.PlanComparison = page.PlanResult.Compare(dive)
+		}
+	}
+	render("plan.html", w, r, page)
+}
+
+// HTTPS handler that links the plan to a logged dive, for plan-vs-actual comparison.
+func planLinkHandler(w http.ResponseWriter, r *http.Request) {
+	mlog := LogOf(r)
+	mlog.Lock()
+	defer mlog.Unlock()
+
+	plan := mlog.FindPlan(r.PathValue(IDTag))
+	if plan == nil {
+		http.NotFound(w, r)
+		return
+	}
+	diveID := r.FormValue(PlanDiveTag)
+	if diveID != "" && mlog.Find(diveID) == nil {
+		http.Error(w, "dive not found", http.StatusBadRequest)
+		return
+	}
+	mlog.LinkPlan(plan, diveID)
+
+	http.Redirect(w, r, "/plans/"+plan.ID, http.StatusFound)
+}
+
+func planRemovalHandler(w http.ResponseWriter, r *http.Request) {
+	mlog := LogOf(r)
+	mlog.Lock()
+	defer mlog.Unlock()
+
+	if mlog.FindPlan(r.PathValue(IDTag)) == nil {
+		http.NotFound(w, r)
+		return
+	}
+	mlog.RemovePlan(r.PathValue(IDTag))
+
+	if r.Header.Get("HX-Trigger") == "delete-btn" {
+		http.Redirect(w, r, "/plans", http.StatusSeeOther) // 303 because this is a redirect to a DELETE request
+	} else {
+		fmt.Fprint(w, "") // this is an async call, return hypermedia in the response to client
+	}
+}
+
+func parsePlanFromRequest(r *http.Request, errorMap map[string]string) (plan *Plan, ok bool) {
+	ok = true
+	plan = NewPlan()
+	plan.Name = strings.TrimSpace(r.FormValue(PlanNameTag))
+
+	if gases, err := ParsePlanGases(r.FormValue(PlanGasesTag)); err != nil {
+		ok = false
+		errorMap[PlanGasesTag] = "Please fix the gases, " + strings.TrimPrefix(err.Error(), ErrInvalidPlanGases.Error()+": ") + "."
+	} else {
+		plan.Gases = gases
+	}
+
+	if segments, err := ParsePlanSegments(r.FormValue(PlanSegmentsTag), len(plan.Gases)); err != nil {
+		ok = false
+		errorMap[PlanSegmentsTag] = "Please fix the segments, " + strings.TrimPrefix(err.Error(), ErrInvalidPlanSegments.Error()+": ") + "."
+	} else {
+		plan.Segments = segments
+	}
+
+	for _, field := range []struct {
+		tag   string
+		value *int
+	}{
+		{PlanGFLowTag, &plan.GFLow},
+		{PlanGFHighTag, &plan.GFHigh},
+	} {
+		if gf, errMsg := validateGradientFactorInput(r.FormValue(field.tag)); errMsg != "" {
+			ok = false
+			errorMap[field.tag] = errMsg
+		} else {
+			*field.value = gf
+		}
+	}
+	if errorMap[PlanGFLowTag] == "" && errorMap[PlanGFHighTag] == "" && plan.GFLow > plan.GFHigh {
+		ok = false
+		errorMap[PlanGFHighTag] = "Gradient factor high must not be lower than gradient factor low."
+	}
+
+	if sac, errMsg := validateSACInput(r.FormValue(PlanSACTag)); errMsg != "" {
+		ok = false
+		errorMap[PlanSACTag] = errMsg
+	} else {
+		plan.SAC = sac
+	}
+
+	if reserve := r.FormValue(PlanReserveTag); slices.Contains(ReserveRules, reserve) {
+		plan.Reserve = reserve
+	} else {
+		ok = false
+		errorMap[PlanReserveTag] = "Please select a reserve rule."
+	}
+
+	if ok {
+		if errMsg := validateProfileGasesInput(plan.Compute().Profile, plan.Mixes()); errMsg != "" {
+			ok = false
+			errorMap[PlanSegmentsTag] = errMsg
+		}
+	}
+
+	return
+}
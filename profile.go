@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	DescentRate = 18.0 // m/min
+	AscentRate  = 9.0  // m/min
+)
+
+var ErrInvalidProfile = errors.New("invalid dive profile")
+
+// ProfileSample is a single point of a dive profile, as recorded by a dive computer. Attribute names are short
+// because a profile can have hundreds of samples.
+type ProfileSample struct {
+	T int     `json:"t"`           // seconds since the start of the dive
+	D float32 `json:"d"`           // depth in meters
+	G int     `json:"g,omitempty"` // switch to the gas with this number (1-based index into `DiveRecord.Gases`), or 0
+}
+
+// Minutes returns the time of the sample in (fractional) minutes.
+func (s ProfileSample) Minutes() float64 {
+	return float64(s.T) / 60
+}
+
+// ParseProfile parses a dive profile, one sample per line: time since the start of the dive ("mm:ss", or whole
+// minutes), depth in meters and, optionally, the number of the gas switched to (e.g. "23:30 21.0 2").
+func ParseProfile(str string) ([]ProfileSample, error) {
+	var profile []ProfileSample
+	for n, line := range strings.Split(str, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) > 3 {
+			return nil, fmt.Errorf("%w: line %d has too many values", ErrInvalidProfile, n+1)
+		}
+
+		var (
+			sample ProfileSample
+			err    error
+		)
+		if sample.T, err = parseProfileTime(fields[0]); err != nil {
+			return nil, fmt.Errorf("%w: line %d: invalid time %q", ErrInvalidProfile, n+1, fields[0])
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("%w: line %d: depth is missing", ErrInvalidProfile, n+1)
+		}
+		depth, err := strconv.ParseFloat(fields[1], 32)
+		if err != nil || depth < 0 || depth > 350 {
+			return nil, fmt.Errorf("%w: line %d: invalid depth %q", ErrInvalidProfile, n+1, fields[1])
+		}
+		sample.D = float32(depth)
+		if len(fields) == 3 {
+			if sample.G, err = strconv.Atoi(fields[2]); err != nil || sample.G < 1 {
+				return nil, fmt.Errorf("%w: line %d: invalid gas number %q", ErrInvalidProfile, n+1, fields[2])
+			}
+		}
+		if len(profile) > 0 && sample.T <= profile[len(profile)-1].T {
+			return nil, fmt.Errorf("%w: line %d: samples must be in chronological order", ErrInvalidProfile, n+1)
+		}
+		profile = append(profile, sample)
+	}
+	return profile, nil
+}
+
+func parseProfileTime(str string) (int, error) {
+	if minStr, secStr, found := strings.Cut(str, ":"); found {
+		mins, err := strconv.Atoi(minStr)
+		if err != nil || mins < 0 {
+			return 0, ErrInvalidProfile
+		}
+		secs, err := strconv.Atoi(secStr)
+		if err != nil || secs < 0 || secs > 59 {
+			return 0, ErrInvalidProfile
+		}
+		return mins*60 + secs, nil
+	}
+	mins, err := strconv.Atoi(str)
+	if err != nil || mins < 0 {
+		return 0, ErrInvalidProfile
+	}
+	return mins * 60, nil
+}
+
+// FormatProfile returns the profile in the format accepted by `ParseProfile`.
+func FormatProfile(profile []ProfileSample) string {
+	var builder strings.Builder
+	for _, sample := range profile {
+		fmt.Fprintf(&builder, "%d:%02d %s", sample.T/60, sample.T%60, strconv.FormatFloat(float64(sample.D), 'f', -1, 32))
+		if sample.G > 0 {
+			fmt.Fprintf(&builder, " %d", sample.G)
+		}
+		builder.WriteString("\n")
+	}
+	return builder.String()
+}
+
+// SquareProfile returns an approximate profile of a dive for which only the maximum depth and duration are known:
+// descent to the maximum depth, bottom time and a direct ascent, at the usual rates.
+func SquareProfile(maxDepth float32, minutes float64) []ProfileSample {
+	descent := float64(maxDepth) / DescentRate
+	ascent := float64(maxDepth) / AscentRate
+	if descent+ascent > minutes {
+		scale := minutes / (descent + ascent)
+		descent, ascent = descent*scale, ascent*scale
+	}
+	return []ProfileSample{
+		{T: 0, D: 0},
+		{T: int(descent * 60), D: maxDepth},
+		{T: int((minutes - ascent) * 60), D: maxDepth},
+		{T: int(minutes * 60), D: 0},
+	}
+}
+
+// Profile returns the recorded profile of the dive or, if there isn't one, an approximate one (see `SquareProfile`).
+// If neither is available, nil is returned.
+func (d *Dive) Profile() []ProfileSample {
+	if len(d.Data.Profile) > 1 {
+		return d.Data.Profile
+	}
+	if d.Data.MaxDepth > 0 && d.Data.Duration.Value() > 0 {
+		return SquareProfile(d.Data.MaxDepth, d.Data.Duration.Minutes())
+	}
+	return nil
+}
+
+// BreathingGases returns gases used on the dive. If none were recorded, the dive is assumed to be on air.
+func (dr *DiveRecord) BreathingGases() []GasMix {
+	if len(dr.Gases) == 0 {
+		return []GasMix{Air}
+	}
+	return dr.Gases
+}
+
+// WalkProfile calls segment for every segment of the profile, between two consecutive samples: depth changing
+// linearly from startDepth to endDepth, over minutes, while breathing gas; next is the gas breathed from the end of
+// the segment on. Gas switches in the profile refer to gases by 1-based index; if there are no gases, air is assumed.
+func WalkProfile(profile []ProfileSample, gases []GasMix, segment func(startDepth, endDepth, minutes float64, gas, next GasMix)) {
+	if len(gases) == 0 {
+		gases = []GasMix{Air}
+	}
+	var (
+		gas       = gases[0]
+		lastDepth = 0.0
+		lastTime  = 0.0
+	)
+	for _, sample := range profile {
+		next := gas
+		if sample.G > 0 && sample.G <= len(gases) {
+			next = gases[sample.G-1]
+		}
+		segment(lastDepth, float64(sample.D), sample.Minutes()-lastTime, gas, next)
+		gas, lastDepth, lastTime = next, float64(sample.D), sample.Minutes()
+	}
+}
+
+// ProfileDepths returns the maximum depth and time-weighted average depth of a profile.
+func ProfileDepths(profile []ProfileSample) (maxDepth float32, avgDepth float32) {
+	var area float64
+	for i, sample := range profile {
+		maxDepth = max(maxDepth, sample.D)
+		if i > 0 {
+			prev := profile[i-1]
+			area += float64(prev.D+sample.D) / 2 * float64(sample.T-prev.T)
+		}
+	}
+	if len(profile) > 1 && profile[len(profile)-1].T > profile[0].T {
+		avgDepth = float32(area / float64(profile[len(profile)-1].T-profile[0].T))
+	}
+	return
+}
+
+// FormattedProfile returns the recorded profile of the dive in the format accepted by `ParseProfile`.
+func (dr *DiveRecord) FormattedProfile() string {
+	return FormatProfile(dr.Profile)
+}
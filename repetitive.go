@@ -0,0 +1,194 @@
+package main
+
+import (
+	"time"
+)
+
+const (
+	// RepetitiveWindow is how long residual nitrogen is tracked after a dive. After that long at the surface, tissues
+	// are considered desaturated: the dive no longer loads tissues on later dives.
+	RepetitiveWindow = 48 * time.Hour
+
+	// FlightCabinPressure is the lowest pressure in the cabin of a commercial aircraft (2400 m altitude).
+	FlightCabinPressure = 0.75 // bar
+
+	// Minimum no-fly times recommended by DAN, regardless of the decompression model.
+	NoFlySingleDive     = 12 * time.Hour
+	NoFlyRepetitiveDive = 18 * time.Hour
+	NoFlyDecoDive       = 24 * time.Hour
+)
+
+// Repetitive relates a dive to the dives before it: surface interval, position within the day, and the residual
+// inert gas carried over into the dive.
+type Repetitive struct {
+	Previous        *Dive         // previous dive, if it was within `RepetitiveWindow`
+	SurfaceInterval time.Duration // time at the surface since the previous dive, if there is one
+	DayDive         int           // 1-based position of the dive among dives on the same day
+	DayDives        int           // number of dives on the same day
+	Residual        *Tissues      // tissue state at the start of the dive, or nil if tissues were desaturated
+	End             *Tissues      // tissue state at the end of the dive, or nil if the dive has no (approximate) profile
+	NoFly           time.Duration // how long to wait after the dive before flying
+}
+
+// NoFly holds the no-fly status after the last logged dive.
+type NoFly struct {
+	LastDive *Dive
+	Until    time.Time
+}
+
+// LoadProfile updates tissues for the whole dive profile (see `WalkProfile`).
+func (t *Tissues) LoadProfile(profile []ProfileSample, gases []GasMix) {
+	WalkProfile(profile, gases, func(startDepth, endDepth, minutes float64, gas, _ GasMix) {
+		t.Load(startDepth, endDepth, minutes, gas)
+	})
+}
+
+// Surface updates tissues for time spent at the surface, breathing air.
+func (t *Tissues) Surface(interval time.Duration) {
+	t.Load(0, 0, interval.Minutes(), Air)
+}
+
+// FlightTime returns how long tissues need to off-gas at the surface before the cabin pressure of an aircraft is
+// tolerated with gradient factor gf, rounded up to a minute.
+func (t *Tissues) FlightTime(gf float64) time.Duration {
+	tissues := *t
+	for minutes := 0; minutes < int(RepetitiveWindow.Minutes()); minutes++ {
+		if tissues.ToleratedAmbient(gf) <= FlightCabinPressure {
+			return time.Duration(minutes) * time.Minute
+		}
+		tissues.Load(0, 0, 1, Air)
+	}
+	return RepetitiveWindow
+}
+
+// MaxLoading returns the loading of the leading compartment, in % of its M-value at the surface.
+func (t *Tissues) MaxLoading() int {
+	maxLoading := 0.0
+	for _, loading := range t.Loading() {
+		maxLoading = max(maxLoading, loading)
+	}
+	return int(maxLoading + 0.5)
+}
+
+// SurfaceIntervalBetween returns the time at the surface between two dives, which is zero if dives overlap.
+func SurfaceIntervalBetween(prev *Dive, next *Dive) time.Duration {
+	return max(0, next.DateTimeIn.Sub(prev.TimeOut()))
+}
+
+func sameDay(a time.Time, b time.Time) bool {
+	return a.Year() == b.Year() && a.YearDay() == b.YearDay()
+}
+
+// Repetitive returns the relation of the dive to the dives before it (see `Repetitive`). Residual inert gas is
+// carried over from dives that ended within `RepetitiveWindow` before the dive. Results are cached until the log
+// changes, and must not be modified.
+func (dl *DiveLog) Repetitive(dive *Dive) *Repetitive {
+	dl.derived.Lock()
+	rep := dl.derived.repetitive[dive]
+	dl.derived.Unlock()
+	if rep != nil {
+		return rep
+	}
+
+	rep = dl.repetitive(dive)
+	dl.derived.Lock()
+	if dl.derived.repetitive == nil {
+		dl.derived.repetitive = make(map[*Dive]*Repetitive)
+	}
+	dl.derived.repetitive[dive] = rep
+	dl.derived.Unlock()
+	return rep
+}
+
+func (dl *DiveLog) repetitive(dive *Dive) *Repetitive {
+	var (
+		rep   = &Repetitive{DayDive: 1, DayDives: 1}
+		model = DefaultDecoModel()
+		first = dive.ix
+	)
+	if first < 0 || first >= len(dl.sorted) || dl.sorted[first] != dive {
+		return rep // not in the log
+	}
+
+	for i := dive.ix - 1; i >= 0 && sameDay(dl.sorted[i].DateTimeIn, dive.DateTimeIn); i-- {
+		rep.DayDive++
+		rep.DayDives++
+	}
+	for i := dive.ix + 1; i < len(dl.sorted) && sameDay(dl.sorted[i].DateTimeIn, dive.DateTimeIn); i++ {
+		rep.DayDives++
+	}
+
+	for first > 0 && SurfaceIntervalBetween(dl.sorted[first-1], dive) < RepetitiveWindow {
+		first--
+	}
+	if first < dive.ix {
+		rep.Previous = dl.sorted[dive.ix-1]
+		rep.SurfaceInterval = SurfaceIntervalBetween(rep.Previous, dive)
+	}
+
+	var tissues *Tissues
+	for i := first; i <= dive.ix; i++ {
+		if i > first && tissues != nil {
+			tissues.Surface(SurfaceIntervalBetween(dl.sorted[i-1], dl.sorted[i]))
+		}
+		if i == dive.ix {
+			rep.Residual = tissues
+		}
+		if profile := dl.sorted[i].Profile(); profile != nil {
+			start := SurfaceTissues()
+			if tissues != nil {
+				*start = *tissues
+			}
+			start.LoadProfile(profile, dl.sorted[i].Data.BreathingGases())
+			tissues = start
+		}
+	}
+	if rep.Residual != nil {
+		residual := *rep.Residual
+		rep.Residual = &residual
+	}
+	rep.End = tissues
+
+	rep.NoFly = NoFlySingleDive
+	if rep.Previous != nil || rep.DayDives > 1 {
+		rep.NoFly = NoFlyRepetitiveDive
+	}
+	if dive.Data.DecoDive || (rep.End != nil && rep.End.Ceiling(model.GFHigh) > 0) {
+		rep.NoFly = NoFlyDecoDive
+	}
+	if rep.End != nil {
+		rep.NoFly = max(rep.NoFly, rep.End.FlightTime(model.GFHigh))
+	}
+	return rep
+}
+
+// NoFlyStatus returns the no-fly status after the last dive, or nil if it is safe to fly at the given time.
+func (dl *DiveLog) NoFlyStatus(now time.Time) *NoFly {
+	if len(dl.sorted) == 0 {
+		return nil
+	}
+	last := dl.sorted[len(dl.sorted)-1]
+	if until := last.TimeOut().Add(dl.Repetitive(last).NoFly); now.Before(until) {
+		return &NoFly{LastDive: last, Until: until}
+	}
+	return nil
+}
+
+// FormattedSurfaceInterval returns the surface interval in hours and minutes, or an empty string if there is no
+// previous dive within `RepetitiveWindow`.
+func (r *Repetitive) FormattedSurfaceInterval() string {
+	if r.Previous == nil {
+		return ""
+	}
+	return FormatHoursMinutes(r.SurfaceInterval)
+}
+
+// FormattedNoFly returns the no-fly time in hours and minutes.
+func (r *Repetitive) FormattedNoFly() string {
+	return FormatHoursMinutes(r.NoFly)
+}
+
+// Remaining returns the time left until it is safe to fly, in hours and minutes.
+func (n *NoFly) Remaining() string {
+	return FormatHoursMinutes(time.Until(n.Until).Round(time.Minute))
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"html/template"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Relative weights of indexed dive record fields. A term found in the site name is a stronger signal than the same
+// term found somewhere in a note.
+const (
+	SiteFieldWeight = 3.0
+	GeoFieldWeight  = 2.0
+	NoteFieldWeight = 1.0
+	TagFieldWeight  = 2.0
+
+	prefixMatchPenalty = 0.5 // score multiplier for terms matched only by prefix
+	snippetContext     = 60  // number of runes of context shown around the first match in a note
+)
+
+// foldTable maps runes with diacritics to their plain ASCII counterparts, so that a query "ciganlija" matches the
+// site "Ada Čiganlija" and vice versa. Only letters that show up in site names (Latin scripts) are covered.
+var foldTable = map[rune]string{
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'ā': "a", 'ă': "a", 'ą': "a",
+	'æ': "ae",
+	'ç': "c", 'ć': "c", 'č': "c", 'ĉ': "c", 'ċ': "c",
+	'ď': "d", 'đ': "dj", 'ð': "d",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ē': "e", 'ė': "e", 'ę': "e", 'ě': "e",
+	'ğ': "g", 'ģ': "g",
+	'ì': "i", 'í': "i", 'î': "i", 'ï': "i", 'ī': "i", 'į': "i", 'ı': "i",
+	'ķ': "k",
+	'ľ': "l", 'ł': "l", 'ļ': "l", 'ĺ': "l",
+	'ñ': "n", 'ń': "n", 'ň': "n", 'ņ': "n",
+	'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "o", 'ø': "o", 'ō': "o", 'ő': "o",
+	'œ': "oe",
+	'ŕ': "r", 'ř': "r",
+	'ś': "s", 'š': "s", 'ş': "s", 'ș': "s", 'ß': "ss",
+	'ť': "t", 'ţ': "t", 'ț': "t", 'þ': "th",
+	'ù': "u", 'ú': "u", 'û': "u", 'ü': "u", 'ū': "u", 'ů': "u", 'ű': "u", 'ų': "u",
+	'ý': "y", 'ÿ': "y",
+	'ź': "z", 'ż': "z", 'ž': "z",
+}
+
+// token is a normalized word, along with its position (in bytes) within the original text.
+type token struct {
+	term  string
+	start int
+	end   int
+}
+
+// tokenize splits text into lowercase, diacritic-free words. Anything that isn't a letter or a digit is a separator.
+func tokenize(text string) []token {
+	var (
+		tokens  []token
+		builder strings.Builder
+		start   = -1
+	)
+
+	flush := func(end int) {
+		if start >= 0 {
+			tokens = append(tokens, token{term: builder.String(), start: start, end: end})
+			builder.Reset()
+			start = -1
+		}
+	}
+
+	for i, r := range text {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			flush(i)
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+		r = unicode.ToLower(r)
+		if folded, ok := foldTable[r]; ok {
+			builder.WriteString(folded)
+		} else {
+			builder.WriteRune(r)
+		}
+	}
+	flush(len(text))
+
+	return tokens
+}
+
+// Terms returns normalized search terms found in text, in order of appearance.
+func Terms(text string) []string {
+	tokens := tokenize(text)
+	terms := make([]string, 0, len(tokens))
+	for _, t := range tokens {
+		terms = append(terms, t.term)
+	}
+	return terms
+}
+
+// SearchIndex is an in-memory inverted index over the free-text fields of dive records (site, geographic location
+// and notes). It is owned by a `DiveLog` and is not thread-safe on its own; the owner's lock protects it.
+type SearchIndex struct {
+	postings map[string]map[string]float64 // term -> dive ID -> field-weighted term frequency
+	docs     map[string][]string           // dive ID -> distinct terms, used for removal
+	terms    []string                      // sorted vocabulary, kept up to date by Add and Remove
+}
+
+// SearchHit is a single dive matched by a query, together with its relevance score.
+type SearchHit struct {
+	Dive  *Dive
+	Score float64
+}
+
+func NewSearchIndex() *SearchIndex {
+	return &SearchIndex{
+		postings: make(map[string]map[string]float64),
+		docs:     make(map[string][]string),
+	}
+}
+
+// Add indexes the dive. If the dive is already indexed, its previous entry is replaced.
+func (idx *SearchIndex) Add(dive *Dive) {
+	idx.Remove(dive.id)
+
+	weights := make(map[string]float64)
+	for _, field := range []struct {
+		text   string
+		weight float64
+	}{
+		{dive.Data.Site, SiteFieldWeight},
+		{dive.Data.Geo, GeoFieldWeight},
+		{dive.Data.Note, NoteFieldWeight},
+		{strings.Join(dive.Data.Tags, " "), TagFieldWeight},
+	} {
+		for _, term := range Terms(field.text) {
+			weights[term] += field.weight
+		}
+	}
+
+	terms := make([]string, 0, len(weights))
+	for term, weight := range weights {
+		postings, found := idx.postings[term]
+		if !found {
+			postings = make(map[string]float64)
+			idx.postings[term] = postings
+			idx.terms = slices.Insert(idx.terms, sort.SearchStrings(idx.terms, term), term)
+		}
+		postings[dive.id] = weight
+		terms = append(terms, term)
+	}
+	idx.docs[dive.id] = terms
+}
+
+// Remove drops the dive with the given ID from the index, if present.
+func (idx *SearchIndex) Remove(id string) {
+	terms, found := idx.docs[id]
+	if !found {
+		return
+	}
+	for _, term := range terms {
+		postings := idx.postings[term]
+		delete(postings, id)
+		if len(postings) == 0 {
+			delete(idx.postings, term)
+			i := sort.SearchStrings(idx.terms, term)
+			idx.terms = slices.Delete(idx.terms, i, i+1)
+		}
+	}
+	delete(idx.docs, id)
+}
+
+// Len returns the number of indexed dives.
+func (idx *SearchIndex) Len() int {
+	return len(idx.docs)
+}
+
+// expand returns all indexed terms that start with prefix. It only reads the index, so concurrent searches under the
+// owner's read lock are safe.
+func (idx *SearchIndex) expand(prefix string) []string {
+	var matched []string
+	for i := sort.SearchStrings(idx.terms, prefix); i < len(idx.terms); i++ {
+		if !strings.HasPrefix(idx.terms[i], prefix) {
+			break
+		}
+		matched = append(matched, idx.terms[i])
+	}
+	return matched
+}
+
+// Search returns IDs of dives matching every word of the query (either exactly or by prefix), mapped to their
+// relevance scores. A score is the field-weighted frequency of the matched terms in the dive, so terms found in
+// heavier fields weigh more. It doesn't depend on other dives, so it stays the same as the log changes, and cursors
+// of search results (see `Cursor`) remain valid.
+func (idx *SearchIndex) Search(query string) map[string]float64 {
+	words := Terms(query)
+	if len(words) == 0 || len(idx.docs) == 0 {
+		return nil
+	}
+
+	var scores map[string]float64
+	for _, word := range words {
+		wordScores := make(map[string]float64)
+		for _, term := range idx.expand(word) {
+			penalty := 1.0
+			if term != word {
+				penalty = prefixMatchPenalty
+			}
+			for id, weight := range idx.postings[term] {
+				wordScores[id] += weight * penalty
+			}
+		}
+
+		if scores == nil {
+			scores = wordScores
+			continue
+		}
+		for id, score := range scores {
+			if wordScore, found := wordScores[id]; found {
+				scores[id] = score + wordScore
+			} else {
+				delete(scores, id)
+			}
+		}
+	}
+
+	return scores
+}
+
+// Highlight returns text with every word that matches (exactly or by prefix) one of the query words wrapped in
+// <mark> tags. Everything else is HTML-escaped.
+func Highlight(text string, query string) template.HTML {
+	return highlight(text, Terms(query), 0, len(text))
+}
+
+// Snippet is like `Highlight`, but for long texts it returns only a fragment around the first match. If nothing
+// in text matches, an empty string is returned.
+func Snippet(text string, query string) template.HTML {
+	words := Terms(query)
+	for _, t := range tokenize(text) {
+		if !matchesAny(t.term, words) {
+			continue
+		}
+		from, to := t.start, t.end
+		for n := 0; n < snippetContext && from > 0; n++ {
+			from = prevRuneStart(text, from)
+		}
+		for n := 0; n < snippetContext && to < len(text); n++ {
+			to = nextRuneStart(text, to)
+		}
+		snippet := highlight(text, words, from, to)
+		if from > 0 {
+			snippet = "…" + snippet
+		}
+		if to < len(text) {
+			snippet += "…"
+		}
+		return snippet
+	}
+	return ""
+}
+
+func highlight(text string, words []string, from int, to int) template.HTML {
+	var (
+		builder strings.Builder
+		last    = from
+	)
+	for _, t := range tokenize(text[from:to]) {
+		if !matchesAny(t.term, words) {
+			continue
+		}
+		builder.WriteString(template.HTMLEscapeString(text[last : from+t.start]))
+		builder.WriteString("<mark>")
+		builder.WriteString(template.HTMLEscapeString(text[from+t.start : from+t.end]))
+		builder.WriteString("</mark>")
+		last = from + t.end
+	}
+	builder.WriteString(template.HTMLEscapeString(text[last:to]))
+	return template.HTML(builder.String())
+}
+
+func matchesAny(term string, words []string) bool {
+	for _, word := range words {
+		if strings.HasPrefix(term, word) {
+			return true
+		}
+	}
+	return false
+}
+
+func prevRuneStart(s string, i int) int {
+	for i--; i > 0 && !isRuneStart(s[i]); i-- {
+	}
+	return i
+}
+
+func nextRuneStart(s string, i int) int {
+	for i++; i < len(s) && !isRuneStart(s[i]); i++ {
+	}
+	return i
+}
+
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
@@ -26,5 +26,9 @@ func crash(format string, v ...any) {
 }
 
 func trace(sev logging.Severity, format string, v ...any) {
+	if server == nil { // not serving, e.g. in tests
+		fmt.Fprintf(os.Stderr, format+"\n", v...)
+		return
+	}
 	server.GetLogger().Output(sev, 2, format, v...)
 }
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Site is an entry in the dive site catalog. Dives reference sites by ID (see `DiveRecord.SiteID`).
+type Site struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`              // canonical name
+	Aliases    []string `json:"aliases,omitempty"` // other names this site was logged under
+	Region     string   `json:"region,omitempty"`
+	Country    string   `json:"country,omitempty"`
+	Position   *LatLon  `json:"position,omitempty"`
+	MaxDepth   float32  `json:"max_depth,omitempty"`
+	Conditions string   `json:"conditions,omitempty"`
+	Notes      string   `json:"notes,omitempty"`
+}
+
+// LatLon is a geographic position in decimal degrees (WGS 84).
+type LatLon struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+func (p *LatLon) String() string {
+	return strconv.FormatFloat(p.Lat, 'f', -1, 64) + ", " + strconv.FormatFloat(p.Lon, 'f', -1, 64)
+}
+
+// DistanceKm returns the great-circle distance between two positions, in kilometers.
+func (p *LatLon) DistanceKm(other *LatLon) float64 {
+	const EarthRadiusKm = 6371.0
+	lat1, lat2 := p.Lat*math.Pi/180, other.Lat*math.Pi/180
+	dLat, dLon := lat2-lat1, (other.Lon-p.Lon)*math.Pi/180
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * EarthRadiusKm * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// NewSite returns a site with the given name, and region and country extracted from a free-text geographic
+// location (e.g. "Nusa Penida, Bali, Indonesia" is region "Nusa Penida, Bali" in country "Indonesia").
+func NewSite(name string, geo string) *Site {
+	site := &Site{Name: strings.TrimSpace(name)}
+	parts := strings.Split(geo, ",")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	parts = Filter(parts, func(part string) bool { return part != "" })
+	if len(parts) > 1 {
+		site.Country = parts[len(parts)-1]
+		site.Region = strings.Join(parts[:len(parts)-1], ", ")
+	} else if len(parts) == 1 {
+		site.Region = parts[0]
+	}
+	return site
+}
+
+// Geo returns the geographic location of the site in the same free-text format used by `DiveRecord.Geo`.
+func (s *Site) Geo() string {
+	if s.Region != "" && s.Country != "" {
+		return s.Region + ", " + s.Country
+	}
+	return s.Region + s.Country
+}
+
+// Names returns the canonical name followed by all aliases.
+func (s *Site) Names() []string {
+	return append([]string{s.Name}, s.Aliases...)
+}
+
+// siteKey normalizes a site name for comparison, so that e.g. "Manta Point", "manta point " and "Manta  Point" are
+// the same site.
+func siteKey(name string) string {
+	return strings.Join(Terms(name), " ")
+}
+
+// SiteCatalog is the registry of dive sites. Like `DiveLog` (its owner), it is not thread-safe.
+type SiteCatalog struct {
+	sites  map[string]*Site
+	byName map[string]*Site // site key of every canonical name and alias -> site
+}
+
+func NewSiteCatalog() *SiteCatalog {
+	return &SiteCatalog{
+		sites:  make(map[string]*Site),
+		byName: make(map[string]*Site),
+	}
+}
+
+// All returns all sites, ordered by name.
+func (sc *SiteCatalog) All() []*Site {
+	all := make([]*Site, 0, len(sc.sites))
+	for _, site := range sc.sites {
+		all = append(all, site)
+	}
+	sort.Slice(all, func(i int, j int) bool {
+		if ki, kj := siteKey(all[i].Name), siteKey(all[j].Name); ki != kj {
+			return ki < kj
+		}
+		return all[i].ID < all[j].ID
+	})
+	return all
+}
+
+func (sc *SiteCatalog) Find(id string) *Site {
+	return sc.sites[id]
+}
+
+// Lookup returns the site known under the given name (canonical or alias), or nil.
+func (sc *SiteCatalog) Lookup(name string) *Site {
+	return sc.byName[siteKey(name)]
+}
+
+// Add inserts the site into the catalog, assigning it a unique ID derived from its name if it doesn't have one.
+func (sc *SiteCatalog) Add(site *Site) *Site {
+	if site.ID == "" || sc.sites[site.ID] != nil {
+		site.ID = UniqueSlug(site.Name, "site", func(id string) bool { return sc.sites[id] != nil })
+	}
+	sc.sites[site.ID] = site
+	sc.reindex()
+	return site
+}
+
+// Update applies changes made to a site (e.g. a renamed site or new aliases) to the name lookup.
+func (sc *SiteCatalog) Update(site *Site) {
+	sc.sites[site.ID] = site
+	sc.reindex()
+}
+
+// Remove deletes the site from the catalog.
+func (sc *SiteCatalog) Remove(id string) {
+	delete(sc.sites, id)
+	sc.reindex()
+}
+
+// ConflictingName returns the first name of site which is already used by another site in the catalog, if any.
+func (sc *SiteCatalog) ConflictingName(site *Site) string {
+	for _, name := range site.Names() {
+		if other := sc.Lookup(name); other != nil && other.ID != site.ID {
+			return name
+		}
+	}
+	return ""
+}
+
+// Duplicates returns groups of sites that are likely the same site logged under slightly different names.
+func (sc *SiteCatalog) Duplicates() [][]*Site {
+	var (
+		all    = sc.All()
+		groups [][]*Site
+		seen   = make(map[string]bool)
+	)
+	for i, site := range all {
+		if seen[site.ID] {
+			continue
+		}
+		group := []*Site{site}
+		for _, other := range all[i+1:] {
+			if !seen[other.ID] && similarSiteNames(site, other) {
+				group = append(group, other)
+				seen[other.ID] = true
+			}
+		}
+		if len(group) > 1 {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+func (sc *SiteCatalog) reindex() {
+	sc.byName = make(map[string]*Site, len(sc.sites))
+	for _, site := range sc.All() {
+		for _, name := range site.Names() {
+			if key := siteKey(name); sc.byName[key] == nil {
+				sc.byName[key] = site
+			}
+		}
+	}
+}
+
+// similarSiteNames reports whether any pair of names of two sites differs only in spacing or by a couple of typos.
+func similarSiteNames(a *Site, b *Site) bool {
+	for _, nameA := range a.Names() {
+		keyA := strings.ReplaceAll(siteKey(nameA), " ", "")
+		for _, nameB := range b.Names() {
+			keyB := strings.ReplaceAll(siteKey(nameB), " ", "")
+			maxDistance := 1
+			if min(len(keyA), len(keyB)) >= 8 {
+				maxDistance = 2
+			}
+			if keyA == keyB || levenshtein(keyA, keyB) <= maxDistance {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func levenshtein(a string, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+// ReconstructSites initializes the site catalog from a list of sites. It must be called before `Reconstruct`, so
+// dives can be linked to their sites.
+func (dl *DiveLog) ReconstructSites(sites []*Site) error {
+	catalog := NewSiteCatalog()
+	for i, site := range sites {
+		if site.ID == "" || catalog.Find(site.ID) != nil {
+			return fmt.Errorf("reconstruction failed: invalid or duplicate site ID @ %s", fmt.Sprintf("/sites/%d", i))
+		}
+		catalog.Add(site)
+	}
+	dl.sites = catalog
+	return nil
+}
+
+func (dl *DiveLog) Sites() *SiteCatalog {
+	return dl.sites
+}
+
+// SiteDives returns dives at the site with the given ID, in chronological order.
+func (dl *DiveLog) SiteDives(id string) DiveList {
+	return dl.sorted.Filter(func(dive *Dive) bool { return dive.Data.SiteID == id })
+}
+
+// AddSite inserts a new site into the catalog.
+func (dl *DiveLog) AddSite(site *Site) {
+	dl.sites.Add(site)
+
+	dl.persist()
+}
+
+// RemoveSite deletes the site from the catalog. Callers must make sure no dives reference the site.
+func (dl *DiveLog) RemoveSite(id string) {
+	dl.sites.Remove(id)
+
+	dl.persist()
+}
+
+// UpdateSite replaces a site in the catalog with its edited copy, and propagates its name and location to all dives
+// at the site. Dives keep a location entered for the dive itself.
+func (dl *DiveLog) UpdateSite(site *Site) {
+	previous := dl.sites.Find(site.ID)
+	dl.sites.Update(site)
+	for _, dive := range dl.SiteDives(site.ID) {
+		dl.touch(dive.id)
+		unlinkGeo(dive, previous)
+		dl.linkSite(dive)
+		dl.index.Add(dive)
+	}
+
+	dl.persist()
+}
+
+// MergeSites merges sources into target: dives at source sites are moved to target, names of source sites become
+// aliases of target, and source sites are removed from the catalog.
+func (dl *DiveLog) MergeSites(target *Site, sources []*Site) {
+	merged := *target
+	merged.Aliases = slices.Clone(target.Aliases)
+	for _, source := range sources {
+		if source.ID == target.ID {
+			continue
+		}
+		for _, name := range source.Names() {
+			if siteKey(name) != siteKey(merged.Name) && !slices.ContainsFunc(merged.Aliases, func(alias string) bool {
+				return siteKey(alias) == siteKey(name)
+			}) {
+				merged.Aliases = append(merged.Aliases, name)
+			}
+		}
+		merged.Region = cmp.Or(merged.Region, source.Region)
+		merged.Country = cmp.Or(merged.Country, source.Country)
+		merged.Position = cmp.Or(merged.Position, source.Position)
+		merged.MaxDepth = max(merged.MaxDepth, source.MaxDepth)
+		for _, dive := range dl.SiteDives(source.ID) {
+			dl.touch(dive.id)
+			unlinkGeo(dive, source)
+			dive.Data.SiteID = target.ID
+		}
+		dl.sites.Remove(source.ID)
+	}
+	dl.UpdateSite(&merged)
+}
+
+// linkSite makes the dive reference a site from the catalog: the one it already references, or the one known under
+// the dive's site name, or a newly added one. Site name of the dive is then taken from the catalog, and so is its
+// location, unless one was entered for the dive itself.
+func (dl *DiveLog) linkSite(dive *Dive) {
+	site := dl.sites.Find(dive.Data.SiteID)
+	if site == nil {
+		site = dl.sites.Lookup(dive.Data.Site)
+	}
+	if site == nil {
+		site = dl.sites.Add(NewSite(dive.Data.Site, dive.Data.Geo))
+	}
+
+	dive.Data.SiteID = site.ID
+	dive.Data.Site = site.Name
+	if dive.Data.Geo == "" {
+		dive.Data.Geo = site.Geo()
+	}
+}
+
+// unlinkGeo clears the location of the dive if it was taken from the site, so `linkSite` takes it from the site the
+// dive is at now. A location entered for the dive itself is kept.
+func unlinkGeo(dive *Dive, site *Site) {
+	if site != nil && dive.Data.Geo == site.Geo() {
+		dive.Data.Geo = ""
+	}
+}
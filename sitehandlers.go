@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	SiteNameTag       = "name"
+	SiteAliasesTag    = "aliases"
+	SiteRegionTag     = "region"
+	SiteCountryTag    = "country"
+	SitePositionTag   = "position"
+	SiteMaxDepthTag   = "max_depth"
+	SiteConditionsTag = "conditions"
+	SiteNotesTag      = "notes"
+
+	MergeTargetTag = "target"
+	MergeSourceTag = "source"
+)
+
+func sitesHandler(w http.ResponseWriter, r *http.Request) {
+	page := &Page{
+		Title:     "Dive Sites",
+		Summaries: make(map[string]*DiveSummary),
+	}
+
+	mlog := LogOf(r)
+	mlog.RLock()
+	defer mlog.RUnlock()
+	page.Sites = mlog.Sites().All()
+	for _, site := range page.Sites {
+		page.Summaries[site.ID] = mlog.SiteDives(site.ID).Summary()
+	}
+	page.Duplicates = mlog.Sites().Duplicates()
+
+	render("sites.html", w, r, page)
+}
+
+func siteHandler(w http.ResponseWriter, r *http.Request) {
+	mlog := LogOf(r)
+	mlog.RLock()
+	defer mlog.RUnlock()
+
+	site := mlog.Sites().Find(r.PathValue(IDTag))
+	if site == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	page := &Page{
+		Title: site.Name,
+		Site:  site,
+		Dives: mlog.SiteDives(site.ID),
+	}
+	page.Summary = DiveList(page.Dives).Summary()
+
+	render("site.html", w, r, page)
+}
+
+func newSiteHandler(w http.ResponseWriter, r *http.Request) {
+	page := &Page{
+		Title: "New Dive Site",
+		Site:  &Site{},
+	}
+	render("site.html", w, r, page)
+}
+
+func siteFormHandler(w http.ResponseWriter, r *http.Request) {
+	var (
+		page     = &Page{InputErrors: make(map[string]string)}
+		existing *Site
+	)
+
+	mlog := LogOf(r)
+	mlog.Lock()
+	defer mlog.Unlock()
+
+	if id := r.PathValue(IDTag); id != "" { // .../{id}/edit
+		if existing = mlog.Sites().Find(id); existing == nil {
+			http.NotFound(w, r)
+			return
+		}
+	} // else .../new
+
+	site, ok := parseSiteFromRequest(r, page.InputErrors)
+	if existing != nil {
+		site.ID = existing.ID
+	}
+	if ok {
+		if name := mlog.Sites().ConflictingName(site); name != "" {
+			ok = false
+			page.InputErrors[SiteNameTag] = fmt.Sprintf("Site name %q is already used by another site.", name)
+		}
+	}
+
+	if ok {
+		if existing != nil {
+			change := mlog.Begin(AccountOf(r), "Site update")
+			mlog.UpdateSite(site)
+			change.Commit()
+		} else {
+			mlog.AddSite(site)
+		}
+		http.Redirect(w, r, "/sites/"+site.ID, http.StatusFound)
+	} else { // not ok
+		page.Title = "New Dive Site"
+		if existing != nil {
+			page.Title = existing.Name
+			page.Dives = mlog.SiteDives(existing.ID)
+			page.Summary = DiveList(page.Dives).Summary()
+		}
+		page.Site = site
+		render("site.html", w, r, page)
+	}
+}
+
+func siteRemovalHandler(w http.ResponseWriter, r *http.Request) {
+	mlog := LogOf(r)
+	mlog.Lock()
+	defer mlog.Unlock()
+
+	site := mlog.Sites().Find(r.PathValue(IDTag))
+	if site == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if len(mlog.SiteDives(site.ID)) > 0 {
+		http.Error(w, "Sites with logged dives can't be deleted; merge them into another site instead.", http.StatusConflict)
+		return
+	}
+	mlog.RemoveSite(site.ID)
+
+	if r.Header.Get("HX-Trigger") == "delete-btn" {
+		http.Redirect(w, r, "/sites", http.StatusSeeOther) // 303 because this is a redirect to a DELETE request
+	} else {
+		fmt.Fprint(w, "") // this is an async call, return hypermedia in the response to client
+	}
+}
+
+// HTTPS handler that merges duplicate sites (see `DiveLog.MergeSites`).
+func siteMergeHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form data.", http.StatusBadRequest)
+		return
+	}
+
+	mlog := LogOf(r)
+	mlog.Lock()
+	defer mlog.Unlock()
+
+	target := mlog.Sites().Find(r.PostForm.Get(MergeTargetTag))
+	if target == nil {
+		http.Error(w, "Please select the site to merge into.", http.StatusBadRequest)
+		return
+	}
+	var sources []*Site
+	for _, id := range r.PostForm[MergeSourceTag] {
+		if source := mlog.Sites().Find(id); source != nil && source.ID != target.ID {
+			sources = append(sources, source)
+		}
+	}
+	if len(sources) == 0 {
+		http.Error(w, "Please select at least one site to merge.", http.StatusBadRequest)
+		return
+	}
+	change := mlog.Begin(AccountOf(r), "Site merge")
+	mlog.MergeSites(target, sources)
+	change.Commit()
+
+	http.Redirect(w, r, "/sites/"+target.ID, http.StatusFound)
+}
+
+func parseSiteFromRequest(r *http.Request, errorMap map[string]string) (site *Site, ok bool) {
+	ok = true
+	site = &Site{}
+
+	if name, errMsg := validateDiveSiteInput(r.FormValue(SiteNameTag)); errMsg != "" {
+		ok = false
+		errorMap[SiteNameTag] = errMsg
+	} else {
+		site.Name = name
+	}
+
+	for _, alias := range strings.Split(r.FormValue(SiteAliasesTag), ",") {
+		if alias = strings.TrimSpace(alias); alias != "" && siteKey(alias) != siteKey(site.Name) {
+			site.Aliases = append(site.Aliases, alias)
+		}
+	}
+
+	if value := strings.TrimSpace(r.FormValue(SitePositionTag)); value != "" {
+		if position, errMsg := validatePositionInput(value); errMsg != "" {
+			ok = false
+			errorMap[SitePositionTag] = errMsg
+		} else {
+			site.Position = position
+		}
+	}
+
+	if value := strings.TrimSpace(r.FormValue(SiteMaxDepthTag)); value != "" {
+		if depth, errMsg := validateDepthInput(value); errMsg != "" {
+			ok = false
+			errorMap[SiteMaxDepthTag] = errMsg
+		} else {
+			site.MaxDepth = depth
+		}
+	}
+
+	// Optional parameters: accept even an empty value after input is trimmed.
+	site.Region, _ = validateNonEmptyString(r.FormValue(SiteRegionTag))
+	site.Country, _ = validateNonEmptyString(r.FormValue(SiteCountryTag))
+	site.Conditions, _ = validateNonEmptyString(r.FormValue(SiteConditionsTag))
+	site.Notes, _ = validateNonEmptyString(r.FormValue(SiteNotesTag))
+
+	return
+}
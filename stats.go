@@ -0,0 +1,254 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"slices"
+	"time"
+)
+
+const (
+	// Bucket sizes of depth and duration histograms.
+	DepthBucket    = 5  // m
+	DurationBucket = 10 // min
+
+	// TopN is the number of entries in rankings, e.g. deepest dives or most visited sites.
+	TopN = 5
+)
+
+// Milestones are dive numbers worth celebrating; after the last one, every 500th dive is a milestone.
+var Milestones = []int{1, 50, 100, 200, 250, 300, 400, 500}
+
+// LogStats holds statistics of the dive log, or of dives in a date range.
+type LogStats struct {
+	Summary        *DiveSummary
+	DecoDives      int
+	PerYear        *Chart
+	PerMonth       *Chart
+	Depths         *Chart
+	Durations      *Chart
+	DecoSplit      *Chart
+	Deepest        DiveList
+	Longest        DiveList
+	TopSites       []*StatsCount
+	TopRegions     []*StatsCount
+	MilestoneDives DiveList
+}
+
+// StatsCount is the number of dives at a site or in a region.
+type StatsCount struct {
+	ID    string // ID of the site, if it's in the catalog
+	Name  string
+	Dives int
+}
+
+// IsMilestone reports whether the dive number is a milestone (see `Milestones`).
+func IsMilestone(num int) bool {
+	last := Milestones[len(Milestones)-1]
+	return slices.Contains(Milestones, num) || (num > last && num%500 == 0)
+}
+
+// Stats returns statistics of dives for which inRange returns true. Dive numbers, and therefore milestones, are
+// always relative to the whole log.
+func (dl *DiveLog) Stats(inRange func(*Dive) bool) *LogStats {
+	dives := DiveList(dl.sorted.Filter(inRange))
+	stats := &LogStats{
+		Summary:   dives.Summary(),
+		PerYear:   &Chart{Title: "Dives per year", Unit: "dives"},
+		PerMonth:  &Chart{Title: "Dives per month", Unit: "dives"},
+		Depths:    &Chart{Title: "Maximum depth", Unit: "dives"},
+		Durations: &Chart{Title: "Duration", Unit: "dives"},
+		DecoSplit: &Chart{Title: "Decompression", Unit: "dives"},
+	}
+	if len(dives) == 0 {
+		return stats
+	}
+
+	// Periods are continuous from the first to the last dive, so gaps in diving show up in charts.
+	var (
+		first  = stats.Summary.First.DateTimeIn
+		last   = stats.Summary.Last.DateTimeIn
+		years  = make(map[int]int)
+		months = make(map[string]int)
+	)
+	for _, dive := range dives {
+		years[dive.DateTimeIn.Year()]++
+		months[dive.DateTimeIn.Format("2006-01")]++
+	}
+	for year := first.Year(); year <= last.Year(); year++ {
+		stats.PerYear.Add(fmt.Sprint(year), float64(years[year]))
+	}
+	for m := time.Date(first.Year(), first.Month(), 1, 0, 0, 0, 0, time.UTC); !m.After(last); m = m.AddDate(0, 1, 0) {
+		stats.PerMonth.Add(m.Format("Jan 2006"), float64(months[m.Format("2006-01")]))
+	}
+
+	depths := make([]int, int(stats.Summary.MaxDepth)/DepthBucket+1)
+	var durations []int
+	for _, dive := range dives {
+		if dive.Data.MaxDepth > 0 {
+			depths[int(dive.Data.MaxDepth)/DepthBucket]++
+		}
+		if minutes := int(dive.Data.Duration.Minutes()); minutes > 0 {
+			bucket := minutes / DurationBucket
+			for len(durations) <= bucket {
+				durations = append(durations, 0)
+			}
+			durations[bucket]++
+		}
+		if dive.Data.DecoDive {
+			stats.DecoDives++
+		}
+	}
+	for i, n := range depths {
+		stats.Depths.Add(fmt.Sprintf("%d–%d m", i*DepthBucket, (i+1)*DepthBucket), float64(n))
+	}
+	for i, n := range durations {
+		stats.Durations.Add(fmt.Sprintf("%d–%d min", i*DurationBucket, (i+1)*DurationBucket), float64(n))
+	}
+	stats.DecoSplit.Add("Recreational", float64(len(dives)-stats.DecoDives))
+	stats.DecoSplit.Add("Decompression", float64(stats.DecoDives))
+
+	stats.Deepest = topDives(dives, func(dive *Dive) float64 { return float64(dive.Data.MaxDepth) })
+	stats.Longest = topDives(dives, func(dive *Dive) float64 { return dive.Data.Duration.Minutes() })
+
+	stats.TopSites = countDives(dives, func(dive *Dive) (string, string) {
+		if site := dl.sites.Find(dive.Data.SiteID); site != nil {
+			return site.ID, site.Name
+		}
+		return "", dive.Data.Site
+	})
+	stats.TopRegions = countDives(dives, func(dive *Dive) (string, string) {
+		if site := dl.sites.Find(dive.Data.SiteID); site != nil && site.Geo() != "" {
+			return "", site.Geo()
+		}
+		return "", dive.Data.Geo
+	})
+
+	for _, dive := range dives {
+		if IsMilestone(dive.Num()) {
+			stats.MilestoneDives = append(stats.MilestoneDives, dive)
+		}
+	}
+	return stats
+}
+
+// DecoPercent returns the share of decompression dives, in %.
+func (stats *LogStats) DecoPercent() int {
+	if stats.Summary.Dives == 0 {
+		return 0
+	}
+	return (stats.DecoDives*100 + stats.Summary.Dives/2) / stats.Summary.Dives
+}
+
+// topDives returns up to `TopN` dives with the greatest value, skipping dives where it's not known (zero). Ties keep
+// chronological order.
+func topDives(dives DiveList, value func(*Dive) float64) DiveList {
+	top := DiveList(dives.Filter(func(dive *Dive) bool { return value(dive) > 0 }))
+	slices.SortStableFunc(top, func(a, b *Dive) int { return cmp.Compare(value(b), value(a)) })
+	return top[:min(len(top), TopN)]
+}
+
+// countDives counts dives by the name returned by keyOf, skipping empty names, and returns up to `TopN` names with
+// the most dives.
+func countDives(dives DiveList, keyOf func(*Dive) (id string, name string)) []*StatsCount {
+	var (
+		counts []*StatsCount
+		byName = make(map[string]*StatsCount)
+	)
+	for _, dive := range dives {
+		id, name := keyOf(dive)
+		if name == "" {
+			continue
+		}
+		count := byName[name]
+		if count == nil {
+			count = &StatsCount{ID: id, Name: name}
+			byName[name] = count
+			counts = append(counts, count)
+		}
+		count.Dives++
+	}
+	slices.SortStableFunc(counts, func(a, b *StatsCount) int { return b.Dives - a.Dives })
+	return counts[:min(len(counts), TopN)]
+}
+
+// ClubStats holds aggregated statistics of dive logs of all accounts. It never refers to individual dives, which are
+// private to their account.
+type ClubStats struct {
+	Members  []*MemberStats
+	Dives    int
+	Total    time.Duration // bottom time
+	MaxDepth float32
+	PerYear  *Chart
+	TopSites []*StatsCount
+}
+
+// MemberStats holds statistics of the dive log of a single account.
+type MemberStats struct {
+	Account  *Account
+	Dives    int
+	Total    time.Duration // bottom time
+	MaxDepth float32
+	LastDive time.Time
+}
+
+// ClubStats returns aggregated statistics of dive logs of the accounts, opening the logs if needed.
+func (lr *LogRegistry) ClubStats(accounts []*Account) (*ClubStats, error) {
+	var (
+		stats = &ClubStats{PerYear: &Chart{Title: "Club dives per year", Unit: "dives"}}
+		all   DiveList
+		sites = make(map[*Dive]string)
+		years = make(map[int]int)
+	)
+	for _, account := range accounts {
+		mlog, err := lr.Open(account)
+		if err != nil {
+			return nil, err
+		}
+
+		mlog.RLock()
+		summary := mlog.All().Summary()
+		member := &MemberStats{Account: account, Dives: summary.Dives, Total: summary.BottomTime, MaxDepth: summary.MaxDepth}
+		if summary.Last != nil {
+			member.LastDive = summary.Last.DateTimeIn
+		}
+		for _, dive := range mlog.All() {
+			all = append(all, dive)
+			years[dive.DateTimeIn.Year()]++
+			if site := mlog.sites.Find(dive.Data.SiteID); site != nil {
+				sites[dive] = site.Name
+			} else {
+				sites[dive] = dive.Data.Site
+			}
+		}
+		mlog.RUnlock()
+
+		stats.Members = append(stats.Members, member)
+		stats.Dives += member.Dives
+		stats.Total += member.Total
+		stats.MaxDepth = max(stats.MaxDepth, member.MaxDepth)
+	}
+
+	if len(years) > 0 {
+		first, last := math.MaxInt, 0
+		for year := range years {
+			first, last = min(first, year), max(last, year)
+		}
+		for year := first; year <= last; year++ {
+			stats.PerYear.Add(fmt.Sprint(year), float64(years[year]))
+		}
+	}
+	stats.TopSites = countDives(all, func(dive *Dive) (string, string) { return "", sites[dive] })
+	return stats, nil
+}
+
+// FormattedTotal returns the total bottom time in hours and minutes.
+func (stats *ClubStats) FormattedTotal() string {
+	return FormatHoursMinutes(stats.Total)
+}
+
+// FormattedTotal returns the total bottom time in hours and minutes.
+func (stats *MemberStats) FormattedTotal() string {
+	return FormatHoursMinutes(stats.Total)
+}
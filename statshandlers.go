@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/cicovic-andrija/libgo/logging"
+)
+
+// HTTPS handler for the statistics dashboard, filterable by the same date range as the dive list.
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	page := &Page{Title: "Statistics"}
+	query, err := ParseDiveQuery(r.URL.Query()) // only the date range is used
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	mlog := LogOf(r)
+	mlog.RLock()
+	defer mlog.RUnlock()
+	page.BeforeFilter = query.Before
+	page.AfterFilter = query.After
+	page.LogStats = mlog.Stats(query.InDateRange)
+
+	render("stats.html", w, r, page)
+}
+
+// HTTPS handler for aggregated statistics of all accounts, available to admins only.
+func clubHandler(w http.ResponseWriter, r *http.Request) {
+	if !AccountOf(r).Admin {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	page := &Page{Title: "Club Statistics"}
+	stats, err := Logs.ClubStats(Users.All())
+	if err != nil {
+		trace(logging.SevError, "club stats failed: %v", err)
+		http.Error(w, "dive logs unavailable", http.StatusInternalServerError)
+		return
+	}
+	page.ClubStats = stats
+
+	render("club.html", w, r, page)
+}
+
+// HTTPS handler for gas consumption analytics: SAC and RMV per dive, trends, and dives flagged for review.
+func consumptionHandler(w http.ResponseWriter, r *http.Request) {
+	page := &Page{Title: "Gas Consumption"}
+
+	mlog := LogOf(r)
+	mlog.RLock()
+	defer mlog.RUnlock()
+	page.ConsumptionStats = mlog.ConsumptionStats()
+
+	render("consumption.html", w, r, page)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+const (
+	TagsTag     = "tags"
+	TagQueryTag = "tag"
+
+	MaxTagLength  = 32
+	TagCloudSizes = 5 // number of distinct sizes of tags in the tag cloud
+)
+
+var (
+	ErrInvalidTag = errors.New("invalid tag")
+
+	tagPattern = regexp.MustCompile(`^[\p{L}\p{N}][\p{L}\p{N} _-]*$`)
+)
+
+// TagCount is a tag with the number of dives tagged with it.
+type TagCount struct {
+	Name  string
+	Dives int
+	Size  int // relative size in the tag cloud, from 1 to `TagCloudSizes`
+}
+
+// NormalizeTag returns the canonical form of a tag: lower case, with spaces trimmed and collapsed.
+func NormalizeTag(tag string) string {
+	return strings.Join(strings.Fields(strings.ToLower(tag)), " ")
+}
+
+// ParseTags parses a comma-separated list of tags (e.g. "wreck, night"). Tags are normalized, and empty or duplicate
+// tags are dropped.
+func ParseTags(str string) ([]string, error) {
+	var tags []string
+	for _, part := range strings.Split(str, ",") {
+		tag := NormalizeTag(part)
+		if tag == "" || slices.Contains(tags, tag) {
+			continue
+		}
+		if len(tag) > MaxTagLength || !tagPattern.MatchString(tag) {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidTag, tag)
+		}
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+// FormattedTags returns tags of the dive in the format accepted by `ParseTags`.
+func (dr *DiveRecord) FormattedTags() string {
+	return strings.Join(dr.Tags, ", ")
+}
+
+func (dr *DiveRecord) HasTag(tag string) bool {
+	return slices.Contains(dr.Tags, tag)
+}
+
+// Tags returns all tags used in the log, in alphabetical order, with sizes for the tag cloud scaled between the least
+// and the most used tag.
+func (dl *DiveLog) Tags() []*TagCount {
+	counts := make(map[string]int)
+	for _, dive := range dl.sorted {
+		for _, tag := range dive.Data.Tags {
+			counts[tag]++
+		}
+	}
+
+	tags := make([]*TagCount, 0, len(counts))
+	least, most := 0, 0
+	for name, n := range counts {
+		tags = append(tags, &TagCount{Name: name, Dives: n})
+		if least == 0 || n < least {
+			least = n
+		}
+		most = max(most, n)
+	}
+	for _, tag := range tags {
+		tag.Size = 1
+		if most > least {
+			tag.Size += (tag.Dives - least) * (TagCloudSizes - 1) / (most - least)
+		}
+	}
+	sort.Slice(tags, func(i int, j int) bool { return tags[i].Name < tags[j].Name })
+	return tags
+}
+
+// AddTag tags the dives, unless they are already tagged.
+func (dl *DiveLog) AddTag(tag string, dives DiveList) {
+	for _, dive := range dives {
+		if !dive.Data.HasTag(tag) {
+			dl.touch(dive.id)
+			dive.Data.Tags = append(dive.Data.Tags, tag)
+			dl.index.Add(dive)
+		}
+	}
+
+	dl.persist()
+}
+
+// CompleteTags returns completions of the last tag of a comma-separated list of tags, from tags used in the log. Each
+// completion is the whole list, with the last tag completed.
+func (dl *DiveLog) CompleteTags(input string) []string {
+	var (
+		prefix, last = "", input
+		completions  = make([]string, 0)
+	)
+	if i := strings.LastIndex(input, ","); i >= 0 {
+		prefix, last = strings.TrimSpace(input[:i])+", ", input[i+1:]
+	}
+	entered, _ := ParseTags(prefix)
+	last = NormalizeTag(last)
+	for _, tag := range dl.Tags() {
+		if strings.HasPrefix(tag.Name, last) && !slices.Contains(entered, tag.Name) {
+			completions = append(completions, prefix+tag.Name)
+		}
+	}
+	return completions
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+)
+
+// HTTPS handler for the tag cloud: tags used in the log, sized by the number of dives tagged with them.
+func tagsHandler(w http.ResponseWriter, r *http.Request) {
+	mlog := LogOf(r)
+	mlog.RLock()
+	defer mlog.RUnlock()
+
+	page := &Page{Title: "Tags", Tags: mlog.Tags()}
+	render("tags.html", w, r, page)
+}
+
+// tagCompletionHandler responds with options of the tag editor's datalist: the tags entered so far, with the last one
+// completed from tags used in the log.
+func tagCompletionHandler(w http.ResponseWriter, r *http.Request) {
+	mlog := LogOf(r)
+	mlog.RLock()
+	defer mlog.RUnlock()
+
+	for _, completion := range mlog.CompleteTags(r.URL.Query().Get(TagsTag)) {
+		fmt.Fprintf(w, "<option value=\"%s\">\n", html.EscapeString(completion))
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	// TrashRetention is how long deleted dives are kept in the trash before they are purged.
+	TrashRetention = 30 * 24 * time.Hour
+
+	UndoTag = "undo"
+)
+
+// TrashedDive is a deleted dive, kept in the trash until restored or purged.
+type TrashedDive struct {
+	ID        string      `json:"id"`
+	DeletedAt string      `json:"deleted_at"` // RFC 3339
+	Record    *DiveRecord `json:"record"`
+
+	dive *Dive
+}
+
+func (td *TrashedDive) Dive() *Dive {
+	return td.dive
+}
+
+// FormattedDeletedAt returns the time the dive was deleted in the server's time zone, e.g. "2006-01-02 15:04".
+func (td *TrashedDive) FormattedDeletedAt() string {
+	deletedAt, err := time.Parse(time.RFC3339, td.DeletedAt)
+	if err != nil {
+		return td.DeletedAt
+	}
+	return deletedAt.Local().Format(DateLayout + " " + TimeLayout)
+}
+
+// PurgeAt returns the time after which the dive is purged from the trash.
+func (td *TrashedDive) PurgeAt() time.Time {
+	deletedAt, _ := time.Parse(time.RFC3339, td.DeletedAt)
+	return deletedAt.Add(TrashRetention)
+}
+
+// ReconstructTrash initializes the trash from persisted deleted dives.
+func (dl *DiveLog) ReconstructTrash(trashed []*TrashedDive) error {
+	byID := make(map[string]*TrashedDive, len(trashed))
+	for i, td := range trashed {
+		dive, err := EmptyDive().reconstructFrom(td.Record)
+		if err != nil {
+			return fmt.Errorf("reconstruction failed: %v @ %s", err, fmt.Sprintf("/trash/%d", i))
+		}
+		dive.id = td.ID
+		td.dive = dive
+		byID[td.ID] = td
+	}
+	dl.trash = byID
+	return nil
+}
+
+// Trashed returns dives in the trash, the most recently deleted first.
+func (dl *DiveLog) Trashed() []*TrashedDive {
+	trashed := make([]*TrashedDive, 0, len(dl.trash))
+	for _, td := range dl.trash {
+		trashed = append(trashed, td)
+	}
+	sort.Slice(trashed, func(i int, j int) bool {
+		if trashed[i].DeletedAt != trashed[j].DeletedAt {
+			return trashed[i].DeletedAt > trashed[j].DeletedAt
+		}
+		return trashed[i].ID < trashed[j].ID
+	})
+	return trashed
+}
+
+// FindTrashed returns the dive with the given ID from the trash, or nil.
+func (dl *DiveLog) FindTrashed(id string) *TrashedDive {
+	return dl.trash[id]
+}
+
+// Trash moves the dive with the given ID to the trash, and purges dives kept in the trash for longer than
+// `TrashRetention`.
+func (dl *DiveLog) Trash(id string, now time.Time) (found bool) {
+	dive := dl.dives[id]
+	if dive == nil {
+		return false
+	}
+	dl.Delete(id)
+	dl.trash[id] = &TrashedDive{
+		ID:        id,
+		DeletedAt: now.UTC().Format(time.RFC3339),
+		Record:    dive.Data,
+		dive:      dive,
+	}
+	dl.purgeExpired(now)
+
+	dl.persist()
+	return true
+}
+
+// Restore moves the dive with the given ID from the trash back to the log, where it is numbered again by its date
+// and time.
+func (dl *DiveLog) Restore(id string) (*Dive, error) {
+	td := dl.trash[id]
+	if td == nil {
+		return nil, fmt.Errorf("dive %s is not in the trash", id)
+	}
+	if dl.dives[id] != nil {
+		return nil, fmt.Errorf("another dive %s is in the log", id)
+	}
+	delete(dl.trash, id)
+	dl.Insert(td.dive)
+	return td.dive, nil
+}
+
+// Purge permanently deletes the dive with the given ID from the trash.
+func (dl *DiveLog) Purge(id string) (found bool) {
+	var td *TrashedDive
+	if td, found = dl.trash[id]; found {
+		delete(dl.trash, id)
+		dl.pruneAttachments(td.Record.attachmentHashes()...)
+		dl.persist()
+	}
+	return
+}
+
+func (dl *DiveLog) purgeExpired(now time.Time) {
+	for id, td := range dl.trash {
+		if now.After(td.PurgeAt()) {
+			delete(dl.trash, id)
+			dl.pruneAttachments(td.Record.attachmentHashes()...)
+		}
+	}
+}
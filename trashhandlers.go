@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/cicovic-andrija/libgo/logging"
+)
+
+// HTTPS handler for the trash: deleted dives that can be restored until they are purged.
+func trashHandler(w http.ResponseWriter, r *http.Request) {
+	mlog := LogOf(r)
+	mlog.RLock()
+	defer mlog.RUnlock()
+
+	page := &Page{Title: "Trash", Trash: mlog.Trashed()}
+	render("trash.html", w, r, page)
+}
+
+// HTTPS handler that restores a dive from the trash. Undoing a delete from the dive list responds with the list
+// showing just the restored dive, so the client can swap its row back in place; otherwise, it goes back to the trash.
+func diveRestoreHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue(IDTag)
+	mlog := LogOf(r)
+	mlog.Lock()
+	defer mlog.Unlock()
+
+	if mlog.FindTrashed(id) == nil {
+		http.NotFound(w, r)
+		return
+	}
+	change := mlog.Begin(AccountOf(r), "Restored from trash")
+	dive, err := mlog.Restore(id)
+	change.Commit()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	trace(logging.SevInfo, "dive %s of %s restored from trash", id, AccountOf(r).Username)
+
+	if r.FormValue(UndoTag) != "true" {
+		http.Redirect(w, r, "/trash", http.StatusSeeOther)
+		return
+	}
+	page := &Page{
+		Title:       "Dives",
+		Dives:       []*Dive{dive},
+		Repetitives: map[string]*Repetitive{dive.ID(): mlog.Repetitive(dive)},
+		Total:       1,
+	}
+	render("dives.html", w, r, page)
+}
+
+// HTTPS handler that permanently deletes a dive from the trash.
+func divePurgeHandler(w http.ResponseWriter, r *http.Request) {
+	mlog := LogOf(r)
+	mlog.Lock()
+	defer mlog.Unlock()
+
+	if !mlog.Purge(r.PathValue(IDTag)) {
+		http.NotFound(w, r)
+		return
+	}
+	fmt.Fprint(w, "") // this is an async call, return hypermedia in the response to client
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	// Dives are suggested as a trip when there are at least TripMinDives of them, each one starting no later than
+	// TripMaxGap after the previous one ended, at sites no farther than TripMaxDistanceKm apart.
+	TripMinDives      = 4
+	TripMaxGap        = 36 * time.Hour
+	TripMaxDistanceKm = 150
+)
+
+// Trip is a group of dives made during a single expedition, e.g. a liveaboard or a week at a dive resort. Dives
+// reference trips by ID (see `DiveRecord.TripID`).
+type Trip struct {
+	ID           string   `json:"id"`
+	Name         string   `json:"name"`
+	StartDate    string   `json:"start_date"` // DateLayout
+	EndDate      string   `json:"end_date"`   // DateLayout, inclusive
+	Operator     string   `json:"operator,omitempty"`
+	Location     string   `json:"location,omitempty"`
+	Participants []string `json:"participants,omitempty"`
+}
+
+// TripSuggestion is a cluster of dives not assigned to any trip, which were made close in time and place.
+type TripSuggestion struct {
+	Name     string
+	Location string
+	Dives    DiveList
+}
+
+// Start returns the first day of the trip.
+func (t *Trip) Start() time.Time {
+	start, _ := time.Parse(DateLayout, t.StartDate)
+	return start
+}
+
+// End returns the last day of the trip.
+func (t *Trip) End() time.Time {
+	end, _ := time.Parse(DateLayout, t.EndDate)
+	return end
+}
+
+// Days returns the number of days the trip lasted.
+func (t *Trip) Days() int {
+	return int(t.End().Sub(t.Start()).Hours()/24) + 1
+}
+
+// Contains reports whether the dive was made during the trip.
+func (t *Trip) Contains(dive *Dive) bool {
+	return !dive.DateTimeIn.Before(t.Start()) && dive.DateTimeIn.Before(t.End().AddDate(0, 0, 1))
+}
+
+func (s *TripSuggestion) StartDate() string {
+	return dateToStr(s.Dives[0].DateTimeIn)
+}
+
+func (s *TripSuggestion) EndDate() string {
+	return dateToStr(s.Dives[len(s.Dives)-1].DateTimeIn)
+}
+
+// Trips returns all trips, most recent first.
+func (dl *DiveLog) Trips() []*Trip {
+	trips := make([]*Trip, 0, len(dl.trips))
+	for _, trip := range dl.trips {
+		trips = append(trips, trip)
+	}
+	sort.Slice(trips, func(i int, j int) bool {
+		if trips[i].StartDate != trips[j].StartDate {
+			return trips[i].StartDate > trips[j].StartDate
+		}
+		return trips[i].ID < trips[j].ID
+	})
+	return trips
+}
+
+func (dl *DiveLog) FindTrip(id string) *Trip {
+	return dl.trips[id]
+}
+
+// TripDives returns dives assigned to the trip with the given ID, in chronological order.
+func (dl *DiveLog) TripDives(id string) DiveList {
+	return dl.sorted.Filter(func(dive *Dive) bool { return dive.Data.TripID == id })
+}
+
+// ReconstructTrips initializes trips from a list of trips. It must be called before `Reconstruct`, so dives can be
+// linked to their trips.
+func (dl *DiveLog) ReconstructTrips(trips []*Trip) error {
+	byID := make(map[string]*Trip, len(trips))
+	for i, trip := range trips {
+		if trip.ID == "" || byID[trip.ID] != nil {
+			return fmt.Errorf("reconstruction failed: invalid or duplicate trip ID @ %s", fmt.Sprintf("/trips/%d", i))
+		}
+		byID[trip.ID] = trip
+	}
+	dl.trips = byID
+	return nil
+}
+
+// AddTrip inserts a new trip, assigning it a unique ID, and assigns dives to it.
+func (dl *DiveLog) AddTrip(trip *Trip, dives DiveList) {
+	trip.ID = UniqueSlug(trip.Name, "trip", func(id string) bool { return dl.trips[id] != nil })
+	dl.trips[trip.ID] = trip
+	for _, dive := range dives {
+		dl.touch(dive.id)
+		dive.Data.TripID = trip.ID
+	}
+
+	dl.persist()
+}
+
+// UpdateTrip replaces the trip data, keeping its ID and assigned dives.
+func (dl *DiveLog) UpdateTrip(existing *Trip, trip *Trip) {
+	trip.ID = existing.ID
+	*existing = *trip
+
+	dl.persist()
+}
+
+// RemoveTrip deletes the trip. Dives assigned to it are kept, but are no longer assigned to any trip.
+func (dl *DiveLog) RemoveTrip(id string) {
+	for _, dive := range dl.TripDives(id) {
+		dl.touch(dive.id)
+		dive.Data.TripID = ""
+	}
+	delete(dl.trips, id)
+
+	dl.persist()
+}
+
+// AssignTrip assigns dives to the trip with the given ID, or unassigns them from their trips if id is empty.
+func (dl *DiveLog) AssignTrip(id string, dives DiveList) {
+	for _, dive := range dives {
+		dl.touch(dive.id)
+		dive.Data.TripID = id
+	}
+
+	dl.persist()
+}
+
+// SuggestTrips returns clusters of dives not assigned to any trip that look like trips (see TripMinDives).
+func (dl *DiveLog) SuggestTrips() []*TripSuggestion {
+	var (
+		suggestions []*TripSuggestion
+		cluster     DiveList
+	)
+
+	flush := func() {
+		if len(cluster) >= TripMinDives {
+			site := dl.sites.Find(cluster[0].Data.SiteID)
+			location := cluster[0].Data.Geo
+			if site != nil && site.Country != "" {
+				location = site.Country
+			}
+			suggestions = append(suggestions, &TripSuggestion{
+				Name:     fmt.Sprintf("%s %s", location, cluster[0].DateTimeIn.Format("January 2006")),
+				Location: location,
+				Dives:    cluster,
+			})
+		}
+		cluster = nil
+	}
+
+	for _, dive := range dl.sorted {
+		if dive.Data.TripID != "" {
+			flush()
+			continue
+		}
+		if len(cluster) > 0 {
+			last := cluster[len(cluster)-1]
+			if dive.DateTimeIn.Sub(last.TimeOut()) > TripMaxGap || !dl.nearby(last, dive) {
+				flush()
+			}
+		}
+		cluster = append(cluster, dive)
+	}
+	flush()
+
+	return suggestions
+}
+
+// nearby reports whether two dives were made close to each other. If positions of both sites are known, they are
+// compared, otherwise sites have to be in the same country (or the same location, if the country is unknown).
+func (dl *DiveLog) nearby(a *Dive, b *Dive) bool {
+	siteA, siteB := dl.sites.Find(a.Data.SiteID), dl.sites.Find(b.Data.SiteID)
+	if siteA == nil || siteB == nil {
+		return a.Data.Geo == b.Data.Geo
+	}
+	if siteA.ID == siteB.ID {
+		return true
+	}
+	if siteA.Position != nil && siteB.Position != nil {
+		return siteA.Position.DistanceKm(siteB.Position) <= TripMaxDistanceKm
+	}
+	if siteA.Country != "" || siteB.Country != "" {
+		return siteA.Country == siteB.Country
+	}
+	return siteA.Geo() == siteB.Geo()
+}
+
+// linkTrip clears the dive's reference to a trip if the trip doesn't exist.
+func (dl *DiveLog) linkTrip(dive *Dive) {
+	if dive.Data.TripID != "" && dl.trips[dive.Data.TripID] == nil {
+		dive.Data.TripID = ""
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	TripNameTag         = "name"
+	TripStartDateTag    = "start_date"
+	TripEndDateTag      = "end_date"
+	TripOperatorTag     = "operator"
+	TripLocationTag     = "location"
+	TripParticipantsTag = "participants"
+	TripDiveTag         = "dive"
+)
+
+func tripsHandler(w http.ResponseWriter, r *http.Request) {
+	page := &Page{
+		Title:     "Trips",
+		Summaries: make(map[string]*DiveSummary),
+	}
+
+	mlog := LogOf(r)
+	mlog.RLock()
+	defer mlog.RUnlock()
+	page.Trips = mlog.Trips()
+	for _, trip := range page.Trips {
+		page.Summaries[trip.ID] = mlog.TripDives(trip.ID).Summary()
+	}
+	page.TripSuggestions = mlog.SuggestTrips()
+
+	render("trips.html", w, r, page)
+}
+
+func tripHandler(w http.ResponseWriter, r *http.Request) {
+	mlog := LogOf(r)
+	mlog.RLock()
+	defer mlog.RUnlock()
+
+	trip := mlog.FindTrip(r.PathValue(IDTag))
+	if trip == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	page := &Page{
+		Title: trip.Name,
+		Trip:  trip,
+		Dives: mlog.TripDives(trip.ID),
+	}
+	page.Summary = DiveList(page.Dives).Summary()
+	page.Unassigned = mlog.All().Filter(func(dive *Dive) bool { return dive.Data.TripID == "" && trip.Contains(dive) })
+
+	render("trip.html", w, r, page)
+}
+
+func newTripHandler(w http.ResponseWriter, r *http.Request) {
+	page := &Page{
+		Title: "New Trip",
+		Trip:  &Trip{},
+	}
+	render("trip.html", w, r, page)
+}
+
+func tripFormHandler(w http.ResponseWriter, r *http.Request) {
+	var (
+		page     = &Page{InputErrors: make(map[string]string)}
+		existing *Trip
+	)
+
+	mlog := LogOf(r)
+	mlog.Lock()
+	defer mlog.Unlock()
+
+	if id := r.PathValue(IDTag); id != "" { // .../{id}/edit
+		if existing = mlog.FindTrip(id); existing == nil {
+			http.NotFound(w, r)
+			return
+		}
+	} // else .../new
+
+	if trip, ok := parseTripFromRequest(r, page.InputErrors); ok {
+		if existing != nil {
+			mlog.UpdateTrip(existing, trip)
+		} else {
+			change := mlog.Begin(AccountOf(r), "Trip creation")
+			mlog.AddTrip(trip, findDives(mlog, r.Form[TripDiveTag]))
+			change.Commit()
+		}
+		http.Redirect(w, r, "/trips/"+trip.ID, http.StatusFound)
+	} else { // not ok
+		page.Title = "New Trip"
+		if existing != nil {
+			page.Title = existing.Name
+			trip.ID = existing.ID
+			page.Dives = mlog.TripDives(existing.ID)
+			page.Summary = DiveList(page.Dives).Summary()
+		}
+		page.Trip = trip
+		render("trip.html", w, r, page)
+	}
+}
+
+// HTTPS handler that assigns selected dives to a trip.
+func tripAssignmentHandler(w http.ResponseWriter, r *http.Request) {
+	mlog := LogOf(r)
+	mlog.Lock()
+	defer mlog.Unlock()
+
+	trip := mlog.FindTrip(r.PathValue(IDTag))
+	if trip == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form data.", http.StatusBadRequest)
+		return
+	}
+	change := mlog.Begin(AccountOf(r), "Trip assignment")
+	mlog.AssignTrip(trip.ID, findDives(mlog, r.PostForm[TripDiveTag]))
+	change.Commit()
+
+	http.Redirect(w, r, "/trips/"+trip.ID, http.StatusFound)
+}
+
+func tripRemovalHandler(w http.ResponseWriter, r *http.Request) {
+	mlog := LogOf(r)
+	mlog.Lock()
+	defer mlog.Unlock()
+
+	if mlog.FindTrip(r.PathValue(IDTag)) == nil {
+		http.NotFound(w, r)
+		return
+	}
+	change := mlog.Begin(AccountOf(r), "Trip removal")
+	mlog.RemoveTrip(r.PathValue(IDTag))
+	change.Commit()
+
+	if r.Header.Get("HX-Trigger") == "delete-btn" {
+		http.Redirect(w, r, "/trips", http.StatusSeeOther) // 303 because this is a redirect to a DELETE request
+	} else {
+		fmt.Fprint(w, "") // this is an async call, return hypermedia in the response to client
+	}
+}
+
+// findDives returns dives with the given IDs, skipping unknown IDs. The caller must hold a lock on mlog.
+func findDives(mlog *DiveLog, ids []string) DiveList {
+	dives := make(DiveList, 0, len(ids))
+	for _, id := range ids {
+		if dive := mlog.Find(id); dive != nil {
+			dives = append(dives, dive)
+		}
+	}
+	return dives
+}
+
+func parseTripFromRequest(r *http.Request, errorMap map[string]string) (trip *Trip, ok bool) {
+	ok = true
+	trip = &Trip{}
+
+	if name, errMsg := validateNonEmptyString(r.FormValue(TripNameTag)); errMsg != "" {
+		ok = false
+		errorMap[TripNameTag] = "Please provide the name of the trip."
+	} else {
+		trip.Name = name
+	}
+
+	trip.StartDate = r.FormValue(TripStartDateTag)
+	trip.EndDate = r.FormValue(TripEndDateTag)
+	if start, errMsg := validateDateInput(trip.StartDate); errMsg != "" {
+		ok = false
+		errorMap[TripStartDateTag] = "Please provide a valid start date."
+	} else if end, errMsg := validateDateInput(trip.EndDate); errMsg != "" {
+		ok = false
+		errorMap[TripEndDateTag] = "Please provide a valid end date."
+	} else if end.Before(start) {
+		ok = false
+		errorMap[TripEndDateTag] = "The trip can't end before it starts."
+	}
+
+	for _, participant := range strings.Split(r.FormValue(TripParticipantsTag), ",") {
+		if participant = strings.TrimSpace(participant); participant != "" {
+			trip.Participants = append(trip.Participants, participant)
+		}
+	}
+
+	// Optional parameters: accept even an empty value after input is trimmed.
+	trip.Operator, _ = validateNonEmptyString(r.FormValue(TripOperatorTag))
+	trip.Location, _ = validateNonEmptyString(r.FormValue(TripLocationTag))
+
+	return
+}
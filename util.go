@@ -3,6 +3,8 @@ package main
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"fmt"
+	"strings"
 )
 
 func RandHexString(n int) (string, error) {
@@ -16,18 +18,6 @@ func RandHexString(n int) (string, error) {
 	return hex.EncodeToString(bytes), nil
 }
 
-func Paginate[T any](s []T, pageNum int, pageSize int) []T {
-	if len(s) == 0 || pageNum < 0 || pageSize < 1 || pageNum*pageSize >= len(s) {
-		return nil
-	}
-	start := pageNum * pageSize
-	end := (pageNum + 1) * pageSize
-	if end > len(s) {
-		end = len(s)
-	}
-	return s[start:end]
-}
-
 func Filter[T any](s []T, predicate func(T) bool) []T {
 	filtered := make([]T, 0, len(s))
 	for _, e := range s {
@@ -37,3 +27,17 @@ func Filter[T any](s []T, predicate func(T) bool) []T {
 	}
 	return filtered
 }
+
+// UniqueSlug returns a URL-friendly identifier derived from name (e.g. "Manta Point" is "manta-point"), which is not
+// taken yet. If name has no letters or digits, fallback is used instead.
+func UniqueSlug(name string, fallback string, taken func(string) bool) string {
+	base := strings.Join(Terms(name), "-")
+	if base == "" {
+		base = fallback
+	}
+	slug := base
+	for n := 2; taken(slug); n++ {
+		slug = fmt.Sprintf("%s-%d", base, n)
+	}
+	return slug
+}
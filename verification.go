@@ -0,0 +1,186 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// SignatureContext is prepended to every signed payload, so a signature over a dive can't be passed off as a
+// signature over anything else.
+const SignatureContext = "ddhs dive verification v1"
+
+var (
+	ErrNotVerified      = errors.New("dive is not verified")
+	ErrInvalidSignature = errors.New("signature does not match the dive record, it was changed after verification")
+	ErrInvalidPublicKey = errors.New("invalid public key")
+)
+
+// Verification is an instructor's sign-off of a dive: an ed25519 signature over the canonical form of the dive
+// record (see `SignedPayload`), stored with the record.
+type Verification struct {
+	Instructor string `json:"instructor"`    // username of the instructor's account
+	Name       string `json:"name"`          // name of the instructor at the time of signing
+	Site       string `json:"site"`          // site name at the time of signing
+	Geo        string `json:"geo,omitempty"` // location at the time of signing
+	PublicKey  string `json:"public_key"`    // base64
+	SignedAt   string `json:"signed_at"`     // RFC 3339
+	Signature  string `json:"signature"`     // base64
+
+	CatalogNames // names of the trip, people and gear at the time of signing
+}
+
+// CatalogNames are names of the trip, people and gear items a dive record references, at the time of signing (see
+// `DiveLog.CatalogNames`).
+type CatalogNames struct {
+	TripID string            `json:"trip_id,omitempty"`
+	Trip   string            `json:"trip,omitempty"`
+	People map[string]string `json:"people,omitempty"` // by person ID
+	Gear   map[string]string `json:"gear,omitempty"`   // by gear item ID
+}
+
+// signedParticipant is a person on a dive, as signed off by a verification.
+type signedParticipant struct {
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+// SignedPayload returns the canonical serialisation of the record signed off by the verification: the
+// `SignatureContext`, instructor and time on separate lines, followed by the record as JSON. References to the log's
+// own catalogs (site, trip, people, gear) are meaningless outside of the log, and their names change with the catalogs,
+// so the record is signed with the names frozen in the verification instead: participants by name and role, gear items
+// and the trip by name. A reference added after signing has no frozen name, so it doesn't match the signature.
+// Attachments are left out, so photos can be added to a verified dive.
+func SignedPayload(record *DiveRecord, v *Verification) ([]byte, error) {
+	canonical := struct {
+		*DiveRecord
+		Trip   string              `json:"trip,omitempty"`
+		People []signedParticipant `json:"people,omitempty"`
+		Gear   []string            `json:"gear,omitempty"`
+	}{}
+	copied := *record
+	copied.UID, copied.SiteID, copied.TripID = "", "", ""
+	copied.Site, copied.Geo = v.Site, v.Geo
+	copied.Verification, copied.Attachments = nil, nil
+	canonical.DiveRecord = &copied
+	if record.TripID == v.TripID {
+		canonical.Trip = v.Trip
+	} else {
+		canonical.Trip = "#" + record.TripID
+	}
+	for _, participant := range record.People {
+		canonical.People = append(canonical.People, signedParticipant{frozenName(v.People, participant.PersonID), participant.Role})
+	}
+	for _, id := range record.Gear {
+		canonical.Gear = append(canonical.Gear, frozenName(v.Gear, id))
+	}
+	data, err := json.Marshal(&canonical)
+	if err != nil {
+		return nil, fmt.Errorf("dive record serialisation failed: %v", err)
+	}
+	return append([]byte(SignatureContext+"\n"+v.Instructor+"\n"+v.SignedAt+"\n"), data...), nil
+}
+
+func frozenName(names map[string]string, id string) string {
+	if name, found := names[id]; found {
+		return name
+	}
+	return "#" + id
+}
+
+// CatalogNames returns the current names of the trip, people and gear items the record references.
+func (dl *DiveLog) CatalogNames(record *DiveRecord) CatalogNames {
+	names := CatalogNames{TripID: record.TripID}
+	if trip := dl.FindTrip(record.TripID); trip != nil {
+		names.Trip = trip.Name
+	}
+	for _, participant := range record.People {
+		if person := dl.FindPerson(participant.PersonID); person != nil {
+			if names.People == nil {
+				names.People = make(map[string]string)
+			}
+			names.People[person.ID] = person.Name
+		}
+	}
+	for _, id := range record.Gear {
+		if item := dl.FindGear(id); item != nil {
+			if names.Gear == nil {
+				names.Gear = make(map[string]string)
+			}
+			names.Gear[item.ID] = item.Name
+		}
+	}
+	return names
+}
+
+// Sign returns the instructor's verification of the record, with the names of catalog entries it references.
+func Sign(record *DiveRecord, names CatalogNames, instructor *Account, now time.Time) (*Verification, error) {
+	key := instructor.privateKey()
+	if !instructor.Instructor || key == nil {
+		return nil, fmt.Errorf("%s is not an instructor", instructor.Username)
+	}
+	v := &Verification{
+		Instructor:   instructor.Username,
+		Name:         instructor.Name,
+		Site:         record.Site,
+		Geo:          record.Geo,
+		CatalogNames: names,
+		PublicKey:    base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey)),
+		SignedAt:     now.UTC().Format(time.RFC3339),
+	}
+	payload, err := SignedPayload(record, v)
+	if err != nil {
+		return nil, err
+	}
+	v.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, payload))
+	return v, nil
+}
+
+// CheckVerification verifies the record's signature against the public key stored with it. It's up to the caller to decide
+// whether the key belongs to the instructor (see `Account.PublicKey`).
+func (dr *DiveRecord) CheckVerification() error {
+	v := dr.Verification
+	if v == nil {
+		return ErrNotVerified
+	}
+	key, err := base64.StdEncoding.DecodeString(v.PublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return ErrInvalidPublicKey
+	}
+	signature, err := base64.StdEncoding.DecodeString(v.Signature)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	payload, err := SignedPayload(dr, v)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(ed25519.PublicKey(key), payload, signature) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// Verified reports whether the record is signed by an instructor, and wasn't changed since.
+func (dr *DiveRecord) Verified() bool {
+	return dr.CheckVerification() == nil
+}
+
+// FormattedSignedAt returns the date of the verification, e.g. "January 2, 2006".
+func (v *Verification) FormattedSignedAt() string {
+	t, err := time.Parse(time.RFC3339, v.SignedAt)
+	if err != nil {
+		return v.SignedAt
+	}
+	return t.Format("January 2, 2006")
+}
+
+// Verify stores the instructor's verification with the dive.
+func (dl *DiveLog) Verify(dive *Dive, v *Verification) {
+	dl.touch(dive.id)
+	dive.Data.Verification = v
+	dl.persist()
+}
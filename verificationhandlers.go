@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cicovic-andrija/libgo/logging"
+)
+
+const (
+	InstructorRoleTag = "instructor"
+
+	// MaxDiveExportSize limits the body of a verification request; profiles of long dives are the bulk of it.
+	MaxDiveExportSize = 4 << 20
+)
+
+// VerificationResult is the JSON response of the public verification endpoint.
+type VerificationResult struct {
+	Valid         bool   `json:"valid"`          // the signature matches the dive record
+	RegisteredKey bool   `json:"registered_key"` // the signing key is the instructor's key registered on this server
+	Instructor    string `json:"instructor,omitempty"`
+	Name          string `json:"name,omitempty"`
+	SignedAt      string `json:"signed_at,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+// InstructorKeyResource is the JSON representation of an instructor's public key.
+type InstructorKeyResource struct {
+	Instructor string `json:"instructor"`
+	Name       string `json:"name"`
+	PublicKey  string `json:"public_key"` // ed25519, base64
+}
+
+// HTTPS handler for an instructor's sign-off of a dive. The instructor signs off in person, on the diver's page, by
+// entering their own credentials. Failed attempts are throttled per instructor and per session of the diver, so the
+// page can't be used to guess passwords.
+func diveVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	mlog := LogOf(r)
+	mlog.Lock()
+	defer mlog.Unlock()
+
+	dive := mlog.Find(r.PathValue(IDTag))
+	if dive == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	var (
+		errorMap    = make(map[string]string)
+		username    = strings.ToLower(strings.TrimSpace(r.FormValue(UsernameTag)))
+		attemptKeys = []string{"account:" + username, "session:" + sessionToken(r)}
+		instructor  *Account
+		err         error
+		now         = time.Now()
+	)
+	if VerificationAttempts.Allowed(now, attemptKeys...) {
+		if instructor, err = Users.Authenticate(username, r.FormValue(PasswordTag)); err != nil {
+			VerificationAttempts.Fail(now, attemptKeys...)
+		}
+	} else {
+		trace(logging.SevWarn, "dive verification as %s from a session of %s throttled", username, AccountOf(r).Username)
+		err = ErrTooManyAttempts
+	}
+	switch {
+	case err == ErrTooManyAttempts:
+		errorMap[PasswordTag] = "Too many failed attempts, please try again later."
+	case err != nil:
+		errorMap[PasswordTag] = "Wrong username or password."
+	case !instructor.Instructor:
+		errorMap[UsernameTag] = "Only instructors can verify dives."
+	case instructor.Username == AccountOf(r).Username:
+		errorMap[UsernameTag] = "Dives can't be verified by their own diver."
+	}
+	if len(errorMap) == 0 {
+		var v *Verification
+		if v, err = Sign(dive.Data, mlog.CatalogNames(dive.Data), instructor, now); err != nil {
+			trace(logging.SevError, "dive verification failed: %v", err)
+			errorMap[UsernameTag] = "The dive could not be verified, please try again."
+		} else {
+			change := mlog.Begin(AccountOf(r), "Verified by "+instructor.Username)
+			mlog.Verify(dive, v)
+			change.Commit()
+			trace(logging.SevInfo, "dive %s of %s verified by %s", dive.ID(), AccountOf(r).Username, instructor.Username)
+			http.Redirect(w, r, "/dives/"+dive.ID(), http.StatusSeeOther)
+			return
+		}
+	}
+
+	page := divePage(mlog, dive)
+	page.InputErrors = errorMap
+	page.Username = r.FormValue(UsernameTag)
+	render("dive.html", w, r, page)
+}
+
+// HTTPS handler that grants or revokes the instructor role. Only admins can change roles.
+func instructorRoleHandler(w http.ResponseWriter, r *http.Request) {
+	if !AccountOf(r).Admin {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if err := Users.SetInstructor(r.PathValue(UsernameTag), r.FormValue(InstructorRoleTag) == "true"); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if err := saveAccounts(); err != nil {
+		trace(logging.SevError, "persistence of accounts failed: %v", err)
+	}
+	http.Redirect(w, r, "/club", http.StatusSeeOther)
+}
+
+// apiVerifyHandler checks a dive export (see `DiveResource`) against the signature stored with it, and the signing key
+// against the instructor's key registered on this server. It is public, so third parties can check dives.
+func apiVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	record := &DiveRecord{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxDiveExportSize)).Decode(record); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid dive record: "+err.Error())
+		return
+	}
+
+	result := &VerificationResult{}
+	if v := record.Verification; v != nil {
+		result.Instructor, result.Name, result.SignedAt = v.Instructor, v.Name, v.SignedAt
+		if account := Users.Find(v.Instructor); account != nil && account.SigningKey != "" {
+			result.RegisteredKey = base64.StdEncoding.EncodeToString(account.PublicKey()) == v.PublicKey
+		}
+	}
+	if err := record.CheckVerification(); err != nil {
+		result.Error = err.Error()
+	} else {
+		result.Valid = true
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// apiInstructorKeyHandler returns the public key of an instructor, so third parties can check it independently.
+func apiInstructorKeyHandler(w http.ResponseWriter, r *http.Request) {
+	account := Users.Find(r.PathValue(UsernameTag))
+	if account == nil || account.SigningKey == "" {
+		writeJSONError(w, http.StatusNotFound, "instructor not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, &InstructorKeyResource{
+		Instructor: account.Username,
+		Name:       account.Name,
+		PublicKey:  base64.StdEncoding.EncodeToString(account.PublicKey()),
+	})
+}